@@ -18,6 +18,9 @@ import (
 	"coupon-system/internal/models"
 	"coupon-system/internal/services"
 	"coupon-system/internal/storage/database"
+	"coupon-system/internal/storage/migrations"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -30,10 +33,9 @@ import (
 	"github.com/gin-contrib/cors"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
 func main() {
@@ -43,26 +45,30 @@ func main() {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
 
-	// Initialize Database
-	db, err := gorm.Open(sqlite.Open(cfg.DatabasePath), &gorm.Config{})
+	// Initialize Storage
+	couponStorage, err := openStorage(cfg)
 	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
+		log.Fatalf("failed to open storage: %v", err)
 	}
 
-	// Auto Migrate the schemas
-	err = db.AutoMigrate(&models.Coupon{}, &models.UserCouponUsage{}, &models.Medicine{}, &models.Category{})
+	// Initialize Cache
+	cache, err := openCache(cfg)
 	if err != nil {
-		log.Fatalf("failed to automigrate database: %v", err)
+		log.Fatalf("failed to open cache: %v", err)
+	}
+	if closer, ok := cache.(io.Closer); ok {
+		defer closer.Close()
 	}
-
-	// Initialize Cache
-	cache := caching.NewLRUCache[string, *models.ApplicableCouponsResponse](cfg.CacheSize, time.Duration(cfg.CacheTTLMinutes)*time.Second)
-
-	// Initialize Storage
-	couponStorage := database.NewSQLiteStore(db)
 
 	// Initialize Service
-	couponService := services.NewCouponService(couponStorage, cache)
+	couponService := services.NewCouponService(couponStorage, cache, time.Duration(cfg.RedemptionHoldTTLMinutes)*time.Minute, cfg.TimeZone)
+
+	// Expire stale coupon holds in the background
+	sweeperCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	couponService.StartRedemptionSweeper(sweeperCtx, time.Duration(cfg.RedemptionSweepIntervalSeconds)*time.Second)
+	// Pick up coupon changes made by other servers
+	couponService.StartCouponIndexRefresher(sweeperCtx, time.Duration(cfg.CouponIndexRefreshSeconds)*time.Second)
 
 	// Initialize Handlers
 	couponHandlers := handlers.NewCouponHandlers(couponService)
@@ -85,12 +91,25 @@ func main() {
 	adminGroup := router.Group("/admin")
 	{
 		adminGroup.POST("/coupons", middleware.AuthMiddleware(), middleware.RoleMiddleware("admin"), couponHandlers.CreateCoupon)
+		adminGroup.GET("/coupons", middleware.AuthMiddleware(), middleware.RoleMiddleware("admin"), couponHandlers.ListCoupons)
+		adminGroup.GET("/coupons/:id", middleware.AuthMiddleware(), middleware.RoleMiddleware("admin"), couponHandlers.GetCoupon)
+		adminGroup.PATCH("/coupons/:id", middleware.AuthMiddleware(), middleware.RoleMiddleware("admin"), couponHandlers.UpdateCoupon)
+		adminGroup.DELETE("/coupons/:id", middleware.AuthMiddleware(), middleware.RoleMiddleware("admin"), couponHandlers.DeleteCoupon)
+		adminGroup.GET("/exclusions", middleware.AuthMiddleware(), middleware.RoleMiddleware("admin"), couponHandlers.ListExclusions)
+		adminGroup.POST("/exclusions", middleware.AuthMiddleware(), middleware.RoleMiddleware("admin"), couponHandlers.CreateExclusion)
+		adminGroup.DELETE("/exclusions/:id", middleware.AuthMiddleware(), middleware.RoleMiddleware("admin"), couponHandlers.DeleteExclusion)
+		adminGroup.GET("/cache/stats", middleware.AuthMiddleware(), middleware.RoleMiddleware("admin"), couponHandlers.GetCacheStats)
 	}
 
 	couponsGroup := router.Group("/coupons")
 	{
 		couponsGroup.POST("/applicable", middleware.AuthMiddleware(), couponHandlers.GetApplicableCoupons)
 		couponsGroup.POST("/validate", middleware.AuthMiddleware(), couponHandlers.ValidateCoupon)
+		couponsGroup.POST("/redemptions", middleware.AuthMiddleware(), couponHandlers.CreateRedemption)
+		couponsGroup.POST("/redemptions/:id/commit", middleware.AuthMiddleware(), couponHandlers.CommitRedemption)
+		couponsGroup.POST("/redemptions/:id/release", middleware.AuthMiddleware(), couponHandlers.ReleaseRedemption)
+		couponsGroup.POST("/stack/validate", middleware.AuthMiddleware(), couponHandlers.ValidateCouponStack)
+		couponsGroup.POST("/stack/redemptions", middleware.AuthMiddleware(), couponHandlers.CreateStackRedemption)
 	}
 
 	router.POST("/generate-tokens", authHandlers.GenerateTokenHandler)
@@ -113,6 +132,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
+	stopSweeper()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -122,3 +142,45 @@ func main() {
 
 	log.Println("Server exiting")
 }
+
+// openStorage opens the storage the configuration selects, checking the schema of the database when there is one.
+func openStorage(cfg *config.Config) (database.CouponStorage, error) {
+	if cfg.StorageDriver == config.StorageDriverMemory {
+		log.Println("Using in-memory storage; coupons and redemptions are lost when the server stops")
+		return database.NewMemoryStore(), nil
+	}
+
+	// Initialize Database
+	db, err := database.Open(cfg.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database: %w", err)
+	}
+
+	// Refuse to run against a schema the migrations have not brought up to date
+	if err := migrations.Check(db); err != nil {
+		return nil, fmt.Errorf("%w; run go run ./cmd/migrate up", err)
+	}
+	return database.NewSQLStore(db), nil
+}
+
+// openCache opens the applicable coupons cache the configuration selects, checking that Redis can be reached
+// when it is used.
+func openCache(cfg *config.Config) (caching.TaggedCache[string, *models.ApplicableCouponsResponse], error) {
+	ttl := time.Duration(cfg.CacheTTLMinutes) * time.Second
+	if cfg.CacheDriver == config.CacheDriverMemory {
+		return caching.NewLRUCache[string, *models.ApplicableCouponsResponse](cfg.CacheSize, ttl), nil
+	}
+
+	options, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return caching.NewRedisCache[*models.ApplicableCouponsResponse](client, "coupons:applicable:", ttl), nil
+}
@@ -0,0 +1,97 @@
+// Command migrate applies and rolls back the schema migrations of the configured database:
+//
+//	go run ./cmd/migrate up            # apply every pending migration
+//	go run ./cmd/migrate down          # roll back the latest migration
+//	go run ./cmd/migrate -steps 2 down # roll back the latest two
+//	go run ./cmd/migrate status        # list the migrations and whether they are applied
+//
+// It migrates the database DATABASE_URL or DATABASE_PATH points at, like the server, unless -dsn is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"coupon-system/internal/config"
+	"coupon-system/internal/storage/database"
+	"coupon-system/internal/storage/migrations"
+)
+
+func main() {
+	dsn := flag.String("dsn", "", "PostgreSQL URL or SQLite path of the database to migrate, instead of the configured one")
+	steps := flag.Int("steps", 1, "number of migrations down rolls back")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: migrate [-dsn DSN] [-steps N] up|down|status\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *dsn == "" {
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			log.Fatalf("Error loading configuration: %v", err)
+		}
+		if cfg.StorageDriver == config.StorageDriverMemory {
+			log.Fatalf("the in-memory store has no schema to migrate")
+		}
+		*dsn = cfg.DatabaseDSN
+	}
+
+	db, err := database.Open(*dsn)
+	if err != nil {
+		log.Fatalf("failed to connect database: %v", err)
+	}
+
+	switch command := flag.Arg(0); command {
+	case "up":
+		applied, err := migrations.Up(db)
+		for _, migration := range applied {
+			log.Printf("Applied %d %s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("failed to migrate up: %v", err)
+		}
+		log.Printf("Database is at version %d", migrations.Latest())
+	case "down":
+		if *steps < 1 {
+			log.Fatalf("-steps must be at least 1, got %d", *steps)
+		}
+		rolledBack, err := migrations.Down(db, *steps)
+		for _, migration := range rolledBack {
+			log.Printf("Rolled back %d %s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("failed to migrate down: %v", err)
+		}
+		version, err := migrations.Version(db)
+		if err != nil {
+			log.Fatalf("failed to read schema version: %v", err)
+		}
+		log.Printf("Database is at version %d", version)
+	case "status":
+		statuses, err := migrations.GetStatus(db)
+		if err != nil {
+			log.Fatalf("failed to read migration status: %v", err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			if status.Unknown {
+				state += " (unknown to this build)"
+			}
+			fmt.Printf("%4d  %-24s %s\n", status.Version, status.Name, state)
+		}
+	default:
+		log.Printf("unknown command %q", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -8,11 +8,11 @@ import (
 
 	"coupon-system/internal/config"
 	"coupon-system/internal/models"
+	"coupon-system/internal/money"
 	"coupon-system/internal/storage/database"
+	"coupon-system/internal/storage/migrations"
 
 	"github.com/google/uuid"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
 func main() {
@@ -21,19 +21,22 @@ func main() {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
 
+	if cfg.StorageDriver == config.StorageDriverMemory {
+		log.Fatalf("the in-memory store only lives as long as the server, so it cannot be seeded")
+	}
+
 	// Initialize Database
-	db, err := gorm.Open(sqlite.Open(cfg.DatabasePath), &gorm.Config{})
+	db, err := database.Open(cfg.DatabaseDSN)
 	if err != nil {
 		log.Fatalf("failed to connect database: %v", err)
 	}
 
-	// Auto Migrate the schemas
-	err = db.AutoMigrate(&models.Coupon{}, &models.UserCouponUsage{})
-	if err != nil {
-		log.Fatalf("failed to automigrate database: %v", err)
+	// The migrate command sets up the schema
+	if err := migrations.Check(db); err != nil {
+		log.Fatalf("%v; run go run ./cmd/migrate up first", err)
 	}
 
-	couponStorage := database.NewSQLiteStore(db)
+	couponStorage := database.NewSQLStore(db)
 	if err != nil {
 		log.Fatalf("Error connecting to database: %v", err)
 	}
@@ -56,12 +59,13 @@ type mockCouponData struct {
 	CouponCode            string
 	ExpiryDate            time.Time
 	UsageType             string
-	MinOrderValue         float64
+	MinOrderValue         money.Money
 	ValidTimeWindowStart  *time.Time
 	ValidTimeWindowEnd    *time.Time
 	TermsAndConditions    string
 	DiscountType          string
-	DiscountValue         float64
+	DiscountAmount        money.Money
+	DiscountPercent       money.Percent
 	MaxUsagePerUser       int
 	MaxTotalUsage         int
 	CurrentTotalUsage     int
@@ -81,11 +85,11 @@ func seedDatabase(db database.CouponStorage) error {
 			ID:                 uuid.New().String(),
 			CouponCode:         "WELCOME10",
 			ExpiryDate:         time.Now().AddDate(0, 6, 0), // Expires in 6 months
-			UsageType:          "one_time",
-			MinOrderValue:      50.00,
+			UsageType:          "multi_use",                 // Once per user rather than once in all
+			MinOrderValue:      money.MustParse("50.00", money.DefaultCurrency),
 			TermsAndConditions: "Valid for new users on their first order.",
 			DiscountType:       "percentage",
-			DiscountValue:      10.00,
+			DiscountPercent:    money.MustParsePercent("10.00"),
 			MaxUsagePerUser:    1,
 			MaxTotalUsage:      1000,
 			CurrentTotalUsage:  0,
@@ -97,10 +101,10 @@ func seedDatabase(db database.CouponStorage) error {
 			CouponCode:         "FLAT20",
 			ExpiryDate:         time.Now().AddDate(1, 0, 0), // Expires in 1 year
 			UsageType:          "multi_use",
-			MinOrderValue:      100.00,
+			MinOrderValue:      money.MustParse("100.00", money.DefaultCurrency),
 			TermsAndConditions: "Get a flat 20 INR discount on orders above 100 INR.",
 			DiscountType:       "fixed_amount",
-			DiscountValue:      20.00,
+			DiscountAmount:     money.MustParse("20.00", money.DefaultCurrency),
 			MaxUsagePerUser:    0, // Unlimited per user
 			MaxTotalUsage:      0, // Unlimited total usage
 			CurrentTotalUsage:  0,
@@ -113,10 +117,10 @@ func seedDatabase(db database.CouponStorage) error {
 			ExpiryDate:           time.Now().AddDate(0, 3, 0), // Expires in 3 months
 			UsageType:            "multi_use",
 			ApplicableCategories: []string{"Painkillers", "Vitamins"},
-			MinOrderValue:        75.00,
+			MinOrderValue:        money.MustParse("75.00", money.DefaultCurrency),
 			TermsAndConditions:   "Valid on Painkillers and Vitamins categories.",
 			DiscountType:         "percentage",
-			DiscountValue:        15.00,
+			DiscountPercent:      money.MustParsePercent("15.00"),
 			MaxUsagePerUser:      5,
 			MaxTotalUsage:        500,
 			CurrentTotalUsage:    0,
@@ -129,10 +133,10 @@ func seedDatabase(db database.CouponStorage) error {
 			ExpiryDate:            time.Now().AddDate(0, 9, 0), // Expires in 9 months
 			UsageType:             "multi_use",
 			ApplicableMedicineIDs: []string{"med1", "med3", "med5"},
-			MinOrderValue:         150.00,
+			MinOrderValue:         money.MustParse("150.00", money.DefaultCurrency),
 			TermsAndConditions:    "Valid on specific medicines.",
 			DiscountType:          "fixed_amount",
-			DiscountValue:         30.00,
+			DiscountAmount:        money.MustParse("30.00", money.DefaultCurrency),
 			MaxUsagePerUser:       2,
 			MaxTotalUsage:         200,
 			CurrentTotalUsage:     0,
@@ -146,10 +150,10 @@ func seedDatabase(db database.CouponStorage) error {
 			UsageType:            "time_based",
 			ValidTimeWindowStart: func() *time.Time { t := time.Now().Add(24 * time.Hour); return &t }(),     // Starts tomorrow
 			ValidTimeWindowEnd:   func() *time.Time { t := time.Now().Add(7 * 24 * time.Hour); return &t }(), // Ends in a week
-			MinOrderValue:        200.00,
+			MinOrderValue:        money.MustParse("200.00", money.DefaultCurrency),
 			TermsAndConditions:   "Valid only during a specific time window.",
 			DiscountType:         "percentage",
-			DiscountValue:        25.00,
+			DiscountPercent:      money.MustParsePercent("25.00"),
 			MaxUsagePerUser:      0,
 			MaxTotalUsage:        0,
 			CurrentTotalUsage:    0,
@@ -169,10 +173,12 @@ func seedDatabase(db database.CouponStorage) error {
 			ValidTimeWindowEnd:   mockCoupon.ValidTimeWindowEnd,
 			TermsAndConditions:   mockCoupon.TermsAndConditions,
 			DiscountType:         mockCoupon.DiscountType,
-			DiscountValue:        mockCoupon.DiscountValue,
+			DiscountAmount:       mockCoupon.DiscountAmount,
+			DiscountPercent:      mockCoupon.DiscountPercent,
 			MaxUsagePerUser:      mockCoupon.MaxUsagePerUser,
 			MaxTotalUsage:        mockCoupon.MaxTotalUsage,
 			CurrentTotalUsage:    mockCoupon.CurrentTotalUsage,
+			IsActive:             true,
 			CreatedAt:            mockCoupon.CreatedAt,
 			UpdatedAt:            mockCoupon.UpdatedAt,
 		}
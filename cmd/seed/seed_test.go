@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"coupon-system/internal/caching"
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"coupon-system/internal/services"
+	"coupon-system/internal/storage/database"
+	"coupon-system/internal/storage/migrations"
+
+	"gorm.io/gorm/logger"
+)
+
+func inr(amount string) money.Money {
+	return money.MustParse(amount, money.DefaultCurrency)
+}
+
+func line(id, category, price string) models.CartItem {
+	return models.CartItem{ID: id, Category: category, Price: inr(price), Quantity: 1}
+}
+
+// seededService returns a service over a SQLite database holding the seeded coupons.
+func seededService(t *testing.T) *services.CouponService {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "coupons.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	store := database.NewSQLStore(db)
+	if err := seedDatabase(store); err != nil {
+		t.Fatalf("failed to seed database: %v", err)
+	}
+	cache := caching.NewLRUCache[string, *models.ApplicableCouponsResponse](100, time.Minute)
+	return services.NewCouponService(store, cache, time.Minute, time.UTC)
+}
+
+// The seeded CATEGORY50 and MEDBUY coupons target categories and medicines, so they only discount the lines they
+// target and do not apply to carts without them.
+func TestSeededTargetedCouponsValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         string
+		cart         []models.CartItem
+		wantReason   string         // Empty when the coupon is valid
+		wantDiscount string         // Total discount when valid
+		wantLines    map[int]string // Discount of each discounted line, by index
+	}{
+		{
+			name:         "CATEGORY50 discounts only its categories",
+			code:         "CATEGORY50",
+			cart:         []models.CartItem{line("med2", "Painkillers", "100.00"), line("med4", "Antibiotics", "100.00"), line("med6", "Vitamins", "40.00")},
+			wantDiscount: "21.00",
+			wantLines:    map[int]string{0: "15.00", 2: "6.00"},
+		},
+		{
+			name:       "CATEGORY50 does not apply without its categories",
+			code:       "CATEGORY50",
+			cart:       []models.CartItem{line("med2", "Antibiotics", "200.00")},
+			wantReason: models.ReasonNoApplicableCategories,
+		},
+		{
+			name:         "MEDBUY discounts only its medicines",
+			code:         "MEDBUY",
+			cart:         []models.CartItem{line("med1", "Antibiotics", "100.00"), line("med2", "Antibiotics", "100.00")},
+			wantDiscount: "30.00",
+			wantLines:    map[int]string{0: "30.00"},
+		},
+		{
+			name:         "MEDBUY is capped by its medicines' subtotal",
+			code:         "MEDBUY",
+			cart:         []models.CartItem{line("med2", "Antibiotics", "180.00"), line("med5", "Antibiotics", "20.00")},
+			wantDiscount: "20.00",
+			wantLines:    map[int]string{1: "20.00"},
+		},
+		{
+			name:       "MEDBUY does not apply without its medicines",
+			code:       "MEDBUY",
+			cart:       []models.CartItem{line("med2", "Painkillers", "200.00")},
+			wantReason: models.ReasonNoApplicableItems,
+		},
+	}
+
+	service := seededService(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total := inr("0.00")
+			for _, item := range tt.cart {
+				total = total.Add(item.Price)
+			}
+			response, err := service.ValidateCoupon(context.Background(), "u1", &models.ValidateCouponRequest{
+				CouponCode: tt.code,
+				CartItems:  tt.cart,
+				OrderTotal: total,
+				Timestamp:  time.Now(),
+			})
+			if err != nil {
+				t.Fatalf("ValidateCoupon failed: %v", err)
+			}
+
+			if tt.wantReason != "" {
+				if response.IsValid || response.Reason != tt.wantReason {
+					t.Fatalf("got valid %v with reason %q, want invalid with reason %q", response.IsValid, response.Reason, tt.wantReason)
+				}
+				return
+			}
+			if !response.IsValid {
+				t.Fatalf("got invalid with reason %q, want valid", response.Reason)
+			}
+			if got := response.Discount.TotalDiscount; got.Cmp(inr(tt.wantDiscount)) != 0 {
+				t.Errorf("total discount is %s, want %s", got, tt.wantDiscount)
+			}
+			for _, line := range response.Discount.Lines {
+				want, ok := tt.wantLines[line.LineIndex]
+				if !ok {
+					want = "0.00"
+				}
+				if line.Discount.Cmp(inr(want)) != 0 {
+					t.Errorf("line %d (%s) is discounted %s, want %s", line.LineIndex, line.ItemID, line.Discount, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSeededTargetedCouponsApplicable(t *testing.T) {
+	tests := []struct {
+		name      string
+		cart      []models.CartItem
+		wantCodes []string
+	}{
+		{
+			name:      "a cart without targeted lines gets only the general coupons",
+			cart:      []models.CartItem{line("med2", "Antibiotics", "200.00")},
+			wantCodes: []string{"FLAT20", "WELCOME10"},
+		},
+		{
+			name:      "a cart with a targeted category gets CATEGORY50",
+			cart:      []models.CartItem{line("med2", "Vitamins", "200.00")},
+			wantCodes: []string{"CATEGORY50", "FLAT20", "WELCOME10"},
+		},
+		{
+			name:      "a cart with a targeted medicine gets MEDBUY",
+			cart:      []models.CartItem{line("med3", "Antibiotics", "200.00")},
+			wantCodes: []string{"FLAT20", "MEDBUY", "WELCOME10"},
+		},
+		{
+			name:      "a cart with both gets both",
+			cart:      []models.CartItem{line("med5", "Painkillers", "200.00")},
+			wantCodes: []string{"CATEGORY50", "FLAT20", "MEDBUY", "WELCOME10"},
+		},
+	}
+
+	service := seededService(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := service.GetApplicableCoupons(context.Background(), "u1", &models.ApplicableCouponsRequest{
+				CartItems:  tt.cart,
+				OrderTotal: inr("200.00"),
+				Timestamp:  time.Now(),
+			})
+			if err != nil {
+				t.Fatalf("GetApplicableCoupons failed: %v", err)
+			}
+			var codes []string
+			for _, coupon := range response.ApplicableCoupons {
+				codes = append(codes, coupon.CouponCode)
+			}
+			slices.Sort(codes)
+			if !slices.Equal(codes, tt.wantCodes) {
+				t.Errorf("applicable coupons are %v, want %v", codes, tt.wantCodes)
+			}
+		})
+	}
+}
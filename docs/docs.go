@@ -15,7 +15,127 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/cache/stats": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Reports how many applicable coupons lookups were served from the cache and how many were computed, since the server started.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "coupons"
+                ],
+                "summary": "Get applicable coupons cache statistics",
+                "responses": {
+                    "200": {
+                        "description": "Cache statistics",
+                        "schema": {
+                            "$ref": "#/definitions/models.CacheStatsResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/admin/coupons": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Retrieves a page of coupons, newest first, optionally filtered by status, usage type, expiry range, medicine or category.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "coupons"
+                ],
+                "summary": "List coupons",
+                "parameters": [
+                    {
+                        "enum": [
+                            "active",
+                            "inactive",
+                            "expired"
+                        ],
+                        "type": "string",
+                        "description": "Coupon status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "one_time",
+                            "multi_use",
+                            "time_based"
+                        ],
+                        "type": "string",
+                        "description": "Usage type",
+                        "name": "usage_type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Earliest expiry date (RFC 3339)",
+                        "name": "expires_after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Latest expiry date (RFC 3339)",
+                        "name": "expires_before",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Medicine the coupon applies to",
+                        "name": "medicine_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Category the coupon applies to",
+                        "name": "category",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number, starting at 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, up to 100",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Page of coupons",
+                        "schema": {
+                            "$ref": "#/definitions/models.ListCouponsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
             "post": {
                 "security": [
                     {
@@ -57,6 +177,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
+                    "409": {
+                        "description": "Coupon code already exists",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
@@ -66,14 +192,102 @@ const docTemplate = `{
                 }
             }
         },
-        "/coupons/applicable": {
-            "post": {
+        "/admin/coupons/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Retrieves a coupon by its ID.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "coupons"
+                ],
+                "summary": "Get a coupon",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Coupon ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Coupon",
+                        "schema": {
+                            "$ref": "#/definitions/models.CouponResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Coupon not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes a coupon so it can no longer be found or applied. Its redemption history is kept.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "coupons"
+                ],
+                "summary": "Delete a coupon",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Coupon ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Coupon deleted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/models.SuccessResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Coupon not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Retrieves a list of coupons applicable to the current cart.",
+                "description": "Updates the fields present in the request and leaves the others unchanged. Set is_active to false to deactivate a coupon.",
                 "consumes": [
                     "application/json"
                 ],
@@ -83,23 +297,30 @@ const docTemplate = `{
                 "tags": [
                     "coupons"
                 ],
-                "summary": "Get applicable coupons",
+                "summary": "Update a coupon",
                 "parameters": [
                     {
-                        "description": "Cart details",
-                        "name": "request",
+                        "type": "string",
+                        "description": "Coupon ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Fields to update",
+                        "name": "coupon",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.ApplicableCouponsRequest"
+                            "$ref": "#/definitions/models.UpdateCouponRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "List of applicable coupons",
+                        "description": "Updated coupon",
                         "schema": {
-                            "$ref": "#/definitions/models.ApplicableCouponsResponse"
+                            "$ref": "#/definitions/models.CouponResponse"
                         }
                     },
                     "400": {
@@ -108,6 +329,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
+                    "404": {
+                        "description": "Coupon not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Coupon code already exists",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
@@ -117,14 +350,43 @@ const docTemplate = `{
                 }
             }
         },
-        "/coupons/validate": {
+        "/admin/exclusions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Retrieves the global list of medicines and categories that no coupon may discount.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "exclusions"
+                ],
+                "summary": "List discount exclusions",
+                "responses": {
+                    "200": {
+                        "description": "Exclusions",
+                        "schema": {
+                            "$ref": "#/definitions/models.ListExclusionsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
             "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Validates a coupon code against the provided cart details.",
+                "description": "Adds a medicine or category to the global exclusion list. Cart lines it matches are never discounted by any coupon.",
                 "consumes": [
                     "application/json"
                 ],
@@ -132,25 +394,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "coupons"
+                    "exclusions"
                 ],
-                "summary": "Validate a coupon",
+                "summary": "Add a discount exclusion",
                 "parameters": [
                     {
-                        "description": "Validation request",
-                        "name": "request",
+                        "description": "Exclusion",
+                        "name": "exclusion",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.ValidateCouponRequest"
+                            "$ref": "#/definitions/models.CreateExclusionRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "Coupon validation result",
+                    "201": {
+                        "description": "Exclusion created",
                         "schema": {
-                            "$ref": "#/definitions/models.ValidateCouponResponse"
+                            "$ref": "#/definitions/models.ExclusionResponse"
                         }
                     },
                     "400": {
@@ -159,6 +421,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
+                    "409": {
+                        "description": "Item already excluded",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
@@ -168,9 +436,60 @@ const docTemplate = `{
                 }
             }
         },
-        "/generate-tokens": {
+        "/admin/exclusions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes an entry from the global exclusion list so coupons may discount the item again.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "exclusions"
+                ],
+                "summary": "Remove a discount exclusion",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Exclusion ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Exclusion deleted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/models.SuccessResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Exclusion not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/coupons/applicable": {
             "post": {
-                "description": "Generates a JSON Web Token for a given user ID and role.",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Retrieves a list of coupons applicable to the current cart, optionally ranked by savings, along with the coupon or combination of stackable coupons that saves the most and the coupons a larger order would unlock.",
                 "consumes": [
                     "application/json"
                 ],
@@ -178,25 +497,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "auth"
+                    "coupons"
                 ],
-                "summary": "Generate a JWT",
+                "summary": "Get applicable coupons",
                 "parameters": [
                     {
-                        "description": "User ID and Role",
+                        "description": "Cart details",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.GenerateTokenRequest"
+                            "$ref": "#/definitions/models.ApplicableCouponsRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "JWT generated successfully",
+                        "description": "List of applicable coupons",
                         "schema": {
-                            "$ref": "#/definitions/handlers.GenerateTokenResponse"
+                            "$ref": "#/definitions/models.ApplicableCouponsResponse"
                         }
                     },
                     "400": {
@@ -213,147 +532,1371 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "handlers.GenerateTokenRequest": {
-            "type": "object",
-            "required": [
-                "role",
-                "user_id"
-            ],
-            "properties": {
-                "role": {
-                    "type": "string",
-                    "enum": [
-                        "admin",
+        },
+        "/coupons/redemptions": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Validates a coupon against the cart and holds one use of it for the order until the hold is committed, released or expires. A retry for the same order returns the hold already placed, with the discount it was placed with.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "redemptions"
+                ],
+                "summary": "Hold a coupon for an order",
+                "parameters": [
+                    {
+                        "description": "Redemption request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.CreateRedemptionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Coupon held",
+                        "schema": {
+                            "$ref": "#/definitions/models.RedemptionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Coupon not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Coupon not applicable or usage limit reached",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/coupons/redemptions/{id}/commit": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Confirms a held coupon once checkout succeeds.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "redemptions"
+                ],
+                "summary": "Commit a coupon hold",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Redemption ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Redemption committed",
+                        "schema": {
+                            "$ref": "#/definitions/models.RedemptionResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Redemption not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Redemption is no longer held",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/coupons/redemptions/{id}/release": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Gives a held coupon back when checkout is abandoned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "redemptions"
+                ],
+                "summary": "Release a coupon hold",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Redemption ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Redemption released",
+                        "schema": {
+                            "$ref": "#/definitions/models.RedemptionResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Redemption not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Redemption is no longer held",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/coupons/stack/redemptions": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Validates several coupon codes used together and holds one use of every coupon that applies for the order. Each hold is committed or released on its own. Coupons already held for the order return their existing hold.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "redemptions"
+                ],
+                "summary": "Hold a combination of coupons for an order",
+                "parameters": [
+                    {
+                        "description": "Stack redemption request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.CreateStackRedemptionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Coupons held",
+                        "schema": {
+                            "$ref": "#/definitions/models.StackRedemptionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "No coupon applicable or usage limit reached",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/coupons/stack/validate": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Validates several coupon codes used together against the provided cart. Coupons are applied from the highest priority down, each on what the ones before it left of the order, and codes that do not apply or conflict with an applied coupon are reported with the reason.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "coupons"
+                ],
+                "summary": "Validate a combination of coupons",
+                "parameters": [
+                    {
+                        "description": "Stack validation request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.ValidateCouponStackRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Stack validation result",
+                        "schema": {
+                            "$ref": "#/definitions/models.ValidateCouponStackResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/coupons/validate": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Validates a coupon code against the provided cart details.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "coupons"
+                ],
+                "summary": "Validate a coupon",
+                "parameters": [
+                    {
+                        "description": "Validation request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.ValidateCouponRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Coupon validation result",
+                        "schema": {
+                            "$ref": "#/definitions/models.ValidateCouponResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/generate-tokens": {
+            "post": {
+                "description": "Generates a JSON Web Token for a given user ID and role.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Generate a JWT",
+                "parameters": [
+                    {
+                        "description": "User ID and Role",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.GenerateTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "JWT generated successfully",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.GenerateTokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "handlers.GenerateTokenRequest": {
+            "type": "object",
+            "required": [
+                "role",
+                "user_id"
+            ],
+            "properties": {
+                "role": {
+                    "type": "string",
+                    "enum": [
+                        "admin",
                         "user"
                     ]
                 },
-                "user_id": {
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.GenerateTokenResponse": {
+            "type": "object",
+            "properties": {
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ApplicableCoupon": {
+            "description": "ApplicableCoupon represents a coupon that is applicable to the current cart.",
+            "type": "object",
+            "properties": {
+                "capped_by": {
+                    "description": "Set when the discount was reduced by a cap: \"max_discount_amount\" or \"eligible_amount\"",
+                    "type": "string"
+                },
+                "coupon_code": {
+                    "type": "string"
+                },
+                "discount": {
+                    "type": "number"
+                },
+                "discount_type": {
+                    "type": "string"
+                },
+                "discount_value": {
+                    "type": "number"
+                },
+                "next_tier": {
+                    "description": "For tiered coupons, the next tier up, if any",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.NextDiscountTier"
+                        }
+                    ]
+                },
+                "rank": {
+                    "description": "Position by savings, from 1, when the coupons are sorted by savings",
+                    "type": "integer"
+                },
+                "tier_reached": {
+                    "description": "For tiered coupons, the tier the order reaches",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.DiscountTier"
+                        }
+                    ]
+                }
+            }
+        },
+        "models.ApplicableCouponsRequest": {
+            "description": "ApplicableCouponsRequest represents the request to find applicable coupons for a cart",
+            "type": "object",
+            "required": [
+                "cart_items",
+                "order_total",
+                "timestamp"
+            ],
+            "properties": {
+                "cart_items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CartItem"
+                    }
+                },
+                "channel": {
+                    "description": "Channel the request comes from, e.g. app or web, for eligibility rules",
+                    "type": "string"
+                },
+                "order_total": {
+                    "type": "number"
+                },
+                "sort": {
+                    "description": "\"savings\" ranks the coupons by the discount they give this cart, largest first",
+                    "type": "string",
+                    "enum": [
+                        "savings"
+                    ]
+                },
+                "timestamp": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ApplicableCouponsResponse": {
+            "description": "ApplicableCouponsResponse represents the response body for applicable coupons.",
+            "type": "object",
+            "properties": {
+                "applicable_coupons": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ApplicableCoupon"
+                    }
+                },
+                "best": {
+                    "description": "Omitted when no coupon applies",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.BestCoupon"
+                        }
+                    ]
+                },
+                "near_misses": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.NearMissCoupon"
+                    }
+                }
+            }
+        },
+        "models.AppliedCoupon": {
+            "description": "AppliedCoupon represents one coupon of a stack and the discount it gives on what earlier coupons left over.",
+            "type": "object",
+            "properties": {
+                "capped_by": {
+                    "type": "string"
+                },
+                "coupon_code": {
+                    "type": "string"
+                },
+                "discount": {
+                    "type": "number"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.LineDiscount"
+                    }
+                },
+                "priority": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BestCoupon": {
+            "description": "BestCoupon names the coupon, or combination of stackable coupons, that saves the most on the cart.",
+            "type": "object",
+            "properties": {
+                "coupon_codes": {
+                    "description": "In the order the coupons are applied",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "discount": {
+                    "type": "number"
+                },
+                "stacked": {
+                    "description": "True when the best choice combines several coupons",
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.CacheStatsResponse": {
+            "description": "CacheStatsResponse reports how often applicable coupons results were served from the cache.",
+            "type": "object",
+            "properties": {
+                "hit_rate": {
+                    "description": "Share of lookups that were hits, 0 before the first lookup",
+                    "type": "number",
+                    "example": 0.8
+                },
+                "hits": {
+                    "type": "integer",
+                    "example": 120
+                },
+                "misses": {
+                    "type": "integer",
+                    "example": 30
+                }
+            }
+        },
+        "models.CartItem": {
+            "description": "CartItem holds cart items",
+            "type": "object",
+            "properties": {
+                "category": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "price": {
+                    "description": "Unit price",
+                    "type": "number"
+                },
+                "quantity": {
+                    "description": "Number of units, treated as 1 when omitted",
+                    "type": "integer",
+                    "minimum": 0
+                }
+            }
+        },
+        "models.CouponResponse": {
+            "description": "CouponResponse represents a coupon as seen by admins.",
+            "type": "object",
+            "properties": {
+                "applicable_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "applicable_medicine_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "buy_quantity": {
+                    "description": "Only set for buy_x_get_y coupons, like the trigger and reward sets",
+                    "type": "integer"
+                },
+                "coupon_code": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "current_total_usage": {
+                    "type": "integer"
+                },
+                "discount_type": {
+                    "type": "string"
+                },
+                "discount_value": {
+                    "type": "number"
+                },
+                "eligibility_rule": {
+                    "type": "string"
+                },
+                "excluded_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "excluded_medicine_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "exclusivity_group": {
+                    "type": "string"
+                },
+                "expiry_date": {
+                    "type": "string"
+                },
+                "get_quantity": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "is_active": {
+                    "type": "boolean"
+                },
+                "max_discount_amount": {
+                    "description": "0 when the discount is not capped",
+                    "type": "number"
+                },
+                "max_total_usage": {
+                    "type": "integer"
+                },
+                "max_usage_per_user": {
+                    "type": "integer"
+                },
+                "min_order_value": {
+                    "type": "number"
+                },
+                "priority": {
+                    "type": "integer"
+                },
+                "recurring_window": {
+                    "description": "Set when the coupon is limited to repeating periods of local time",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.RecurringWindow"
+                        }
+                    ]
+                },
+                "reward_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reward_medicine_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "stackable": {
+                    "type": "boolean"
+                },
+                "terms_and_conditions": {
+                    "type": "string"
+                },
+                "tiers": {
+                    "description": "Only set for tiered coupons",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.DiscountTier"
+                    }
+                },
+                "trigger_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "trigger_medicine_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "usage_type": {
+                    "type": "string"
+                },
+                "valid_time_window_end": {
+                    "type": "string"
+                },
+                "valid_time_window_start": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CreateCouponRequest": {
+            "description": "CreateCouponRequest represents the request to create a new coupon",
+            "type": "object",
+            "required": [
+                "coupon_code",
+                "discount_type",
+                "expiry_date",
+                "usage_type"
+            ],
+            "properties": {
+                "applicable_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "applicable_medicine_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "buy_quantity": {
+                    "description": "BuyQuantity and GetQuantity make a buy_x_get_y coupon give GetQuantity reward units for every BuyQuantity\ntrigger units, e.g. 2 and 1 for \"buy 2, get 1 free\"",
+                    "type": "integer"
+                },
+                "coupon_code": {
+                    "type": "string"
+                },
+                "discount_type": {
+                    "description": "DiscountType is the type of discount (percentage, fixed_amount, buy_x_get_y or tiered)",
+                    "type": "string",
+                    "enum": [
+                        "percentage",
+                        "fixed_amount",
+                        "buy_x_get_y",
+                        "tiered"
+                    ]
+                },
+                "discount_value": {
+                    "description": "DiscountValue is the percentage off for percentage coupons, the amount off for fixed_amount coupons, or the\npercentage off the reward units for buy_x_get_y coupons (100 makes them free). Tiered coupons use Tiers instead",
+                    "type": "number"
+                },
+                "eligibility_rule": {
+                    "description": "EligibilityRule is an extra condition on the cart and request, e.g. \"category = Vitamins AND quantity \u003e= 3 AND\nchannel = app\". It is checked when the coupon is saved; see the README for the variables it can use",
+                    "type": "string"
+                },
+                "excluded_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "excluded_medicine_ids": {
+                    "description": "Excluded medicines and categories are never discounted by the coupon, on top of the global exclusions",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "exclusivity_group": {
+                    "type": "string"
+                },
+                "expiry_date": {
+                    "type": "string"
+                },
+                "get_quantity": {
+                    "type": "integer"
+                },
+                "max_discount_amount": {
+                    "description": "MaxDiscountAmount caps the discount the coupon can give, e.g. \"20% off up to 100\". Omit or set to 0 for no cap",
+                    "type": "number"
+                },
+                "max_total_usage": {
+                    "type": "integer"
+                },
+                "max_usage_per_user": {
+                    "type": "integer"
+                },
+                "min_order_value": {
+                    "type": "number"
+                },
+                "priority": {
+                    "type": "integer"
+                },
+                "recurring_window": {
+                    "description": "RecurringWindow limits the coupon to repeating periods of local time, on top of the valid time window",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.RecurringWindow"
+                        }
+                    ]
+                },
+                "reward_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reward_medicine_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "stackable": {
+                    "description": "Stackable, ExclusivityGroup and Priority control how the coupon combines with others; see POST /coupons/stack/validate",
+                    "type": "boolean"
+                },
+                "terms_and_conditions": {
+                    "type": "string"
+                },
+                "tiers": {
+                    "description": "Tiers are the spend thresholds of a tiered coupon and the discount each unlocks. The lowest threshold\nbecomes the coupon's minimum order value",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.DiscountTier"
+                    }
+                },
+                "trigger_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "trigger_medicine_ids": {
+                    "description": "The trigger set holds the items that count towards the units to buy, any item when empty. The reward set\nholds the items that can be given as the reward, the trigger set when empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "usage_type": {
+                    "type": "string",
+                    "enum": [
+                        "one_time",
+                        "multi_use",
+                        "time_based"
+                    ]
+                },
+                "valid_time_window_end": {
+                    "type": "string"
+                },
+                "valid_time_window_start": {
                     "type": "string"
                 }
             }
         },
-        "handlers.GenerateTokenResponse": {
+        "models.CreateExclusionRequest": {
+            "description": "CreateExclusionRequest represents the request to keep a medicine or category out of every discount.",
             "type": "object",
+            "required": [
+                "item_id",
+                "kind"
+            ],
             "properties": {
-                "token": {
+                "item_id": {
+                    "description": "Medicine or category ID",
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string",
+                    "enum": [
+                        "medicine",
+                        "category"
+                    ]
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CreateRedemptionRequest": {
+            "description": "CreateRedemptionRequest represents the request body for holding a coupon against an order. The coupon is checked against the server's current time, not the request's timestamp.",
+            "type": "object",
+            "required": [
+                "cart_items",
+                "coupon_code",
+                "order_id",
+                "order_total",
+                "timestamp"
+            ],
+            "properties": {
+                "cart_items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CartItem"
+                    }
+                },
+                "channel": {
+                    "description": "Channel the request comes from, e.g. app or web, for eligibility rules",
+                    "type": "string"
+                },
+                "collect_all_failures": {
+                    "description": "Run every check and report all failures instead of stopping at the first",
+                    "type": "boolean"
+                },
+                "coupon_code": {
+                    "type": "string"
+                },
+                "order_id": {
+                    "type": "string"
+                },
+                "order_total": {
+                    "type": "number"
+                },
+                "timestamp": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CreateStackRedemptionRequest": {
+            "description": "CreateStackRedemptionRequest represents the request body for holding several coupons against an order. The coupons are checked against the server's current time, not the request's timestamp.",
+            "type": "object",
+            "required": [
+                "cart_items",
+                "coupon_codes",
+                "order_id",
+                "order_total",
+                "timestamp"
+            ],
+            "properties": {
+                "cart_items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CartItem"
+                    }
+                },
+                "channel": {
+                    "description": "Channel the request comes from, e.g. app or web, for eligibility rules",
+                    "type": "string"
+                },
+                "coupon_codes": {
+                    "type": "array",
+                    "maxItems": 10,
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "order_id": {
+                    "type": "string"
+                },
+                "order_total": {
+                    "type": "number"
+                },
+                "timestamp": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.DiscountDetails": {
+            "description": "DiscountDetails represents the details of the discount applied by a coupon.",
+            "type": "object",
+            "properties": {
+                "capped_by": {
+                    "description": "Set when the discount was reduced by a cap: \"max_discount_amount\" or \"eligible_amount\"",
+                    "type": "string"
+                },
+                "items_discount": {
+                    "type": "number"
+                },
+                "lines": {
+                    "description": "How the discount is split across the cart lines",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.LineDiscount"
+                    }
+                },
+                "total_discount": {
+                    "type": "number"
+                }
+            }
+        },
+        "models.DiscountTier": {
+            "description": "DiscountTier represents one spend threshold of a tiered coupon and the discount an order reaching it gets.",
+            "type": "object",
+            "required": [
+                "discount_type",
+                "discount_value",
+                "min_order_value"
+            ],
+            "properties": {
+                "discount_type": {
+                    "type": "string",
+                    "enum": [
+                        "percentage",
+                        "fixed_amount"
+                    ]
+                },
+                "discount_value": {
+                    "type": "number"
+                },
+                "min_order_value": {
+                    "type": "number"
+                }
+            }
+        },
+        "models.ErrorResponse": {
+            "description": "ErrorResponse represents a generic error response with an error message and details.",
+            "type": "object",
+            "properties": {
+                "details": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ExclusionResponse": {
+            "description": "ExclusionResponse represents a medicine or category that no coupon discounts.",
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "item_id": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.LineDiscount": {
+            "description": "LineDiscount represents the part of a discount allocated to one cart line.",
+            "type": "object",
+            "properties": {
+                "category": {
+                    "type": "string"
+                },
+                "discount": {
+                    "type": "number"
+                },
+                "id": {
                     "type": "string"
+                },
+                "line_index": {
+                    "description": "Position of the line in cart_items",
+                    "type": "integer"
+                },
+                "line_total": {
+                    "description": "Price multiplied by quantity",
+                    "type": "number"
+                },
+                "quantity": {
+                    "type": "integer"
+                },
+                "reward_units": {
+                    "description": "RewardUnits is how many units of the line a buy_x_get_y coupon gives as the reward, at its discount\npercentage off; they are free when the percentage is 100",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ListCouponsResponse": {
+            "description": "ListCouponsResponse represents one page of coupons.",
+            "type": "object",
+            "properties": {
+                "coupons": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CouponResponse"
+                    }
+                },
+                "page": {
+                    "type": "integer"
+                },
+                "page_size": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ListExclusionsResponse": {
+            "description": "ListExclusionsResponse represents the global exclusion list.",
+            "type": "object",
+            "properties": {
+                "exclusions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ExclusionResponse"
+                    }
                 }
             }
         },
-        "models.ApplicableCoupon": {
-            "description": "ApplicableCoupon represents a coupon that is applicable to the current cart.",
+        "models.NearMissCoupon": {
+            "description": "NearMissCoupon represents a coupon the cart would unlock by reaching the coupon's minimum order value.",
             "type": "object",
             "properties": {
+                "amount_needed": {
+                    "description": "How much more the order needs to reach the minimum order value",
+                    "type": "number"
+                },
                 "coupon_code": {
                     "type": "string"
                 },
-                "discount": {
-                    "type": "number"
-                },
                 "discount_type": {
                     "type": "string"
                 },
                 "discount_value": {
                     "type": "number"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "min_order_value": {
+                    "type": "number"
                 }
             }
         },
-        "models.ApplicableCouponsRequest": {
-            "description": "ApplicableCouponsRequest represents the request to find applicable coupons for a cart",
+        "models.NextDiscountTier": {
+            "description": "NextDiscountTier represents the next tier a tiered coupon would reach and how much more the order needs to reach it.",
             "type": "object",
             "required": [
-                "cart_items",
-                "order_total",
-                "timestamp"
+                "discount_type",
+                "discount_value",
+                "min_order_value"
             ],
             "properties": {
-                "cart_items": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/models.CartItem"
-                    }
+                "amount_needed": {
+                    "type": "number"
                 },
-                "order_total": {
+                "discount_type": {
+                    "type": "string",
+                    "enum": [
+                        "percentage",
+                        "fixed_amount"
+                    ]
+                },
+                "discount_value": {
                     "type": "number"
                 },
-                "timestamp": {
-                    "type": "string"
+                "min_order_value": {
+                    "type": "number"
                 }
             }
         },
-        "models.ApplicableCouponsResponse": {
-            "description": "ApplicableCouponsResponse represents the response body for applicable coupons.",
+        "models.RecurringWindow": {
+            "description": "RecurringWindow limits a coupon to repeating periods of local time, e.g. weekdays from 18:00 to 21:00 or the weekend of the first week of the month.",
             "type": "object",
             "properties": {
-                "applicable_coupons": {
+                "days": {
+                    "description": "Weekdays the coupon is valid on, every day when empty",
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/models.ApplicableCoupon"
+                        "type": "string"
+                    }
+                },
+                "end_time": {
+                    "description": "Local time of day it stops being valid; earlier than start_time for periods past midnight",
+                    "type": "string",
+                    "example": "21:00"
+                },
+                "start_time": {
+                    "description": "Local time of day the coupon becomes valid, the whole day when omitted with end_time",
+                    "type": "string",
+                    "example": "18:00"
+                },
+                "weeks_of_month": {
+                    "description": "Calendar weeks of the month, from Monday to Sunday, week 1 holding the 1st, e.g. [1] with saturday and sunday for the weekend of week 1; every week when empty",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
                     }
                 }
             }
         },
-        "models.CartItem": {
-            "description": "CartItem holds cart items",
+        "models.RedemptionResponse": {
+            "description": "RedemptionResponse represents a coupon hold and its current status.",
             "type": "object",
             "properties": {
-                "category": {
+                "coupon_code": {
                     "type": "string"
                 },
-                "id": {
+                "discount": {
+                    "$ref": "#/definitions/models.DiscountDetails"
+                },
+                "expires_at": {
                     "type": "string"
                 },
-                "price": {
-                    "type": "number"
+                "order_id": {
+                    "type": "string"
                 },
-                "quantity": {
-                    "type": "integer"
+                "redemption_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
                 }
             }
         },
-        "models.CreateCouponRequest": {
-            "description": "CreateCouponRequest represents the request to create a new coupon",
+        "models.RejectedCoupon": {
+            "description": "RejectedCoupon represents a coupon that was left out of a stack and why.",
+            "type": "object",
+            "properties": {
+                "conflicts_with": {
+                    "description": "The applied coupon it conflicts with, for \"conflict\"",
+                    "type": "string"
+                },
+                "coupon_code": {
+                    "type": "string"
+                },
+                "failure": {
+                    "description": "Why validation failed, for \"not_applicable\"",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.ValidationFailure"
+                        }
+                    ]
+                },
+                "message": {
+                    "type": "string"
+                },
+                "reason": {
+                    "description": "\"not_found\", \"not_applicable\", \"duplicate\" or \"conflict\"",
+                    "type": "string"
+                }
+            }
+        },
+        "models.StackRedemptionResponse": {
+            "description": "StackRedemptionResponse represents the holds placed for the applied coupons of a stack.",
+            "type": "object",
+            "properties": {
+                "discount": {
+                    "description": "Combined discount of the held coupons",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.DiscountDetails"
+                        }
+                    ]
+                },
+                "redemptions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.RedemptionResponse"
+                    }
+                },
+                "rejected_coupons": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.RejectedCoupon"
+                    }
+                }
+            }
+        },
+        "models.SuccessResponse": {
+            "description": "SuccessResponse represents a generic success response with a message.",
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UpdateCouponRequest": {
+            "description": "UpdateCouponRequest represents the request to update an existing coupon. Omitted fields are left unchanged.",
             "type": "object",
-            "required": [
-                "coupon_code",
-                "discount_type",
-                "discount_value",
-                "expiry_date",
-                "usage_type"
-            ],
             "properties": {
                 "applicable_categories": {
+                    "description": "Replaces the coupon's categories when present",
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
                 "applicable_medicine_ids": {
+                    "description": "Replaces the coupon's medicines when present",
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
+                "buy_quantity": {
+                    "type": "integer"
+                },
                 "coupon_code": {
                     "type": "string"
                 },
                 "discount_type": {
-                    "description": "DiscountType is the type of discount (percentage or fixed_amount)",
                     "type": "string",
                     "enum": [
                         "percentage",
-                        "fixed_amount"
+                        "fixed_amount",
+                        "buy_x_get_y",
+                        "tiered"
                     ]
                 },
                 "discount_value": {
                     "type": "number"
                 },
+                "eligibility_rule": {
+                    "description": "Set to \"\" to remove the rule",
+                    "type": "string"
+                },
+                "excluded_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "excluded_medicine_ids": {
+                    "description": "Replaces the coupon's excluded medicines when present",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "exclusivity_group": {
+                    "description": "Set to \"\" to remove the coupon from its group",
+                    "type": "string"
+                },
                 "expiry_date": {
                     "type": "string"
                 },
+                "get_quantity": {
+                    "type": "integer"
+                },
+                "is_active": {
+                    "description": "Set to false to deactivate the coupon",
+                    "type": "boolean"
+                },
+                "max_discount_amount": {
+                    "description": "Set to 0 to remove the cap",
+                    "type": "number"
+                },
                 "max_total_usage": {
                     "type": "integer"
                 },
@@ -363,9 +1906,56 @@ const docTemplate = `{
                 "min_order_value": {
                     "type": "number"
                 },
+                "priority": {
+                    "type": "integer"
+                },
+                "recurring_window": {
+                    "description": "Replaces the coupon's recurring window when present; {} removes it",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.RecurringWindow"
+                        }
+                    ]
+                },
+                "reward_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reward_medicine_ids": {
+                    "description": "Replaces the coupon's reward medicines when present",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "stackable": {
+                    "type": "boolean"
+                },
                 "terms_and_conditions": {
                     "type": "string"
                 },
+                "tiers": {
+                    "description": "Replaces the coupon's tiers when present",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.DiscountTier"
+                    }
+                },
+                "trigger_categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "trigger_medicine_ids": {
+                    "description": "Replaces the coupon's trigger medicines when present",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "usage_type": {
                     "type": "string",
                     "enum": [
@@ -382,45 +1972,73 @@ const docTemplate = `{
                 }
             }
         },
-        "models.DiscountDetails": {
-            "description": "DiscountDetails represents the details of the discount applied by a coupon.",
+        "models.ValidateCouponRequest": {
+            "description": "ValidateCouponRequest represents the request body for validating a coupon.",
             "type": "object",
+            "required": [
+                "cart_items",
+                "coupon_code",
+                "order_total",
+                "timestamp"
+            ],
             "properties": {
-                "items_discount": {
-                    "type": "number"
+                "cart_items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CartItem"
+                    }
                 },
-                "total_discount": {
-                    "type": "number"
-                }
-            }
-        },
-        "models.ErrorResponse": {
-            "description": "ErrorResponse represents a generic error response with an error message and details.",
-            "type": "object",
-            "properties": {
-                "details": {
+                "channel": {
+                    "description": "Channel the request comes from, e.g. app or web, for eligibility rules",
                     "type": "string"
                 },
-                "error": {
+                "collect_all_failures": {
+                    "description": "Run every check and report all failures instead of stopping at the first",
+                    "type": "boolean"
+                },
+                "coupon_code": {
+                    "type": "string"
+                },
+                "order_total": {
+                    "type": "number"
+                },
+                "timestamp": {
                     "type": "string"
                 }
             }
         },
-        "models.SuccessResponse": {
-            "description": "SuccessResponse represents a generic success response with a message.",
+        "models.ValidateCouponResponse": {
+            "description": "ValidateCouponResponse represents the response body for validating a coupon.",
             "type": "object",
             "properties": {
+                "discount": {
+                    "$ref": "#/definitions/models.DiscountDetails"
+                },
+                "failures": {
+                    "description": "The first failure, or every failure when collect_all_failures is set",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ValidationFailure"
+                    }
+                },
+                "is_valid": {
+                    "type": "boolean"
+                },
                 "message": {
                     "type": "string"
+                },
+                "reason": {
+                    "description": "Reason code of the first failure, e.g. \"EXPIRED\"",
+                    "type": "string"
                 }
             }
         },
-        "models.ValidateCouponRequest": {
-            "description": "ValidateCouponRequest represents the request body for validating a coupon.",
+        "models.ValidateCouponStackRequest": {
+            "description": "ValidateCouponStackRequest represents the request body for validating several coupons used together.",
             "type": "object",
             "required": [
                 "cart_items",
-                "coupon_code",
+                "coupon_codes",
                 "order_total",
                 "timestamp"
             ],
@@ -431,9 +2049,18 @@ const docTemplate = `{
                         "$ref": "#/definitions/models.CartItem"
                     }
                 },
-                "coupon_code": {
+                "channel": {
+                    "description": "Channel the request comes from, e.g. app or web, for eligibility rules",
                     "type": "string"
                 },
+                "coupon_codes": {
+                    "type": "array",
+                    "maxItems": 10,
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "order_total": {
                     "type": "number"
                 },
@@ -442,18 +2069,49 @@ const docTemplate = `{
                 }
             }
         },
-        "models.ValidateCouponResponse": {
-            "description": "ValidateCouponResponse represents the response body for validating a coupon.",
+        "models.ValidateCouponStackResponse": {
+            "description": "ValidateCouponStackResponse represents the coupons of a stack that apply to the cart, in the order they are applied, and the ones that do not.",
             "type": "object",
             "properties": {
+                "applied_coupons": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.AppliedCoupon"
+                    }
+                },
                 "discount": {
-                    "$ref": "#/definitions/models.DiscountDetails"
+                    "description": "Combined discount of the applied coupons",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.DiscountDetails"
+                        }
+                    ]
                 },
                 "is_valid": {
+                    "description": "True when at least one coupon applies",
                     "type": "boolean"
                 },
+                "rejected_coupons": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.RejectedCoupon"
+                    }
+                }
+            }
+        },
+        "models.ValidationFailure": {
+            "description": "ValidationFailure represents one check a coupon fails, with a stable reason code and the values that explain it.",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
                 "message": {
                     "type": "string"
+                },
+                "params": {
+                    "type": "object",
+                    "additionalProperties": {}
                 }
             }
         }
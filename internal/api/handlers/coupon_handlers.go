@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"coupon-system/internal/models"
 	"coupon-system/internal/services"
+	"coupon-system/internal/storage/database"
 )
 
 // CouponHandlers defines the handlers for coupon-related API endpoints.
@@ -33,6 +35,7 @@ func NewCouponHandlers(couponService *services.CouponService) *CouponHandlers {
 //	@Param			coupon	body		models.CreateCouponRequest	true	"Coupon Data"
 //	@Success		201		{object}	models.SuccessResponse		"Coupon created successfully"
 //	@Failure		400		{object}	models.ErrorResponse		"Bad request"
+//	@Failure		409		{object}	models.ErrorResponse		"Coupon code already exists"
 //	@Failure		500		{object}	models.ErrorResponse		"Internal server error"
 //	@Router			/admin/coupons [post]
 func (h *CouponHandlers) CreateCoupon(c *gin.Context) {
@@ -43,19 +46,133 @@ func (h *CouponHandlers) CreateCoupon(c *gin.Context) {
 	}
 
 	if err := h.couponService.CreateCoupon(c.Request.Context(), &req); err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to create coupon", Details: err.Error()})
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to create coupon", Details: err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusCreated, models.SuccessResponse{Message: "Coupon created successfully"})
 }
 
+// GetCoupon retrieves a coupon by ID.
+// GetCoupon godoc
+//
+//	@Summary		Get a coupon
+//	@Security		BearerAuth
+//	@Description	Retrieves a coupon by its ID.
+//	@Tags			coupons
+//	@Produce		json
+//	@Param			id	path		string					true	"Coupon ID"
+//	@Success		200	{object}	models.CouponResponse	"Coupon"
+//	@Failure		404	{object}	models.ErrorResponse	"Coupon not found"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal server error"
+//	@Router			/admin/coupons/{id} [get]
+func (h *CouponHandlers) GetCoupon(c *gin.Context) {
+	coupon, err := h.couponService.GetCoupon(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to get coupon", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+// ListCoupons retrieves a page of coupons.
+// ListCoupons godoc
+//
+//	@Summary		List coupons
+//	@Security		BearerAuth
+//	@Description	Retrieves a page of coupons, newest first, optionally filtered by status, usage type, expiry range, medicine or category.
+//	@Tags			coupons
+//	@Produce		json
+//	@Param			status			query		string						false	"Coupon status"	Enums(active, inactive, expired)
+//	@Param			usage_type		query		string						false	"Usage type"	Enums(one_time, multi_use, time_based)
+//	@Param			expires_after	query		string						false	"Earliest expiry date (RFC 3339)"
+//	@Param			expires_before	query		string						false	"Latest expiry date (RFC 3339)"
+//	@Param			medicine_id		query		string						false	"Medicine the coupon applies to"
+//	@Param			category		query		string						false	"Category the coupon applies to"
+//	@Param			page			query		int							false	"Page number, starting at 1"
+//	@Param			page_size		query		int							false	"Page size, up to 100"
+//	@Success		200				{object}	models.ListCouponsResponse	"Page of coupons"
+//	@Failure		400				{object}	models.ErrorResponse		"Bad request"
+//	@Failure		500				{object}	models.ErrorResponse		"Internal server error"
+//	@Router			/admin/coupons [get]
+func (h *CouponHandlers) ListCoupons(c *gin.Context) {
+	var req models.ListCouponsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	coupons, err := h.couponService.ListCoupons(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to list coupons", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupons)
+}
+
+// UpdateCoupon updates an existing coupon.
+// UpdateCoupon godoc
+//
+//	@Summary		Update a coupon
+//	@Security		BearerAuth
+//	@Description	Updates the fields present in the request and leaves the others unchanged. Set is_active to false to deactivate a coupon.
+//	@Tags			coupons
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string						true	"Coupon ID"
+//	@Param			coupon	body		models.UpdateCouponRequest	true	"Fields to update"
+//	@Success		200		{object}	models.CouponResponse		"Updated coupon"
+//	@Failure		400		{object}	models.ErrorResponse		"Bad request"
+//	@Failure		404		{object}	models.ErrorResponse		"Coupon not found"
+//	@Failure		409		{object}	models.ErrorResponse		"Coupon code already exists"
+//	@Failure		500		{object}	models.ErrorResponse		"Internal server error"
+//	@Router			/admin/coupons/{id} [patch]
+func (h *CouponHandlers) UpdateCoupon(c *gin.Context) {
+	var req models.UpdateCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	coupon, err := h.couponService.UpdateCoupon(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to update coupon", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+// DeleteCoupon deletes a coupon.
+// DeleteCoupon godoc
+//
+//	@Summary		Delete a coupon
+//	@Security		BearerAuth
+//	@Description	Deletes a coupon so it can no longer be found or applied. Its redemption history is kept.
+//	@Tags			coupons
+//	@Produce		json
+//	@Param			id	path		string					true	"Coupon ID"
+//	@Success		200	{object}	models.SuccessResponse	"Coupon deleted successfully"
+//	@Failure		404	{object}	models.ErrorResponse	"Coupon not found"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal server error"
+//	@Router			/admin/coupons/{id} [delete]
+func (h *CouponHandlers) DeleteCoupon(c *gin.Context) {
+	if err := h.couponService.DeleteCoupon(c.Request.Context(), c.Param("id")); err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to delete coupon", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{Message: "Coupon deleted successfully"})
+}
+
 // GetApplicableCoupons retrieves the coupons applicable to a cart.
 // GetApplicableCoupons godoc
 //
 //	@Summary		Get applicable coupons
 //	@Security		BearerAuth
-//	@Description	Retrieves a list of coupons applicable to the current cart.
+//	@Description	Retrieves a list of coupons applicable to the current cart, optionally ranked by savings, along with the coupon or combination of stackable coupons that saves the most and the coupons a larger order would unlock.
 //	@Tags			coupons
 //	@Accept			json
 //	@Produce		json
@@ -81,13 +198,27 @@ func (h *CouponHandlers) GetApplicableCoupons(c *gin.Context) {
 
 	applicableCoupons, err := h.couponService.GetApplicableCoupons(c.Request.Context(), userID, &req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to get applicable coupons", Details: err.Error()})
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to get applicable coupons", Details: err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, applicableCoupons)
 }
 
+// GetCacheStats reports how well the applicable coupons cache is doing.
+// GetCacheStats godoc
+//
+//	@Summary		Get applicable coupons cache statistics
+//	@Security		BearerAuth
+//	@Description	Reports how many applicable coupons lookups were served from the cache and how many were computed, since the server started.
+//	@Tags			coupons
+//	@Produce		json
+//	@Success		200	{object}	models.CacheStatsResponse	"Cache statistics"
+//	@Router			/admin/cache/stats [get]
+func (h *CouponHandlers) GetCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.couponService.ApplicableCouponsCacheStats())
+}
+
 // ValidateCoupon validates a coupon against a shopping cart.
 // ValidateCoupon godoc
 //
@@ -119,9 +250,198 @@ func (h *CouponHandlers) ValidateCoupon(c *gin.Context) {
 
 	validationResponse, err := h.couponService.ValidateCoupon(c.Request.Context(), userID, &req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to validate coupon", Details: err.Error()})
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to validate coupon", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, validationResponse)
+}
+
+// ValidateCouponStack validates several coupons used together.
+// ValidateCouponStack godoc
+//
+//	@Summary		Validate a combination of coupons
+//	@Security		BearerAuth
+//	@Description	Validates several coupon codes used together against the provided cart. Coupons are applied from the highest priority down, each on what the ones before it left of the order, and codes that do not apply or conflict with an applied coupon are reported with the reason.
+//	@Tags			coupons
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		models.ValidateCouponStackRequest	true	"Stack validation request"
+//	@Success		200		{object}	models.ValidateCouponStackResponse	"Stack validation result"
+//	@Failure		400		{object}	models.ErrorResponse				"Bad request"
+//	@Failure		500		{object}	models.ErrorResponse				"Internal server error"
+//	@Router			/coupons/stack/validate [post]
+func (h *CouponHandlers) ValidateCouponStack(c *gin.Context) {
+	var req models.ValidateCouponStackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	userID, exists := c.MustGet("userID").(string)
+	if !exists {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "User ID not found in context"})
+		return
+	}
+
+	validationResponse, err := h.couponService.ValidateCouponStack(c.Request.Context(), userID, &req)
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to validate coupons", Details: err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, validationResponse)
 }
+
+// CreateRedemption holds a coupon for an order.
+// CreateRedemption godoc
+//
+//	@Summary		Hold a coupon for an order
+//	@Security		BearerAuth
+//	@Description	Validates a coupon against the cart and holds one use of it for the order until the hold is committed, released or expires. A retry for the same order returns the hold already placed, with the discount it was placed with.
+//	@Tags			redemptions
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		models.CreateRedemptionRequest	true	"Redemption request"
+//	@Success		201		{object}	models.RedemptionResponse		"Coupon held"
+//	@Failure		400		{object}	models.ErrorResponse			"Bad request"
+//	@Failure		404		{object}	models.ErrorResponse			"Coupon not found"
+//	@Failure		422		{object}	models.ErrorResponse			"Coupon not applicable or usage limit reached"
+//	@Failure		500		{object}	models.ErrorResponse			"Internal server error"
+//	@Router			/coupons/redemptions [post]
+func (h *CouponHandlers) CreateRedemption(c *gin.Context) {
+	var req models.CreateRedemptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	userID, exists := c.MustGet("userID").(string)
+	if !exists {
+		// This should not happen if the auth middleware is correctly applied
+		// but it's good practice to handle the possibility.
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "User ID not found in context"})
+		return
+	}
+
+	redemption, err := h.couponService.CreateRedemption(c.Request.Context(), userID, &req)
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to hold coupon", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, redemption)
+}
+
+// CreateStackRedemption holds a combination of coupons for an order.
+// CreateStackRedemption godoc
+//
+//	@Summary		Hold a combination of coupons for an order
+//	@Security		BearerAuth
+//	@Description	Validates several coupon codes used together and holds one use of every coupon that applies for the order. Each hold is committed or released on its own. Coupons already held for the order return their existing hold.
+//	@Tags			redemptions
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		models.CreateStackRedemptionRequest	true	"Stack redemption request"
+//	@Success		201		{object}	models.StackRedemptionResponse		"Coupons held"
+//	@Failure		400		{object}	models.ErrorResponse				"Bad request"
+//	@Failure		422		{object}	models.ErrorResponse				"No coupon applicable or usage limit reached"
+//	@Failure		500		{object}	models.ErrorResponse				"Internal server error"
+//	@Router			/coupons/stack/redemptions [post]
+func (h *CouponHandlers) CreateStackRedemption(c *gin.Context) {
+	var req models.CreateStackRedemptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	userID, exists := c.MustGet("userID").(string)
+	if !exists {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "User ID not found in context"})
+		return
+	}
+
+	redemptions, err := h.couponService.CreateStackRedemption(c.Request.Context(), userID, &req)
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to hold coupons", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, redemptions)
+}
+
+// CommitRedemption confirms a held coupon.
+// CommitRedemption godoc
+//
+//	@Summary		Commit a coupon hold
+//	@Security		BearerAuth
+//	@Description	Confirms a held coupon once checkout succeeds.
+//	@Tags			redemptions
+//	@Produce		json
+//	@Param			id	path		string						true	"Redemption ID"
+//	@Success		200	{object}	models.RedemptionResponse	"Redemption committed"
+//	@Failure		404	{object}	models.ErrorResponse		"Redemption not found"
+//	@Failure		409	{object}	models.ErrorResponse		"Redemption is no longer held"
+//	@Failure		500	{object}	models.ErrorResponse		"Internal server error"
+//	@Router			/coupons/redemptions/{id}/commit [post]
+func (h *CouponHandlers) CommitRedemption(c *gin.Context) {
+	userID, exists := c.MustGet("userID").(string)
+	if !exists {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "User ID not found in context"})
+		return
+	}
+
+	redemption, err := h.couponService.CommitRedemption(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to commit redemption", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, redemption)
+}
+
+// ReleaseRedemption gives a held coupon back.
+// ReleaseRedemption godoc
+//
+//	@Summary		Release a coupon hold
+//	@Security		BearerAuth
+//	@Description	Gives a held coupon back when checkout is abandoned.
+//	@Tags			redemptions
+//	@Produce		json
+//	@Param			id	path		string						true	"Redemption ID"
+//	@Success		200	{object}	models.RedemptionResponse	"Redemption released"
+//	@Failure		404	{object}	models.ErrorResponse		"Redemption not found"
+//	@Failure		409	{object}	models.ErrorResponse		"Redemption is no longer held"
+//	@Failure		500	{object}	models.ErrorResponse		"Internal server error"
+//	@Router			/coupons/redemptions/{id}/release [post]
+func (h *CouponHandlers) ReleaseRedemption(c *gin.Context) {
+	userID, exists := c.MustGet("userID").(string)
+	if !exists {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "User ID not found in context"})
+		return
+	}
+
+	redemption, err := h.couponService.ReleaseRedemption(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to release redemption", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, redemption)
+}
+
+// errorStatus maps the errors of the coupon service to HTTP status codes, 500 for errors it does not know.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrCouponNotFound), errors.Is(err, services.ErrRedemptionNotFound), errors.Is(err, services.ErrExclusionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidCoupon), errors.Is(err, services.ErrInvalidExclusion), errors.Is(err, services.ErrInvalidCart):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrCouponNotApplicable), errors.Is(err, database.ErrUsageLimitReached):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, database.ErrRedemptionNotHeld), errors.Is(err, database.ErrCouponCodeTaken), errors.Is(err, database.ErrExclusionExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
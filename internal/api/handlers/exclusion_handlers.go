@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"coupon-system/internal/models"
+)
+
+// ListExclusions lists the medicines and categories no coupon may discount.
+// ListExclusions godoc
+//
+//	@Summary		List discount exclusions
+//	@Security		BearerAuth
+//	@Description	Retrieves the global list of medicines and categories that no coupon may discount.
+//	@Tags			exclusions
+//	@Produce		json
+//	@Success		200	{object}	models.ListExclusionsResponse	"Exclusions"
+//	@Failure		500	{object}	models.ErrorResponse			"Internal server error"
+//	@Router			/admin/exclusions [get]
+func (h *CouponHandlers) ListExclusions(c *gin.Context) {
+	exclusions, err := h.couponService.ListExclusions(c.Request.Context())
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to list exclusions", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, exclusions)
+}
+
+// CreateExclusion adds a medicine or category to the global exclusion list.
+// CreateExclusion godoc
+//
+//	@Summary		Add a discount exclusion
+//	@Security		BearerAuth
+//	@Description	Adds a medicine or category to the global exclusion list. Cart lines it matches are never discounted by any coupon.
+//	@Tags			exclusions
+//	@Accept			json
+//	@Produce		json
+//	@Param			exclusion	body		models.CreateExclusionRequest	true	"Exclusion"
+//	@Success		201			{object}	models.ExclusionResponse		"Exclusion created"
+//	@Failure		400			{object}	models.ErrorResponse			"Bad request"
+//	@Failure		409			{object}	models.ErrorResponse			"Item already excluded"
+//	@Failure		500			{object}	models.ErrorResponse			"Internal server error"
+//	@Router			/admin/exclusions [post]
+func (h *CouponHandlers) CreateExclusion(c *gin.Context) {
+	var req models.CreateExclusionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	exclusion, err := h.couponService.CreateExclusion(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to create exclusion", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, exclusion)
+}
+
+// DeleteExclusion removes a medicine or category from the global exclusion list.
+// DeleteExclusion godoc
+//
+//	@Summary		Remove a discount exclusion
+//	@Security		BearerAuth
+//	@Description	Removes an entry from the global exclusion list so coupons may discount the item again.
+//	@Tags			exclusions
+//	@Produce		json
+//	@Param			id	path		string					true	"Exclusion ID"
+//	@Success		200	{object}	models.SuccessResponse	"Exclusion deleted successfully"
+//	@Failure		404	{object}	models.ErrorResponse	"Exclusion not found"
+//	@Failure		500	{object}	models.ErrorResponse	"Internal server error"
+//	@Router			/admin/exclusions/{id} [delete]
+func (h *CouponHandlers) DeleteExclusion(c *gin.Context) {
+	if err := h.couponService.DeleteExclusion(c.Request.Context(), c.Param("id")); err != nil {
+		c.JSON(errorStatus(err), models.ErrorResponse{Error: "Failed to delete exclusion", Details: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{Message: "Exclusion deleted successfully"})
+}
@@ -6,3 +6,19 @@ type Cache[K comparable, V any] interface {
 	Set(key K, value V)
 	Delete(key K)
 }
+
+// TaggedCache is a Cache whose entries can be tagged, e.g. with the user they belong to, so that every entry
+// with a tag can be dropped without flushing the rest.
+type TaggedCache[K comparable, V any] interface {
+	Cache[K, V]
+	// Version returns a number that changes whenever entries are invalidated. Read it before loading a value
+	// to cache and pass it to SetTagged.
+	Version() uint64
+	// SetTagged adds or updates a value with tags. If entries were invalidated after version was read, the
+	// value may have been loaded from data that has changed since, so it is not cached.
+	SetTagged(version uint64, key K, value V, tags ...string)
+	// Invalidate removes every entry set with any of the tags.
+	Invalidate(tags ...string)
+	// InvalidateAll removes every entry.
+	InvalidateAll()
+}
@@ -0,0 +1,280 @@
+package caching_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"coupon-system/internal/caching"
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// The tests in this package describe the behavior the coupon service relies on from a cache of applicable
+// coupons results: that it returns what was set until the TTL passes, drops entries by tag, does not keep
+// values loaded before an invalidation and, when it is shared between processes, that every process sees the
+// entries and invalidations of the others. Each runs against every cache.
+
+// cache is the kind of cache the tests check.
+type cache = caching.TaggedCache[string, *models.ApplicableCouponsResponse]
+
+// backend is a cache implementation under test.
+type backend struct {
+	// newCache returns an empty cache whose entries expire after ttl. The caches of a shared backend share their
+	// entries and invalidations, like those of several server processes.
+	newCache func() cache
+	ttl      time.Duration
+	// elapse lets d pass for the expiry of entries.
+	elapse func(d time.Duration)
+}
+
+// eachCache runs test against every cache: the in-process LRU cache, unless the test is for shared caches
+// only, and the Redis cache, against miniredis, a Redis stand-in running in the process, or, when
+// TEST_REDIS_URL is set, the Redis server at that URL, where it only leaves keys that expire within seconds:
+//
+//	TEST_REDIS_URL=redis://localhost:6379/0 go test ./internal/caching
+func eachCache(t *testing.T, sharedOnly bool, test func(t *testing.T, backend backend)) {
+	if !sharedOnly {
+		t.Run("lru", func(t *testing.T) {
+			const ttl = 50 * time.Millisecond
+			test(t, backend{
+				newCache: func() cache { return caching.NewLRUCache[string, *models.ApplicableCouponsResponse](100, ttl) },
+				ttl:      ttl,
+				elapse:   time.Sleep,
+			})
+		})
+	}
+	t.Run("redis", func(t *testing.T) {
+		// Redis expires keys to the millisecond, so the TTL can be short
+		const ttl = time.Second
+		redisURL, elapse := os.Getenv("TEST_REDIS_URL"), time.Sleep
+		if redisURL == "" {
+			server := miniredis.RunT(t)
+			redisURL = "redis://" + server.Addr()
+			// miniredis only expires keys when its clock is moved forward
+			elapse = server.FastForward
+		}
+		options, err := redis.ParseURL(redisURL)
+		if err != nil {
+			t.Fatalf("invalid Redis URL: %v", err)
+		}
+		client := redis.NewClient(options)
+		t.Cleanup(func() { client.Close() })
+
+		prefix := "cachetest:" + uuid.New().String() + ":"
+		test(t, backend{
+			newCache: func() cache {
+				cache := caching.NewRedisCache[*models.ApplicableCouponsResponse](client, prefix, ttl)
+				t.Cleanup(func() { cache.Close() })
+				return cache
+			},
+			ttl:    ttl,
+			elapse: elapse,
+		})
+	})
+}
+
+func TestEntriesAreReturnedAsSetUntilTheyExpire(t *testing.T) {
+	eachCache(t, false, func(t *testing.T, backend backend) {
+		cache := backend.newCache()
+		cache.SetTagged(cache.Version(), "key", response(), "user:u1", "coupon:c1")
+		expectEntry(t, cache, "key", response())
+		backend.elapse(backend.ttl + backend.ttl/2)
+		expectMiss(t, cache, "key")
+	})
+}
+
+func TestEntriesAreSetAndDeletedByKey(t *testing.T) {
+	eachCache(t, false, func(t *testing.T, backend backend) {
+		cache := backend.newCache()
+		expectMiss(t, cache, "key")
+		cache.Set("key", response())
+		expectEntry(t, cache, "key", response())
+		updated := response()
+		updated.NearMisses = nil
+		cache.Set("key", updated)
+		expectEntry(t, cache, "key", updated)
+		cache.Delete("key")
+		expectMiss(t, cache, "key")
+	})
+}
+
+func TestInvalidatingTagsDropsOnlyTheirEntries(t *testing.T) {
+	eachCache(t, false, func(t *testing.T, backend backend) {
+		cache := backend.newCache()
+		version := cache.Version()
+		cache.SetTagged(version, "u1", response(), "user:u1", "coupon:c1")
+		cache.SetTagged(version, "u2", response(), "user:u2", "coupon:c1")
+		cache.SetTagged(version, "u3", response(), "user:u3", "coupon:c2")
+		cache.Set("untagged", response())
+
+		cache.Invalidate("coupon:c1", "user:u9")
+		if cache.Version() == version {
+			t.Errorf("the version did not change when tags were invalidated")
+		}
+		expectMiss(t, cache, "u1")
+		expectMiss(t, cache, "u2")
+		expectEntry(t, cache, "u3", response())
+		expectEntry(t, cache, "untagged", response())
+	})
+}
+
+func TestInvalidatingEverythingDropsEveryEntry(t *testing.T) {
+	eachCache(t, false, func(t *testing.T, backend backend) {
+		cache := backend.newCache()
+		version := cache.Version()
+		cache.SetTagged(version, "tagged", response(), "user:u1")
+		cache.Set("untagged", response())
+
+		cache.InvalidateAll()
+		if cache.Version() == version {
+			t.Errorf("the version did not change when everything was invalidated")
+		}
+		expectMiss(t, cache, "tagged")
+		expectMiss(t, cache, "untagged")
+
+		// The cache is usable afterwards, and the old tags no longer reach new entries
+		cache.SetTagged(cache.Version(), "tagged", response(), "user:u2")
+		cache.Invalidate("user:u1")
+		expectEntry(t, cache, "tagged", response())
+	})
+}
+
+func TestValuesLoadedBeforeAnInvalidationAreNotKept(t *testing.T) {
+	eachCache(t, false, func(t *testing.T, backend backend) {
+		cache := backend.newCache()
+		version := cache.Version()
+		cache.Invalidate("user:u1")
+		cache.SetTagged(version, "after-tags", response(), "user:u2")
+		expectMiss(t, cache, "after-tags")
+
+		version = cache.Version()
+		cache.InvalidateAll()
+		cache.SetTagged(version, "after-all", response(), "user:u2")
+		expectMiss(t, cache, "after-all")
+	})
+}
+
+func TestEntriesAreShared(t *testing.T) {
+	eachCache(t, true, func(t *testing.T, backend backend) {
+		first, second := backend.newCache(), backend.newCache()
+		first.SetTagged(first.Version(), "key", response(), "user:u1")
+		expectEntry(t, second, "key", response())
+		second.Delete("key")
+		expectMiss(t, first, "key")
+	})
+}
+
+func TestInvalidationsReachEveryProcess(t *testing.T) {
+	eachCache(t, true, func(t *testing.T, backend backend) {
+		first, second := backend.newCache(), backend.newCache()
+		version := first.Version()
+		first.SetTagged(version, "tagged", response(), "user:u1")
+		second.Invalidate("user:u1")
+		expectMiss(t, first, "tagged")
+		// With a version read before another process invalidated tags
+		first.SetTagged(version, "stale", response(), "user:u2")
+		expectMiss(t, first, "stale")
+
+		first.Set("untagged", response())
+		second.InvalidateAll()
+		expectMiss(t, first, "untagged")
+	})
+}
+
+// How long TestOtherProcessesAreNotifiedOfInvalidations waits for a notification.
+const notificationTimeout = 2 * time.Second
+
+func TestOtherProcessesAreNotifiedOfInvalidations(t *testing.T) {
+	eachCache(t, true, func(t *testing.T, backend backend) {
+		first, second := backend.newCache(), backend.newCache()
+		notifier, ok := first.(caching.InvalidationNotifier)
+		if !ok {
+			t.Fatalf("a shared cache does not implement caching.InvalidationNotifier")
+		}
+		notifications := make(chan caching.Invalidation, 10)
+		notifier.OnInvalidate(func(invalidation caching.Invalidation) { notifications <- invalidation })
+
+		// A cache is not notified of its own invalidations; the ones that follow come in order
+		first.InvalidateAll()
+		second.Invalidate("user:u1", "coupon:c1")
+		second.InvalidateAll()
+		for _, want := range []caching.Invalidation{{Tags: []string{"user:u1", "coupon:c1"}}, {All: true}} {
+			select {
+			case got := <-notifications:
+				if got.All != want.All || fmt.Sprint(got.Tags) != fmt.Sprint(want.Tags) {
+					t.Errorf("notified of %+v, want %+v", got, want)
+				}
+			case <-time.After(notificationTimeout):
+				t.Fatalf("not notified of %+v within %v", want, notificationTimeout)
+			}
+		}
+		select {
+		case got := <-notifications:
+			t.Errorf("notified of %+v, want no more notifications", got)
+		case <-time.After(notificationTimeout / 10):
+		}
+	})
+}
+
+// response returns an applicable coupons result using every field, so an entry that does not come back
+// exactly as it was set renders differently.
+func response() *models.ApplicableCouponsResponse {
+	inr := func(amount string) money.Money { return money.MustParse(amount, money.DefaultCurrency) }
+	return &models.ApplicableCouponsResponse{
+		ApplicableCoupons: []models.ApplicableCoupon{
+			{
+				CouponCode:    "TIERED",
+				DiscountValue: json.Number("10.5"),
+				DiscountType:  "tiered",
+				Discount:      inr("52.50"),
+				CappedBy:      models.DiscountCappedByMaxDiscount,
+				Rank:          1,
+				TierReached:   &models.DiscountTier{MinOrderValue: inr("500.00"), DiscountType: "percentage", DiscountValue: json.Number("10.5")},
+				NextTier: &models.NextDiscountTier{
+					DiscountTier: models.DiscountTier{MinOrderValue: inr("1000.00"), DiscountType: "fixed_amount", DiscountValue: json.Number("150.00")},
+					AmountNeeded: inr("499.99"),
+				},
+			},
+			{CouponCode: "FLAT", DiscountValue: json.Number("20.00"), DiscountType: "fixed_amount", Discount: inr("20.00"), Rank: 2},
+		},
+		Best:       &models.BestCoupon{CouponCodes: []string{"TIERED", "FLAT"}, Discount: inr("72.50"), Stacked: true},
+		NearMisses: []models.NearMissCoupon{{CouponCode: "BIG", DiscountValue: json.Number("15"), DiscountType: "percentage", MinOrderValue: inr("2000.00"), AmountNeeded: inr("1499.99"), Message: "Add ₹1499.99 more"}},
+	}
+}
+
+// expectEntry checks that the cache returns an entry rendering like want, which is what clients get.
+func expectEntry(t *testing.T, cache cache, key string, want *models.ApplicableCouponsResponse) {
+	t.Helper()
+	got, ok := cache.Get(key)
+	if !ok {
+		t.Errorf("entry %q is missing", key)
+		return
+	}
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("failed to render entry %q: %v", key, err)
+	}
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("entry %q renders as %s, want %s", key, gotJSON, wantJSON)
+	}
+}
+
+// expectMiss checks that the cache has no entry for the key.
+func expectMiss(t *testing.T, cache cache, key string) {
+	t.Helper()
+	if _, ok := cache.Get(key); ok {
+		t.Errorf("entry %q was found, want none", key)
+	}
+}
@@ -1,24 +1,38 @@
 package caching
 
 import (
+	"sync"
 	"time"
 
 	e "github.com/hashicorp/golang-lru/v2/expirable"
 )
 
-// LRUCache is an LRU cache implementation with expirability.
+// LRUCache is an LRU cache implementation with expirability. It implements TaggedCache by keeping an index
+// of the keys set with each tag.
 type LRUCache[K comparable, V any] struct {
 	cache      *e.LRU[K, V]
 	defaultTTL time.Duration
+
+	mu      sync.Mutex
+	version uint64
+	keys    map[string]map[K]struct{} // Keys of the entries set with each tag
+	tags    map[K][]string            // Tags each entry was set with
+
+	// The LRU reports the entries it evicts while holding its own lock, which may be taken while mu is held,
+	// so evicted keys are queued and taken out of the index the next time mu is held.
+	evictedMu sync.Mutex
+	evicted   []K
 }
 
 // NewLRUCache creates a new LRUCache.
 func NewLRUCache[K comparable, V any](maxEntries int, defaultTTL time.Duration) *LRUCache[K, V] {
-	c := e.NewLRU[K, V](maxEntries, nil, defaultTTL)
-	return &LRUCache[K, V]{
-		cache:      c,
+	c := &LRUCache[K, V]{
 		defaultTTL: defaultTTL,
+		keys:       make(map[string]map[K]struct{}),
+		tags:       make(map[K][]string),
 	}
+	c.cache = e.NewLRU[K, V](maxEntries, c.onEvict, defaultTTL)
+	return c
 }
 
 // Get retrieves a value from the cache.
@@ -29,10 +43,109 @@ func (c *LRUCache[K, V]) Get(key K) (V, bool) {
 
 // Set adds or updates a value in the cache with a TTL.
 func (c *LRUCache[K, V]) Set(key K, value V) {
-	c.cache.Add(key, value)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value, nil)
 }
 
 // Delete removes a value from the cache.
 func (c *LRUCache[K, V]) Delete(key K) {
 	c.cache.Remove(key)
 }
+
+// Version returns the number of invalidations so far.
+func (c *LRUCache[K, V]) Version() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// SetTagged adds or updates a value in the cache with a TTL and tags, unless entries were invalidated since
+// version.
+func (c *LRUCache[K, V]) SetTagged(version uint64, key K, value V, tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		return
+	}
+	c.set(key, value, tags)
+}
+
+// Invalidate removes every entry set with any of the tags.
+func (c *LRUCache[K, V]) Invalidate(tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	c.dropEvicted()
+
+	for _, tag := range tags {
+		for key := range c.keys[tag] {
+			c.cache.Remove(key)
+			c.unindex(key)
+		}
+	}
+}
+
+// InvalidateAll removes every entry.
+func (c *LRUCache[K, V]) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+
+	c.cache.Purge()
+	c.keys = make(map[string]map[K]struct{})
+	c.tags = make(map[K][]string)
+	c.evictedMu.Lock()
+	c.evicted = nil
+	c.evictedMu.Unlock()
+}
+
+// set adds a value and indexes it under its tags, replacing the tags it had. c.mu must be held.
+func (c *LRUCache[K, V]) set(key K, value V, tags []string) {
+	c.dropEvicted()
+	c.unindex(key)
+	c.cache.Add(key, value)
+	if len(tags) == 0 {
+		return
+	}
+	c.tags[key] = tags
+	for _, tag := range tags {
+		if c.keys[tag] == nil {
+			c.keys[tag] = make(map[K]struct{})
+		}
+		c.keys[tag][key] = struct{}{}
+	}
+}
+
+// unindex removes a key from the index. c.mu must be held.
+func (c *LRUCache[K, V]) unindex(key K) {
+	for _, tag := range c.tags[key] {
+		delete(c.keys[tag], key)
+		if len(c.keys[tag]) == 0 {
+			delete(c.keys, tag)
+		}
+	}
+	delete(c.tags, key)
+}
+
+// dropEvicted removes the keys the LRU evicted from the index, unless they have been set again since.
+// c.mu must be held.
+func (c *LRUCache[K, V]) dropEvicted() {
+	c.evictedMu.Lock()
+	evicted := c.evicted
+	c.evicted = nil
+	c.evictedMu.Unlock()
+
+	for _, key := range evicted {
+		if !c.cache.Contains(key) {
+			c.unindex(key)
+		}
+	}
+}
+
+// onEvict queues the key of an entry the LRU evicted, expired or removed.
+func (c *LRUCache[K, V]) onEvict(key K, _ V) {
+	c.evictedMu.Lock()
+	c.evicted = append(c.evicted, key)
+	c.evictedMu.Unlock()
+}
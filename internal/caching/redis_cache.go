@@ -0,0 +1,265 @@
+package caching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Invalidation describes an invalidation another process made in a shared cache.
+type Invalidation struct {
+	All  bool     `json:"all"`            // Whether every entry was invalidated
+	Tags []string `json:"tags,omitempty"` // Tags whose entries were invalidated, unless All
+}
+
+// InvalidationNotifier is implemented by caches shared between processes. Listeners registered with
+// OnInvalidate are called with the invalidations other processes make, so each process can drop whatever it
+// derived from the invalidated data.
+type InvalidationNotifier interface {
+	OnInvalidate(listener func(Invalidation))
+}
+
+// Timeout of each Redis operation. A lookup that times out is a miss.
+const redisTimeout = time.Second
+
+// unknownVersion is returned by Version when Redis cannot be reached. It never matches the stored version, so
+// values loaded meanwhile are not cached.
+const unknownVersion = ^uint64(0)
+
+// RedisCache is a TaggedCache kept in Redis, or any server speaking its protocol, so that several server
+// processes share its entries and invalidations. Values are stored as JSON and expire after the TTL.
+//
+// Entries and the sets of keys of each tag live under an epoch that InvalidateAll increments, which leaves the
+// old ones to expire. A counter in Redis is the version, and every invalidation is published on a channel for
+// the other processes' listeners. Each operation is a script, so it is atomic. The scripts build key names,
+// so the cache needs a single Redis server rather than a cluster.
+type RedisCache[V any] struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	source string // Identifies this cache's invalidations on the channel
+
+	subscription *redis.PubSub
+	mu           sync.Mutex
+	listeners    []func(Invalidation)
+}
+
+// invalidationMessage is published on the channel for every invalidation.
+type invalidationMessage struct {
+	Source string `json:"source"`
+	Invalidation
+}
+
+// NewRedisCache creates a RedisCache keeping its keys under prefix, e.g. "coupons:applicable:", and subscribes
+// to its invalidation channel until Close is called. Caches with the same prefix share their entries.
+func NewRedisCache[V any](client *redis.Client, prefix string, ttl time.Duration) *RedisCache[V] {
+	c := &RedisCache[V]{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+		source: uuid.New().String(),
+	}
+	c.subscription = client.Subscribe(context.Background(), c.channel())
+	go c.listen()
+	return c
+}
+
+// Close stops listening for invalidations. It does not close the client.
+func (c *RedisCache[V]) Close() error {
+	return c.subscription.Close()
+}
+
+// OnInvalidate registers a listener for the invalidations of other caches with the same prefix.
+func (c *RedisCache[V]) OnInvalidate(listener func(Invalidation)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+var redisGetScript = redis.NewScript(`
+local epoch = redis.call('GET', KEYS[1]) or '0'
+return redis.call('GET', ARGV[1] .. epoch .. ':entry:' .. ARGV[2])
+`)
+
+// Get retrieves a value from the cache.
+func (c *RedisCache[V]) Get(key string) (V, bool) {
+	var value V
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := redisGetScript.Run(ctx, c.client, []string{c.epochKey()}, c.prefix, key).Text()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("failed to get cache entry: %v", err)
+		}
+		return value, false
+	}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		log.Printf("failed to decode cache entry: %v", err)
+		return value, false
+	}
+	return value, true
+}
+
+// Set adds or updates a value in the cache with a TTL.
+func (c *RedisCache[V]) Set(key string, value V) {
+	c.set(nil, key, value, nil)
+}
+
+var redisDeleteScript = redis.NewScript(`
+local epoch = redis.call('GET', KEYS[1]) or '0'
+return redis.call('DEL', ARGV[1] .. epoch .. ':entry:' .. ARGV[2])
+`)
+
+// Delete removes a value from the cache.
+func (c *RedisCache[V]) Delete(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := redisDeleteScript.Run(ctx, c.client, []string{c.epochKey()}, c.prefix, key).Err(); err != nil {
+		log.Printf("failed to delete cache entry: %v", err)
+	}
+}
+
+// Version returns the number of invalidations so far.
+func (c *RedisCache[V]) Version() uint64 {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	version, err := c.client.Get(ctx, c.versionKey()).Uint64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("failed to get cache version: %v", err)
+		return unknownVersion
+	}
+	return version
+}
+
+// SetTagged adds or updates a value in the cache with a TTL and tags, unless entries were invalidated since
+// version.
+func (c *RedisCache[V]) SetTagged(version uint64, key string, value V, tags ...string) {
+	c.set(&version, key, value, tags)
+}
+
+// redisSetScript stores an entry and adds it to the sets of its tags, which live as long as their newest
+// entry. With a version in ARGV[5], it does nothing unless the version is current.
+var redisSetScript = redis.NewScript(`
+if ARGV[5] and (redis.call('GET', KEYS[2]) or '0') ~= ARGV[5] then
+	return 0
+end
+local epoch = redis.call('GET', KEYS[1]) or '0'
+local entry = ARGV[1] .. epoch .. ':entry:' .. ARGV[2]
+redis.call('SET', entry, ARGV[3], 'PX', ARGV[4])
+for i = 3, #KEYS do
+	local tag = ARGV[1] .. epoch .. ':tag:' .. KEYS[i]
+	redis.call('SADD', tag, entry)
+	redis.call('PEXPIRE', tag, ARGV[4])
+end
+return 1
+`)
+
+func (c *RedisCache[V]) set(version *uint64, key string, value V, tags []string) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("failed to encode cache entry: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := append([]string{c.epochKey(), c.versionKey()}, tags...)
+	args := []interface{}{c.prefix, key, data, c.ttl.Milliseconds()}
+	if version != nil {
+		args = append(args, strconv.FormatUint(*version, 10))
+	}
+	if err := redisSetScript.Run(ctx, c.client, keys, args...).Err(); err != nil {
+		log.Printf("failed to set cache entry: %v", err)
+	}
+}
+
+// redisInvalidateScript deletes the entries of the tags in KEYS[3:] and their sets, or with no tags starts a
+// new epoch. Either way it increments the version and publishes ARGV[2] on the channel in ARGV[3].
+var redisInvalidateScript = redis.NewScript(`
+redis.call('INCR', KEYS[2])
+if #KEYS == 2 then
+	redis.call('INCR', KEYS[1])
+else
+	local epoch = redis.call('GET', KEYS[1]) or '0'
+	for i = 3, #KEYS do
+		local tag = ARGV[1] .. epoch .. ':tag:' .. KEYS[i]
+		for _, entry in ipairs(redis.call('SMEMBERS', tag)) do
+			redis.call('DEL', entry)
+		end
+		redis.call('DEL', tag)
+	end
+end
+redis.call('PUBLISH', ARGV[3], ARGV[2])
+return 1
+`)
+
+// Invalidate removes every entry set with any of the tags.
+func (c *RedisCache[V]) Invalidate(tags ...string) {
+	if len(tags) == 0 {
+		return
+	}
+	c.invalidate(Invalidation{Tags: tags})
+}
+
+// InvalidateAll removes every entry.
+func (c *RedisCache[V]) InvalidateAll() {
+	c.invalidate(Invalidation{All: true})
+}
+
+func (c *RedisCache[V]) invalidate(invalidation Invalidation) {
+	message, err := json.Marshal(invalidationMessage{Source: c.source, Invalidation: invalidation})
+	if err != nil {
+		log.Printf("failed to encode cache invalidation: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := append([]string{c.epochKey(), c.versionKey()}, invalidation.Tags...)
+	if err := redisInvalidateScript.Run(ctx, c.client, keys, c.prefix, message, c.channel()).Err(); err != nil {
+		log.Printf("failed to invalidate cache entries, they may be served stale until they expire: %v", err)
+	}
+}
+
+// listen calls the listeners with the invalidations other caches publish, until the subscription is closed.
+func (c *RedisCache[V]) listen() {
+	for msg := range c.subscription.Channel() {
+		var message invalidationMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+			log.Printf("failed to decode cache invalidation: %v", err)
+			continue
+		}
+		if message.Source == c.source {
+			continue
+		}
+
+		c.mu.Lock()
+		listeners := c.listeners
+		c.mu.Unlock()
+		for _, listener := range listeners {
+			listener(message.Invalidation)
+		}
+	}
+}
+
+func (c *RedisCache[V]) epochKey() string {
+	return c.prefix + "epoch"
+}
+
+func (c *RedisCache[V]) versionKey() string {
+	return c.prefix + "version"
+}
+
+func (c *RedisCache[V]) channel() string {
+	return c.prefix + "invalidations"
+}
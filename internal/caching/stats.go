@@ -0,0 +1,30 @@
+package caching
+
+import "sync/atomic"
+
+// Stats counts the lookups of a cache that found an entry and those that did not. It is safe for concurrent
+// use.
+type Stats struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// Hit records a lookup that found an entry.
+func (s *Stats) Hit() {
+	s.hits.Add(1)
+}
+
+// Miss records a lookup that found nothing.
+func (s *Stats) Miss() {
+	s.misses.Add(1)
+}
+
+// Snapshot returns the number of hits and misses so far and the share of lookups that were hits, 0 before
+// the first lookup.
+func (s *Stats) Snapshot() (hits, misses uint64, hitRate float64) {
+	hits, misses = s.hits.Load(), s.misses.Load()
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	return hits, misses, hitRate
+}
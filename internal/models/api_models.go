@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"coupon-system/internal/money"
+	"encoding/json"
+	"time"
+)
 
 // @Description SuccessResponse represents a generic success response with a message.
 type SuccessResponse struct {
@@ -15,67 +19,393 @@ type ErrorResponse struct {
 
 // @Description CreateCouponRequest represents the request to create a new coupon
 type CreateCouponRequest struct {
-	CouponCode            string     `json:"coupon_code" binding:"required"`
-	ExpiryDate            time.Time  `json:"expiry_date" binding:"required"`
-	UsageType             string     `json:"usage_type" binding:"required,oneof=one_time multi_use time_based"`
-	ApplicableMedicineIDs []string   `json:"applicable_medicine_ids"`
-	ApplicableCategories  []string   `json:"applicable_categories"`
-	MinOrderValue         float64    `json:"min_order_value"`
-	ValidTimeWindowStart  *time.Time `json:"valid_time_window_start,omitempty"`
-	ValidTimeWindowEnd    *time.Time `json:"valid_time_window_end,omitempty"`
-	TermsAndConditions    string     `json:"terms_and_conditions"`
-	// DiscountType is the type of discount (percentage or fixed_amount)
-	DiscountType    string  `json:"discount_type" binding:"required,oneof=percentage fixed_amount"`
-	DiscountValue   float64 `json:"discount_value" binding:"required"`
-	MaxUsagePerUser int     `json:"max_usage_per_user"`
-	MaxTotalUsage   int     `json:"max_total_usage"`
+	CouponCode            string      `json:"coupon_code" binding:"required"`
+	ExpiryDate            time.Time   `json:"expiry_date" binding:"required"`
+	UsageType             string      `json:"usage_type" binding:"required,oneof=one_time multi_use time_based"`
+	ApplicableMedicineIDs []string    `json:"applicable_medicine_ids"`
+	ApplicableCategories  []string    `json:"applicable_categories"`
+	MinOrderValue         money.Money `json:"min_order_value" swaggertype:"number"`
+	ValidTimeWindowStart  *time.Time  `json:"valid_time_window_start,omitempty"`
+	ValidTimeWindowEnd    *time.Time  `json:"valid_time_window_end,omitempty"`
+	TermsAndConditions    string      `json:"terms_and_conditions"`
+	// EligibilityRule is an extra condition on the cart and request, e.g. "category = Vitamins AND quantity >= 3 AND
+	// channel = app". It is checked when the coupon is saved; see the README for the variables it can use
+	EligibilityRule string `json:"eligibility_rule"`
+	// DiscountType is the type of discount (percentage, fixed_amount, buy_x_get_y or tiered)
+	DiscountType string `json:"discount_type" binding:"required,oneof=percentage fixed_amount buy_x_get_y tiered"`
+	// DiscountValue is the percentage off for percentage coupons, the amount off for fixed_amount coupons, or the
+	// percentage off the reward units for buy_x_get_y coupons (100 makes them free). Tiered coupons use Tiers instead
+	DiscountValue json.Number `json:"discount_value" binding:"required_unless=DiscountType tiered" swaggertype:"number"`
+	// Tiers are the spend thresholds of a tiered coupon and the discount each unlocks. The lowest threshold
+	// becomes the coupon's minimum order value
+	Tiers []DiscountTier `json:"tiers" binding:"omitempty,dive"`
+	// Excluded medicines and categories are never discounted by the coupon, on top of the global exclusions
+	ExcludedMedicineIDs []string `json:"excluded_medicine_ids"`
+	ExcludedCategories  []string `json:"excluded_categories"`
+	// BuyQuantity and GetQuantity make a buy_x_get_y coupon give GetQuantity reward units for every BuyQuantity
+	// trigger units, e.g. 2 and 1 for "buy 2, get 1 free"
+	BuyQuantity int `json:"buy_quantity"`
+	GetQuantity int `json:"get_quantity"`
+	// The trigger set holds the items that count towards the units to buy, any item when empty. The reward set
+	// holds the items that can be given as the reward, the trigger set when empty
+	TriggerMedicineIDs []string `json:"trigger_medicine_ids"`
+	TriggerCategories  []string `json:"trigger_categories"`
+	RewardMedicineIDs  []string `json:"reward_medicine_ids"`
+	RewardCategories   []string `json:"reward_categories"`
+	// MaxDiscountAmount caps the discount the coupon can give, e.g. "20% off up to 100". Omit or set to 0 for no cap
+	MaxDiscountAmount money.Money `json:"max_discount_amount" swaggertype:"number"`
+	MaxUsagePerUser   int         `json:"max_usage_per_user"`
+	MaxTotalUsage     int         `json:"max_total_usage"`
+	// Stackable, ExclusivityGroup and Priority control how the coupon combines with others; see POST /coupons/stack/validate
+	Stackable        bool   `json:"stackable"`
+	ExclusivityGroup string `json:"exclusivity_group"`
+	Priority         int    `json:"priority"`
+	// RecurringWindow limits the coupon to repeating periods of local time, on top of the valid time window
+	RecurringWindow *RecurringWindow `json:"recurring_window,omitempty"`
+}
+
+// @Description UpdateCouponRequest represents the request to update an existing coupon. Omitted fields are left unchanged.
+type UpdateCouponRequest struct {
+	CouponCode            *string         `json:"coupon_code,omitempty"`
+	ExpiryDate            *time.Time      `json:"expiry_date,omitempty"`
+	UsageType             *string         `json:"usage_type,omitempty" binding:"omitempty,oneof=one_time multi_use time_based"`
+	ApplicableMedicineIDs *[]string       `json:"applicable_medicine_ids,omitempty"` // Replaces the coupon's medicines when present
+	ApplicableCategories  *[]string       `json:"applicable_categories,omitempty"`   // Replaces the coupon's categories when present
+	MinOrderValue         *money.Money    `json:"min_order_value,omitempty" swaggertype:"number"`
+	ValidTimeWindowStart  *time.Time      `json:"valid_time_window_start,omitempty"`
+	ValidTimeWindowEnd    *time.Time      `json:"valid_time_window_end,omitempty"`
+	TermsAndConditions    *string         `json:"terms_and_conditions,omitempty"`
+	EligibilityRule       *string         `json:"eligibility_rule,omitempty"` // Set to "" to remove the rule
+	DiscountType          *string         `json:"discount_type,omitempty" binding:"omitempty,oneof=percentage fixed_amount buy_x_get_y tiered"`
+	DiscountValue         *json.Number    `json:"discount_value,omitempty" swaggertype:"number"`
+	BuyQuantity           *int            `json:"buy_quantity,omitempty"`
+	GetQuantity           *int            `json:"get_quantity,omitempty"`
+	TriggerMedicineIDs    *[]string       `json:"trigger_medicine_ids,omitempty"` // Replaces the coupon's trigger medicines when present
+	TriggerCategories     *[]string       `json:"trigger_categories,omitempty"`
+	RewardMedicineIDs     *[]string       `json:"reward_medicine_ids,omitempty"` // Replaces the coupon's reward medicines when present
+	RewardCategories      *[]string       `json:"reward_categories,omitempty"`
+	Tiers                 *[]DiscountTier `json:"tiers,omitempty" binding:"omitempty,dive"` // Replaces the coupon's tiers when present
+	ExcludedMedicineIDs   *[]string       `json:"excluded_medicine_ids,omitempty"`          // Replaces the coupon's excluded medicines when present
+	ExcludedCategories    *[]string       `json:"excluded_categories,omitempty"`
+	MaxDiscountAmount     *money.Money    `json:"max_discount_amount,omitempty" swaggertype:"number"` // Set to 0 to remove the cap
+	MaxUsagePerUser       *int            `json:"max_usage_per_user,omitempty"`
+	MaxTotalUsage         *int            `json:"max_total_usage,omitempty"`
+	IsActive              *bool           `json:"is_active,omitempty"` // Set to false to deactivate the coupon
+	Stackable             *bool           `json:"stackable,omitempty"`
+	ExclusivityGroup      *string         `json:"exclusivity_group,omitempty"` // Set to "" to remove the coupon from its group
+	Priority              *int            `json:"priority,omitempty"`
+	// Replaces the coupon's recurring window when present; {} removes it
+	RecurringWindow *RecurringWindow `json:"recurring_window,omitempty"`
+}
+
+// @Description RecurringWindow limits a coupon to repeating periods of local time, e.g. weekdays from 18:00 to 21:00 or the weekend of the first week of the month.
+type RecurringWindow struct {
+	Days         []string `json:"days,omitempty" binding:"omitempty,dive,oneof=sunday monday tuesday wednesday thursday friday saturday"` // Weekdays the coupon is valid on, every day when empty
+	WeeksOfMonth []int    `json:"weeks_of_month,omitempty" binding:"omitempty,dive,min=1,max=6"`                                          // Calendar weeks of the month, from Monday to Sunday, week 1 holding the 1st, e.g. [1] with saturday and sunday for the weekend of week 1; every week when empty
+	StartTime    string   `json:"start_time,omitempty" example:"18:00"`                                                                   // Local time of day the coupon becomes valid, the whole day when omitted with end_time
+	EndTime      string   `json:"end_time,omitempty" example:"21:00"`                                                                     // Local time of day it stops being valid; earlier than start_time for periods past midnight
+}
+
+// @Description DiscountTier represents one spend threshold of a tiered coupon and the discount an order reaching it gets.
+type DiscountTier struct {
+	MinOrderValue money.Money `json:"min_order_value" binding:"required" swaggertype:"number"`
+	DiscountType  string      `json:"discount_type" binding:"required,oneof=percentage fixed_amount"`
+	DiscountValue json.Number `json:"discount_value" binding:"required" swaggertype:"number"`
+}
+
+// @Description NextDiscountTier represents the next tier a tiered coupon would reach and how much more the order needs to reach it.
+type NextDiscountTier struct {
+	DiscountTier
+	AmountNeeded money.Money `json:"amount_needed" swaggertype:"number"`
+}
+
+// @Description ListCouponsRequest holds the filters and pagination for listing coupons.
+type ListCouponsRequest struct {
+	Status        string     `form:"status" binding:"omitempty,oneof=active inactive expired"`
+	UsageType     string     `form:"usage_type" binding:"omitempty,oneof=one_time multi_use time_based"`
+	ExpiresAfter  *time.Time `form:"expires_after"`
+	ExpiresBefore *time.Time `form:"expires_before"`
+	MedicineID    string     `form:"medicine_id"`
+	Category      string     `form:"category"`
+	Page          int        `form:"page" binding:"omitempty,min=1"`
+	PageSize      int        `form:"page_size" binding:"omitempty,min=1,max=100"`
+}
+
+// @Description CouponResponse represents a coupon as seen by admins.
+type CouponResponse struct {
+	ID                    string         `json:"id"`
+	CouponCode            string         `json:"coupon_code"`
+	ExpiryDate            time.Time      `json:"expiry_date"`
+	UsageType             string         `json:"usage_type"`
+	ApplicableMedicineIDs []string       `json:"applicable_medicine_ids"`
+	ApplicableCategories  []string       `json:"applicable_categories"`
+	Currency              string         `json:"currency"`
+	MinOrderValue         money.Money    `json:"min_order_value" swaggertype:"number"`
+	ValidTimeWindowStart  *time.Time     `json:"valid_time_window_start,omitempty"`
+	ValidTimeWindowEnd    *time.Time     `json:"valid_time_window_end,omitempty"`
+	TermsAndConditions    string         `json:"terms_and_conditions"`
+	EligibilityRule       string         `json:"eligibility_rule,omitempty"`
+	DiscountType          string         `json:"discount_type"`
+	DiscountValue         json.Number    `json:"discount_value" swaggertype:"number"`
+	BuyQuantity           int            `json:"buy_quantity,omitempty"` // Only set for buy_x_get_y coupons, like the trigger and reward sets
+	GetQuantity           int            `json:"get_quantity,omitempty"`
+	TriggerMedicineIDs    []string       `json:"trigger_medicine_ids,omitempty"`
+	TriggerCategories     []string       `json:"trigger_categories,omitempty"`
+	RewardMedicineIDs     []string       `json:"reward_medicine_ids,omitempty"`
+	RewardCategories      []string       `json:"reward_categories,omitempty"`
+	Tiers                 []DiscountTier `json:"tiers,omitempty"` // Only set for tiered coupons
+	ExcludedMedicineIDs   []string       `json:"excluded_medicine_ids"`
+	ExcludedCategories    []string       `json:"excluded_categories"`
+	MaxDiscountAmount     money.Money    `json:"max_discount_amount" swaggertype:"number"` // 0 when the discount is not capped
+	MaxUsagePerUser       int            `json:"max_usage_per_user"`
+	MaxTotalUsage         int            `json:"max_total_usage"`
+	CurrentTotalUsage     int            `json:"current_total_usage"`
+	IsActive              bool           `json:"is_active"`
+	Stackable             bool           `json:"stackable"`
+	ExclusivityGroup      string         `json:"exclusivity_group"`
+	Priority              int            `json:"priority"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+	// Set when the coupon is limited to repeating periods of local time
+	RecurringWindow *RecurringWindow `json:"recurring_window,omitempty"`
+}
+
+// @Description ListCouponsResponse represents one page of coupons.
+type ListCouponsResponse struct {
+	Coupons  []CouponResponse `json:"coupons"`
+	Total    int64            `json:"total"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+}
+
+// @Description CreateExclusionRequest represents the request to keep a medicine or category out of every discount.
+type CreateExclusionRequest struct {
+	Kind   string `json:"kind" binding:"required,oneof=medicine category"`
+	ItemID string `json:"item_id" binding:"required"` // Medicine or category ID
+	Reason string `json:"reason"`
+}
+
+// @Description ExclusionResponse represents a medicine or category that no coupon discounts.
+type ExclusionResponse struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	ItemID    string    `json:"item_id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// @Description ListExclusionsResponse represents the global exclusion list.
+type ListExclusionsResponse struct {
+	Exclusions []ExclusionResponse `json:"exclusions"`
 }
 
 // @Description ApplicableCouponsRequest represents the request to find applicable coupons for a cart
 type ApplicableCouponsRequest struct {
-	CartItems  []CartItem `json:"cart_items" binding:"required"`
-	OrderTotal float64    `json:"order_total" binding:"required"`
-	Timestamp  time.Time  `json:"timestamp" binding:"required"`
+	CartItems  []CartItem  `json:"cart_items" binding:"required,dive"`
+	OrderTotal money.Money `json:"order_total" binding:"required" swaggertype:"number"`
+	Timestamp  time.Time   `json:"timestamp" binding:"required"`
+	Channel    string      `json:"channel,omitempty"`                                // Channel the request comes from, e.g. app or web, for eligibility rules
+	Sort       string      `json:"sort,omitempty" binding:"omitempty,oneof=savings"` // "savings" ranks the coupons by the discount they give this cart, largest first
 }
 
+// Sort orders accepted by ApplicableCouponsRequest.Sort.
+const (
+	ApplicableCouponsSortSavings = "savings"
+)
+
 // @Description ApplicableCoupon represents a coupon that is applicable to the current cart.
 type ApplicableCoupon struct {
-	CouponCode    string  `json:"coupon_code"`
-	DiscountValue float64 `json:"discount_value"`
-	DiscountType  string  `json:"discount_type"`
-	Discount      float64 `json:"discount"`
+	CouponCode    string            `json:"coupon_code"`
+	DiscountValue json.Number       `json:"discount_value" swaggertype:"number"`
+	DiscountType  string            `json:"discount_type"`
+	Discount      money.Money       `json:"discount" swaggertype:"number"`
+	CappedBy      string            `json:"capped_by,omitempty"`    // Set when the discount was reduced by a cap: "max_discount_amount" or "eligible_amount"
+	Rank          int               `json:"rank,omitempty"`         // Position by savings, from 1, when the coupons are sorted by savings
+	TierReached   *DiscountTier     `json:"tier_reached,omitempty"` // For tiered coupons, the tier the order reaches
+	NextTier      *NextDiscountTier `json:"next_tier,omitempty"`    // For tiered coupons, the next tier up, if any
+}
+
+// @Description BestCoupon names the coupon, or combination of stackable coupons, that saves the most on the cart.
+type BestCoupon struct {
+	CouponCodes []string    `json:"coupon_codes"` // In the order the coupons are applied
+	Discount    money.Money `json:"discount" swaggertype:"number"`
+	Stacked     bool        `json:"stacked"` // True when the best choice combines several coupons
+}
+
+// @Description NearMissCoupon represents a coupon the cart would unlock by reaching the coupon's minimum order value.
+type NearMissCoupon struct {
+	CouponCode    string      `json:"coupon_code"`
+	DiscountValue json.Number `json:"discount_value" swaggertype:"number"`
+	DiscountType  string      `json:"discount_type"`
+	MinOrderValue money.Money `json:"min_order_value" swaggertype:"number"`
+	AmountNeeded  money.Money `json:"amount_needed" swaggertype:"number"` // How much more the order needs to reach the minimum order value
+	Message       string      `json:"message"`
 }
 
 // @Description ApplicableCouponsResponse represents the response body for applicable coupons.
 type ApplicableCouponsResponse struct {
 	ApplicableCoupons []ApplicableCoupon `json:"applicable_coupons"`
+	Best              *BestCoupon        `json:"best,omitempty"` // Omitted when no coupon applies
+	NearMisses        []NearMissCoupon   `json:"near_misses,omitempty"`
+}
+
+// @Description CacheStatsResponse reports how often applicable coupons results were served from the cache.
+type CacheStatsResponse struct {
+	Hits    uint64  `json:"hits" example:"120"`
+	Misses  uint64  `json:"misses" example:"30"`
+	HitRate float64 `json:"hit_rate" example:"0.8"` // Share of lookups that were hits, 0 before the first lookup
 }
 
 // @Description ValidateCouponRequest represents the request body for validating a coupon.
 type ValidateCouponRequest struct {
-	CouponCode string     `json:"coupon_code" binding:"required"`
-	CartItems  []CartItem `json:"cart_items" binding:"required"`
-	OrderTotal float64    `json:"order_total" binding:"required"`
-	Timestamp  time.Time  `json:"timestamp" binding:"required"`
+	CouponCode         string      `json:"coupon_code" binding:"required"`
+	CartItems          []CartItem  `json:"cart_items" binding:"required,dive"`
+	OrderTotal         money.Money `json:"order_total" binding:"required" swaggertype:"number"`
+	Timestamp          time.Time   `json:"timestamp" binding:"required"`
+	Channel            string      `json:"channel,omitempty"`              // Channel the request comes from, e.g. app or web, for eligibility rules
+	CollectAllFailures bool        `json:"collect_all_failures,omitempty"` // Run every check and report all failures instead of stopping at the first
 }
 
+// Caps that can reduce a discount, reported in the capped_by field of discount responses.
+const (
+	DiscountCappedByMaxDiscount    = "max_discount_amount" // The coupon's maximum discount amount
+	DiscountCappedByEligibleAmount = "eligible_amount"     // The price of the items the coupon applies to
+)
+
 // @Description DiscountDetails represents the details of the discount applied by a coupon.
 type DiscountDetails struct {
-	ItemsDiscount float64 `json:"items_discount"`
-	TotalDiscount float64 `json:"total_discount"`
+	ItemsDiscount money.Money    `json:"items_discount" swaggertype:"number"`
+	TotalDiscount money.Money    `json:"total_discount" swaggertype:"number"`
+	CappedBy      string         `json:"capped_by,omitempty"` // Set when the discount was reduced by a cap: "max_discount_amount" or "eligible_amount"
+	Lines         []LineDiscount `json:"lines,omitempty"`     // How the discount is split across the cart lines
+}
+
+// @Description LineDiscount represents the part of a discount allocated to one cart line.
+type LineDiscount struct {
+	LineIndex int         `json:"line_index"` // Position of the line in cart_items
+	ItemID    string      `json:"id"`
+	Category  string      `json:"category"`
+	Quantity  int         `json:"quantity"`
+	LineTotal money.Money `json:"line_total" swaggertype:"number"` // Price multiplied by quantity
+	Discount  money.Money `json:"discount" swaggertype:"number"`
+	// RewardUnits is how many units of the line a buy_x_get_y coupon gives as the reward, at its discount
+	// percentage off; they are free when the percentage is 100
+	RewardUnits int `json:"reward_units,omitempty"`
 }
 
 // @Description ValidateCouponResponse represents the response body for validating a coupon.
 type ValidateCouponResponse struct {
-	IsValid  bool             `json:"is_valid"`
-	Discount *DiscountDetails `json:"discount,omitempty"`
-	Message  string           `json:"message"`
+	IsValid  bool                `json:"is_valid"`
+	Discount *DiscountDetails    `json:"discount,omitempty"`
+	Message  string              `json:"message"`
+	Reason   string              `json:"reason,omitempty"`   // Reason code of the first failure, e.g. "EXPIRED"
+	Failures []ValidationFailure `json:"failures,omitempty"` // The first failure, or every failure when collect_all_failures is set
+}
+
+// Reason codes of validation failures. Unlike the messages they are stable, so clients can act on them.
+const (
+	ReasonCouponNotFound         = "COUPON_NOT_FOUND"
+	ReasonInactive               = "INACTIVE"
+	ReasonExpired                = "EXPIRED"                  // Params: expiry_date
+	ReasonNotYetActive           = "NOT_YET_ACTIVE"           // Params: valid_from
+	ReasonNoLongerActive         = "NO_LONGER_ACTIVE"         // Params: valid_until
+	ReasonOutsideRecurringWindow = "OUTSIDE_RECURRING_WINDOW" // Params: recurring_window, time_zone
+	ReasonMinOrderNotMet         = "MIN_ORDER_NOT_MET"        // Params: min_order_value, amount_needed
+	ReasonNoApplicableItems      = "NO_APPLICABLE_ITEMS"
+	ReasonNoApplicableCategories = "NO_APPLICABLE_CATEGORIES"
+	ReasonItemsExcluded          = "ITEMS_EXCLUDED"
+	ReasonNotEnoughUnits         = "NOT_ENOUGH_UNITS" // Params: buy_quantity, get_quantity
+	ReasonRuleNotMet             = "RULE_NOT_MET"     // Params: clause, column
+	ReasonRuleInvalid            = "RULE_INVALID"
+	ReasonUserLimitReached       = "USER_LIMIT_REACHED"  // Params: max_usage_per_user
+	ReasonTotalLimitReached      = "TOTAL_LIMIT_REACHED" // Params: max_total_usage
+	ReasonAlreadyRedeemed        = "ALREADY_REDEEMED"
+	ReasonNoTimeWindow           = "NO_TIME_WINDOW"
+)
+
+// @Description ValidationFailure represents one check a coupon fails, with a stable reason code and the values that explain it.
+type ValidationFailure struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Params  map[string]any `json:"params,omitempty"`
+}
+
+// @Description ValidateCouponStackRequest represents the request body for validating several coupons used together.
+type ValidateCouponStackRequest struct {
+	CouponCodes []string    `json:"coupon_codes" binding:"required,min=1,max=10,dive,required"`
+	CartItems   []CartItem  `json:"cart_items" binding:"required,dive"`
+	OrderTotal  money.Money `json:"order_total" binding:"required" swaggertype:"number"`
+	Timestamp   time.Time   `json:"timestamp" binding:"required"`
+	Channel     string      `json:"channel,omitempty"` // Channel the request comes from, e.g. app or web, for eligibility rules
+}
+
+// @Description AppliedCoupon represents one coupon of a stack and the discount it gives on what earlier coupons left over.
+type AppliedCoupon struct {
+	CouponCode string         `json:"coupon_code"`
+	Priority   int            `json:"priority"`
+	Discount   money.Money    `json:"discount" swaggertype:"number"`
+	CappedBy   string         `json:"capped_by,omitempty"`
+	Lines      []LineDiscount `json:"lines,omitempty"`
+}
+
+// Reasons a coupon is left out of a stack, reported in RejectedCoupon.Reason.
+const (
+	StackRejectionNotFound      = "not_found"      // No coupon has the code
+	StackRejectionNotApplicable = "not_applicable" // The coupon fails validation against the cart
+	StackRejectionDuplicate     = "duplicate"      // The code was given more than once
+	StackRejectionConflict      = "conflict"       // The coupon cannot be combined with a coupon that was applied before it
+)
+
+// @Description RejectedCoupon represents a coupon that was left out of a stack and why.
+type RejectedCoupon struct {
+	CouponCode    string             `json:"coupon_code"`
+	Reason        string             `json:"reason"`                   // "not_found", "not_applicable", "duplicate" or "conflict"
+	ConflictsWith string             `json:"conflicts_with,omitempty"` // The applied coupon it conflicts with, for "conflict"
+	Message       string             `json:"message"`
+	Failure       *ValidationFailure `json:"failure,omitempty"` // Why validation failed, for "not_applicable"
+}
+
+// @Description ValidateCouponStackResponse represents the coupons of a stack that apply to the cart, in the order they are applied, and the ones that do not.
+type ValidateCouponStackResponse struct {
+	IsValid         bool             `json:"is_valid"` // True when at least one coupon applies
+	AppliedCoupons  []AppliedCoupon  `json:"applied_coupons"`
+	RejectedCoupons []RejectedCoupon `json:"rejected_coupons"`
+	Discount        *DiscountDetails `json:"discount,omitempty"` // Combined discount of the applied coupons
+}
+
+// @Description CreateRedemptionRequest represents the request body for holding a coupon against an order. The coupon is checked against the server's current time, not the request's timestamp.
+type CreateRedemptionRequest struct {
+	OrderID string `json:"order_id" binding:"required"`
+	ValidateCouponRequest
+}
+
+// @Description RedemptionResponse represents a coupon hold and its current status.
+type RedemptionResponse struct {
+	RedemptionID string           `json:"redemption_id"`
+	CouponCode   string           `json:"coupon_code"`
+	OrderID      string           `json:"order_id"`
+	Status       string           `json:"status"`
+	ExpiresAt    time.Time        `json:"expires_at"`
+	Discount     *DiscountDetails `json:"discount,omitempty"`
+}
+
+// @Description CreateStackRedemptionRequest represents the request body for holding several coupons against an order. The coupons are checked against the server's current time, not the request's timestamp.
+type CreateStackRedemptionRequest struct {
+	OrderID string `json:"order_id" binding:"required"`
+	ValidateCouponStackRequest
+}
+
+// @Description StackRedemptionResponse represents the holds placed for the applied coupons of a stack.
+type StackRedemptionResponse struct {
+	Redemptions     []RedemptionResponse `json:"redemptions"`
+	RejectedCoupons []RejectedCoupon     `json:"rejected_coupons"`
+	Discount        *DiscountDetails     `json:"discount,omitempty"` // Combined discount of the held coupons
 }
 
 // @Description CartItem holds cart items
 type CartItem struct {
-	ID       string  `json:"id"`
-	Category string  `json:"category"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+	ID       string      `json:"id"`
+	Category string      `json:"category"`
+	Price    money.Money `json:"price" swaggertype:"number"` // Unit price
+	Quantity int         `json:"quantity" binding:"min=0"`   // Number of units, treated as 1 when omitted
 }
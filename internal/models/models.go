@@ -1,6 +1,7 @@
 package models
 
 import (
+	"coupon-system/internal/money"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,25 +9,88 @@ import (
 
 // Coupon represents a coupon entity.
 type Coupon struct {
-	ID                   string     `json:"id" gorm:"primaryKey;column:id" example:"f47ac10b-58cc-4372-a567-0e02b2c3d479"`                          // Auto-generated unique ID (e.g., UUID)
-	CouponCode           string     `json:"coupon_code" gorm:"unique;column:coupon_code" example:"SUMMER20"`                                        // User-facing unique identifier
-	ExpiryDate           time.Time  `json:"expiry_date" gorm:"column:expiry_date" example:"2024-12-31T23:59:59Z"`                                   // Expiry date of the coupon
-	UsageType            string     `json:"usage_type" gorm:"column:usage_type" example:"multi_use"`                                                // "one_time", "multi_use", "time_based"
-	MinOrderValue        float64    `json:"min_order_value" gorm:"column:min_order_value" example:"100.00"`                                         // Minimum order value for the coupon to be applicable
-	ValidTimeWindowStart *time.Time `json:"valid_time_window_start,omitempty" gorm:"column:valid_time_window_start" example:"2024-01-01T00:00:00Z"` // Pointer for optionality
-	MedicineIDs          []Medicine `gorm:"many2many:coupon_medicine_ids;"`
-	Categories           []Category `gorm:"many2many:coupon_categories;"`
-
-	ValidTimeWindowEnd *time.Time `json:"valid_time_window_end,omitempty" gorm:"column:valid_time_window_end" example:"2024-01-07T23:59:59Z"` // Pointer for optionality
-	TermsAndConditions string     `json:"terms_and_conditions" gorm:"column:terms_and_conditions" example:"Valid for new users only"`         // Terms and conditions for the coupon
-	DiscountType       string     `json:"discount_type" gorm:"column:discount_type" example:"percentage"`                                     // e.g., "percentage", "fixed_amount"
-	DiscountValue      float64    `json:"discount_value" gorm:"column:discount_value" example:"10.00"`                                        // The amount or percentage of discount
-	MaxUsagePerUser    int        `json:"max_usage_per_user" gorm:"column:max_usage_per_user" example:"1"`                                    // 0 for unlimited
-	MaxTotalUsage      int        `json:"max_total_usage" gorm:"column:max_total_usage" example:"100"`                                        // For "multi_use" if there's a global cap, 0 for unlimited
-	CurrentTotalUsage  int        `json:"current_total_usage" gorm:"column:current_total_usage" example:"50"`                                 // Current total usage of the coupon
-	CreatedAt          time.Time  `json:"created_at" gorm:"column:created_at" example:"2024-01-01T00:00:00Z"`                                 // Timestamp of when the coupon was created
-	UpdatedAt          time.Time  `json:"updated_at" gorm:"column:updated_at" example:"2024-01-01T00:00:00Z"`                                 // Timestamp of when the coupon was last updated
-	gorm.Model
+	ID                   string       `json:"id" gorm:"primaryKey;column:id" example:"f47ac10b-58cc-4372-a567-0e02b2c3d479"`                          // Auto-generated unique ID (e.g., UUID)
+	CouponCode           string       `json:"coupon_code" gorm:"unique;column:coupon_code" example:"SUMMER20"`                                        // User-facing unique identifier
+	ExpiryDate           time.Time    `json:"expiry_date" gorm:"column:expiry_date" example:"2024-12-31T23:59:59Z"`                                   // Expiry date of the coupon
+	UsageType            string       `json:"usage_type" gorm:"column:usage_type" example:"multi_use"`                                                // "one_time", "multi_use", "time_based"
+	MinOrderValue        money.Money  `json:"min_order_value" gorm:"embedded;embeddedPrefix:min_order_" swaggertype:"number" example:"100.00"`        // Minimum order value for the coupon to be applicable
+	ValidTimeWindowStart *time.Time   `json:"valid_time_window_start,omitempty" gorm:"column:valid_time_window_start" example:"2024-01-01T00:00:00Z"` // Pointer for optionality
+	MedicineIDs          []Medicine   `gorm:"many2many:coupon_medicine_ids;"`
+	Categories           []Category   `gorm:"many2many:coupon_categories;"`
+	TriggerMedicines     []Medicine   `gorm:"many2many:coupon_trigger_medicines;"`   // For "buy_x_get_y", medicines that count towards the units to buy
+	TriggerCategories    []Category   `gorm:"many2many:coupon_trigger_categories;"`  // For "buy_x_get_y", categories that count towards the units to buy
+	RewardMedicines      []Medicine   `gorm:"many2many:coupon_reward_medicines;"`    // For "buy_x_get_y", medicines that can be given as the reward
+	RewardCategories     []Category   `gorm:"many2many:coupon_reward_categories;"`   // For "buy_x_get_y", categories that can be given as the reward
+	ExcludedMedicines    []Medicine   `gorm:"many2many:coupon_excluded_medicines;"`  // Medicines the coupon never discounts
+	ExcludedCategories   []Category   `gorm:"many2many:coupon_excluded_categories;"` // Categories the coupon never discounts
+	Tiers                []CouponTier `gorm:"foreignKey:CouponID"`                   // For "tiered", the spend thresholds and their discounts, lowest threshold first
+
+	ValidTimeWindowEnd *time.Time    `json:"valid_time_window_end,omitempty" gorm:"column:valid_time_window_end" example:"2024-01-07T23:59:59Z"`     // Pointer for optionality
+	Recurrence         Recurrence    `json:"recurrence" gorm:"embedded;embeddedPrefix:recurring_"`                                                   // Repeating periods of local time the coupon is limited to, if any
+	TermsAndConditions string        `json:"terms_and_conditions" gorm:"column:terms_and_conditions" example:"Valid for new users only"`             // Terms and conditions for the coupon
+	EligibilityRule    string        `json:"eligibility_rule" gorm:"column:eligibility_rule" example:"channel = app"`                                // Extra conditions on the cart and request, see package rules; empty for none
+	DiscountType       string        `json:"discount_type" gorm:"column:discount_type" example:"percentage"`                                         // e.g., "percentage", "fixed_amount", "buy_x_get_y", "tiered"
+	DiscountAmount     money.Money   `json:"discount_amount" gorm:"embedded;embeddedPrefix:discount_" swaggertype:"number" example:"20.00"`          // Amount off, for "fixed_amount" coupons
+	DiscountPercent    money.Percent `json:"discount_percent" gorm:"column:discount_percent" swaggertype:"number" example:"10.00"`                   // Percentage off, for "percentage" coupons, or off the reward units for "buy_x_get_y" coupons
+	BuyQuantity        int           `json:"buy_quantity" gorm:"column:buy_quantity;not null;default:0" example:"2"`                                 // For "buy_x_get_y", units to buy for each reward
+	GetQuantity        int           `json:"get_quantity" gorm:"column:get_quantity;not null;default:0" example:"1"`                                 // For "buy_x_get_y", units given as the reward
+	MaxDiscountAmount  money.Money   `json:"max_discount_amount" gorm:"embedded;embeddedPrefix:max_discount_" swaggertype:"number" example:"100.00"` // Largest discount the coupon can give, 0 for no cap
+	MaxUsagePerUser    int           `json:"max_usage_per_user" gorm:"column:max_usage_per_user" example:"1"`                                        // 0 for unlimited
+	MaxTotalUsage      int           `json:"max_total_usage" gorm:"column:max_total_usage" example:"100"`                                            // For "multi_use" if there's a global cap, 0 for unlimited
+	CurrentTotalUsage  int           `json:"current_total_usage" gorm:"column:current_total_usage" example:"50"`                                     // Current total usage of the coupon
+	IsActive           bool          `json:"is_active" gorm:"column:is_active;not null;default:true" example:"true"`                                 // Inactive coupons are never applicable
+	Stackable          bool          `json:"stackable" gorm:"column:stackable;not null;default:false" example:"false"`                               // Whether the coupon can be combined with other coupons
+	ExclusivityGroup   string        `json:"exclusivity_group" gorm:"column:exclusivity_group" example:"sitewide"`                                   // Combined coupons must all be in different groups, empty for no group
+	Priority           int           `json:"priority" gorm:"column:priority;not null;default:0" example:"10"`                                        // Combined coupons are applied from the highest priority down
+	CreatedAt          time.Time     `json:"created_at" gorm:"column:created_at" example:"2024-01-01T00:00:00Z"`                                     // Timestamp of when the coupon was created
+	UpdatedAt          time.Time     `json:"updated_at" gorm:"column:updated_at" example:"2024-01-01T00:00:00Z"`                                     // Timestamp of when the coupon was last updated
+
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"column:deleted_at;index"` // Set when the coupon is deleted; deleted coupons are left out of queries
+}
+
+// Recurrence limits a coupon to repeating periods of local time, e.g. weekdays from 18:00 to 21:00 or the weekend
+// of the first week of the month. Local time is in the configured time zone. The zero value places no limit.
+type Recurrence struct {
+	Days        int `gorm:"column:days;not null;default:0"`         // Bit mask of weekdays, bit 0 for Sunday; 0 for every day
+	Weeks       int `gorm:"column:weeks;not null;default:0"`        // Bit mask of weeks of the month, bit 0 for week 1 (see WeekOfMonth); 0 for every week
+	StartMinute int `gorm:"column:start_minute;not null;default:0"` // Minute of the day the coupon becomes valid
+	EndMinute   int `gorm:"column:end_minute;not null;default:0"`   // Minute of the day it stops being valid, before StartMinute for periods past midnight; equal to StartMinute for the whole day
+}
+
+// WeekOfMonth returns the calendar week of the month a date falls in, from 1 to 6. Weeks run from Monday to
+// Sunday, so a weekend is never split, and week 1 is the one holding the 1st: when the 1st is a Sunday, week 1
+// is that day alone.
+func WeekOfMonth(date time.Time) int {
+	first := (int(date.Weekday()) - (date.Day()-1)%7 + 7) % 7 // Weekday of the 1st
+	before := (first + 6) % 7                                 // Days of week 1 in the previous month
+	return (date.Day()-1+before)/7 + 1
+}
+
+// CouponTier is one spend threshold of a "tiered" coupon and the discount an order reaching it gets.
+type CouponTier struct {
+	ID              uint          `gorm:"primaryKey"`
+	CouponID        string        `gorm:"column:coupon_id;index"`
+	Position        int           `gorm:"column:position"`                    // Tiers are numbered from 0 in order of their thresholds
+	Threshold       money.Money   `gorm:"embedded;embeddedPrefix:threshold_"` // Smallest order total that reaches the tier
+	DiscountType    string        `gorm:"column:discount_type"`               // "percentage" or "fixed_amount"
+	DiscountAmount  money.Money   `gorm:"embedded;embeddedPrefix:discount_"`
+	DiscountPercent money.Percent `gorm:"column:discount_percent"`
+}
+
+// Kinds of items a DiscountExclusion keeps out of discounts.
+const (
+	ExclusionKindMedicine = "medicine"
+	ExclusionKindCategory = "category"
+)
+
+// DiscountExclusion keeps a medicine, or every medicine of a category, out of the discount of every coupon,
+// e.g. controlled drugs or infant formula.
+type DiscountExclusion struct {
+	ID        string    `gorm:"primaryKey;column:id"`
+	Kind      string    `gorm:"column:kind;uniqueIndex:idx_discount_exclusions_item"`    // "medicine" or "category"
+	ItemID    string    `gorm:"column:item_id;uniqueIndex:idx_discount_exclusions_item"` // Medicine or category ID
+	Reason    string    `gorm:"column:reason"`
+	CreatedAt time.Time `gorm:"column:created_at"`
 }
 
 type UserCouponUsage struct {
@@ -42,3 +106,36 @@ type Medicine struct {
 type Category struct {
 	ID string `gorm:"primaryKey"`
 }
+
+// Usage types. A "one_time" coupon can be redeemed once in all. A "multi_use" coupon can be redeemed again,
+// by each user up to MaxUsagePerUser times. A "time_based" coupon is reused like a "multi_use" one but only
+// within its valid time window or recurring window, which it must have.
+const (
+	UsageTypeOneTime   = "one_time"
+	UsageTypeMultiUse  = "multi_use"
+	UsageTypeTimeBased = "time_based"
+)
+
+// Redemption statuses. A redemption starts out "held" and moves to exactly one
+// of the terminal statuses.
+const (
+	RedemptionStatusHeld      = "held"
+	RedemptionStatusCommitted = "committed"
+	RedemptionStatusReleased  = "released"
+	RedemptionStatusExpired   = "expired"
+)
+
+// CouponRedemption records a coupon hold placed for an order. A held redemption
+// counts against the coupon's usage limits until it is released or expires.
+type CouponRedemption struct {
+	ID            string      `gorm:"primaryKey;column:id"`
+	CouponID      string      `gorm:"column:coupon_id;index"`
+	UserID        string      `gorm:"column:user_id;index"`
+	OrderID       string      `gorm:"column:order_id;index"`
+	CouponCode    string      `gorm:"column:coupon_code"`
+	Status        string      `gorm:"column:status;index"`
+	TotalDiscount money.Money `gorm:"embedded;embeddedPrefix:total_discount_"`
+	ExpiresAt     time.Time   `gorm:"column:expires_at;index"`
+	CreatedAt     time.Time   `gorm:"column:created_at"`
+	UpdatedAt     time.Time   `gorm:"column:updated_at"`
+}
@@ -0,0 +1,290 @@
+// Package money represents amounts of money exactly, as integer minor units of a currency.
+//
+// Whenever an amount has to be rounded to whole minor units, for example when parsing "10.005" or taking a
+// percentage of a price, it is rounded half away from zero ("round half up"). Splitting an amount into parts
+// never rounds: see Money.Allocate.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strings"
+)
+
+// DefaultCurrency is the currency of amounts that are given without one, such as plain JSON numbers.
+const DefaultCurrency = "INR"
+
+// minorUnitDigits holds the number of decimal digits of a currency's minor unit. Currencies that are not
+// listed use two digits.
+var minorUnitDigits = map[string]int{
+	"INR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+}
+
+// ErrInvalidAmount is returned when a string is not a decimal number that fits in an amount.
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Money is an exact amount of money. The zero value is zero in no particular currency and takes on the
+// currency of whatever it is combined with.
+type Money struct {
+	Amount   int64  `gorm:"column:amount"`   // Minor units of the currency, e.g. paise for INR
+	Currency string `gorm:"column:currency"` // ISO 4217 currency code
+}
+
+// New returns an amount of minor units of a currency.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse parses a decimal string such as "149.99" into an amount of the currency.
+func Parse(s string, currency string) (Money, error) {
+	amount, err := parseFixed(s, digitsOf(currency))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// MustParse is like Parse but panics if the string cannot be parsed. It is meant for constants and seed data.
+func MustParse(s string, currency string) Money {
+	m, err := Parse(s, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// String formats the amount as a decimal number with as many fraction digits as the currency's minor unit.
+func (m Money) String() string {
+	return formatFixed(m.Amount, digitsOf(m.currencyOrDefault()))
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsPositive reports whether the amount is greater than zero.
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// IsNegative reports whether the amount is less than zero.
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// SameCurrency reports whether two amounts can be combined. The zero value combines with any currency.
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency == "" || o.Currency == "" || m.Currency == o.Currency
+}
+
+// Add returns m + o. Both amounts must be in the same currency.
+func (m Money) Add(o Money) Money {
+	return Money{Amount: m.Amount + o.Amount, Currency: m.combinedCurrency(o)}
+}
+
+// Sub returns m - o. Both amounts must be in the same currency.
+func (m Money) Sub(o Money) Money {
+	return Money{Amount: m.Amount - o.Amount, Currency: m.combinedCurrency(o)}
+}
+
+// Mul returns the amount multiplied by n, e.g. a unit price by a quantity.
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+// Cmp compares two amounts in the same currency and returns -1, 0 or +1.
+func (m Money) Cmp(o Money) int {
+	m.combinedCurrency(o)
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// LessThan reports whether m < o.
+func (m Money) LessThan(o Money) bool {
+	return m.Cmp(o) < 0
+}
+
+// Max returns the larger of two amounts in the same currency.
+func Max(a, b Money) Money {
+	if a.LessThan(b) {
+		return Money{Amount: b.Amount, Currency: a.combinedCurrency(b)}
+	}
+	return Money{Amount: a.Amount, Currency: a.combinedCurrency(b)}
+}
+
+// Min returns the smaller of two amounts in the same currency.
+func Min(a, b Money) Money {
+	if b.LessThan(a) {
+		return Money{Amount: b.Amount, Currency: a.combinedCurrency(b)}
+	}
+	return Money{Amount: a.Amount, Currency: a.combinedCurrency(b)}
+}
+
+// Allocate splits an amount into parts proportional to the non-negative weights. Every part is rounded towards
+// zero to whole minor units and the units left over go, one each, to the parts with the largest remainders, so
+// the parts always add up to the amount. If all weights are zero the amount is split evenly.
+func (m Money) Allocate(weights []int64) []Money {
+	parts := make([]Money, len(weights))
+	if len(weights) == 0 {
+		return parts
+	}
+	if m.IsNegative() {
+		// A negative amount splits like its magnitude
+		for i, part := range m.Mul(-1).Allocate(weights) {
+			parts[i] = part.Mul(-1)
+		}
+		return parts
+	}
+
+	var totalWeight int64
+	for _, w := range weights {
+		totalWeight += w
+	}
+	if totalWeight <= 0 {
+		weights = make([]int64, len(weights))
+		for i := range weights {
+			weights[i] = 1
+		}
+		totalWeight = int64(len(weights))
+	}
+
+	remainders := make([]int64, len(weights))
+	var allocated int64
+	for i, w := range weights {
+		q, r := mulDiv(m.Amount, w, totalWeight)
+		parts[i] = Money{Amount: q, Currency: m.Currency}
+		remainders[i] = r
+		allocated += q
+	}
+
+	for left := m.Amount - allocated; left > 0; left-- {
+		largest := 0
+		for i := range remainders {
+			if remainders[i] > remainders[largest] {
+				largest = i
+			}
+		}
+		parts[largest].Amount++
+		remainders[largest] = -1
+	}
+	return parts
+}
+
+// MarshalJSON encodes the amount as a JSON number in major units, e.g. 149.99.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number or numeric string in major units of the default currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	parsed, err := Parse(strings.Trim(string(data), `"`), DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) currencyOrDefault() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+// combinedCurrency returns the currency of an operation on m and o. Mixing currencies is a programming
+// error, so it panics rather than silently producing a meaningless amount.
+func (m Money) combinedCurrency(o Money) string {
+	if !m.SameCurrency(o) {
+		panic(fmt.Sprintf("money: currency mismatch: %s and %s", m.Currency, o.Currency))
+	}
+	if m.Currency == "" {
+		return o.Currency
+	}
+	return m.Currency
+}
+
+func digitsOf(currency string) int {
+	if digits, ok := minorUnitDigits[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+// parseFixed parses a decimal number, optionally in exponent notation, into an integer scaled by 10^digits,
+// rounding half away from zero.
+func parseFixed(s string, digits int) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.IndexFunc(s, func(r rune) bool { return !strings.ContainsRune("0123456789+-.eE", r) }) >= 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	value, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	scaled := value.Mul(value, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)))
+	quotient, remainder := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// Round half away from zero: compare twice the remainder with the denominator
+	if new(big.Int).Abs(new(big.Int).Lsh(remainder, 1)).Cmp(scaled.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(scaled.Num().Sign())))
+	}
+
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+	return quotient.Int64(), nil
+}
+
+// formatFixed formats an integer scaled by 10^digits as a decimal number.
+func formatFixed(value int64, digits int) string {
+	sign := ""
+	magnitude := uint64(value)
+	if value < 0 {
+		sign = "-"
+		magnitude = uint64(-value)
+	}
+	if digits == 0 {
+		return fmt.Sprintf("%s%d", sign, magnitude)
+	}
+
+	scale := uint64(math.Pow10(digits))
+	return fmt.Sprintf("%s%d.%0*d", sign, magnitude/scale, digits, magnitude%scale)
+}
+
+// mulDiv returns the quotient and remainder of a*b/c for positive c, without overflowing on the intermediate
+// product. As with Go's integer division, the quotient is truncated towards zero and the remainder has the sign
+// of a*b.
+func mulDiv(a, b, c int64) (int64, int64) {
+	hi, lo := bits.Mul64(magnitude(a), magnitude(b))
+	q, r := bits.Div64(hi, lo, uint64(c))
+	if (a < 0) != (b < 0) {
+		return -int64(q), -int64(r)
+	}
+	return int64(q), int64(r)
+}
+
+// magnitude returns the absolute value of n, which for math.MinInt64 only fits in a uint64.
+func magnitude(n int64) uint64 {
+	if n < 0 {
+		return uint64(-n)
+	}
+	return uint64(n)
+}
@@ -0,0 +1,52 @@
+package money
+
+import "testing"
+
+func inr(amount string) Money {
+	return MustParse(amount, DefaultCurrency)
+}
+
+func TestPercentOfRoundsHalfAwayFromZero(t *testing.T) {
+	tests := []struct {
+		percent string
+		amount  string
+		want    string
+	}{
+		{"10.00", "100.00", "10.00"},
+		{"10.00", "0.05", "0.01"},
+		{"10.00", "0.04", "0.00"},
+		{"10.00", "-100.00", "-10.00"},
+		{"10.00", "-0.05", "-0.01"},
+		{"10.00", "-0.04", "0.00"},
+		{"-10.00", "0.05", "-0.01"},
+		{"-10.00", "-0.05", "0.01"},
+		{"33.33", "92233720368547758.07", "30741498998836967.76"},
+	}
+	for _, tt := range tests {
+		if got := MustParsePercent(tt.percent).Of(inr(tt.amount)); got.Cmp(inr(tt.want)) != 0 {
+			t.Errorf("%s%% of %s is %s, want %s", tt.percent, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestAllocateAddsUpToTheAmount(t *testing.T) {
+	tests := []struct {
+		amount  string
+		weights []int64
+		want    []string
+	}{
+		{"10.00", []int64{1, 1, 1}, []string{"3.34", "3.33", "3.33"}},
+		{"-10.00", []int64{1, 1, 1}, []string{"-3.34", "-3.33", "-3.33"}},
+		{"1.00", []int64{0, 0}, []string{"0.50", "0.50"}},
+		{"0.05", []int64{300, 100}, []string{"0.04", "0.01"}},
+	}
+	for _, tt := range tests {
+		parts := inr(tt.amount).Allocate(tt.weights)
+		for i, part := range parts {
+			if part.Cmp(inr(tt.want[i])) != 0 {
+				t.Errorf("allocating %s by %v gave %v, want %v", tt.amount, tt.weights, parts, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package money
+
+import "strings"
+
+// percentDigits is the number of decimal digits kept for percentages: a Percent counts basis points.
+const percentDigits = 2
+
+// Percent is an exact percentage in hundredths of a percent (basis points), so 1550 is 15.5%.
+type Percent int64
+
+// ParsePercent parses a decimal string such as "15.5" into a percentage.
+func ParsePercent(s string) (Percent, error) {
+	value, err := parseFixed(s, percentDigits)
+	if err != nil {
+		return 0, err
+	}
+	return Percent(value), nil
+}
+
+// MustParsePercent is like ParsePercent but panics if the string cannot be parsed. It is meant for constants and seed data.
+func MustParsePercent(s string) Percent {
+	p, err := ParsePercent(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String formats the percentage as a decimal number, e.g. "15.50".
+func (p Percent) String() string {
+	return formatFixed(int64(p), percentDigits)
+}
+
+// Of returns the percentage of an amount, rounded half away from zero to whole minor units.
+func (p Percent) Of(m Money) Money {
+	q, r := mulDiv(m.Amount, int64(p), 100*100)
+	switch {
+	case 2*r >= 100*100:
+		q++
+	case 2*r <= -100*100:
+		q--
+	}
+	return Money{Amount: q, Currency: m.Currency}
+}
+
+// MarshalJSON encodes the percentage as a JSON number, e.g. 15.50.
+func (p Percent) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number or numeric string.
+func (p *Percent) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	parsed, err := ParsePercent(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
@@ -0,0 +1,335 @@
+package rules
+
+import (
+	"coupon-system/internal/money"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind is the kind of a lexical token of a rule.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenNumber
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	text  string // Unquoted for strings
+	start int
+	end   int
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of rule"
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// isKeyword reports whether a word token is the given keyword, which is case insensitive.
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+func isKeyword(word string) bool {
+	switch strings.ToUpper(word) {
+	case "AND", "OR", "NOT", "IN":
+		return true
+	}
+	return false
+}
+
+// lex splits a rule into tokens. Token offsets are in bytes; errors report columns in runes.
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c, size := utf8.DecodeRuneInString(source[i:])
+		switch {
+		case c == utf8.RuneError && size == 1:
+			return nil, &Error{Column: column(source, i), Message: "invalid UTF-8"}
+		case unicode.IsSpace(c):
+			i += size
+		case c == '(' || c == ')' || c == ',':
+			kind := map[rune]tokenKind{'(': tokenLParen, ')': tokenRParen, ',': tokenComma}[c]
+			tokens = append(tokens, token{kind: kind, text: string(c), start: i, end: i + 1})
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexRune(source[i+1:], c)
+			if end < 0 {
+				return nil, &Error{Column: column(source, i), Message: "unterminated string"}
+			}
+			end += i + 1
+			tokens = append(tokens, token{kind: tokenString, text: source[i+1 : end], start: i, end: end + 1})
+			i = end + 1
+		case strings.ContainsRune("=!<>", c):
+			end := i + 1
+			if end < len(source) && source[end] == '=' {
+				end++
+			}
+			op := source[i:end]
+			if op == "!" {
+				return nil, &Error{Column: column(source, i), Message: `unexpected "!", did you mean "!="?`}
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, start: i, end: end})
+			i = end
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			end := scan(source, i+size, func(c rune) bool { return c == '.' || unicode.IsDigit(c) })
+			tokens = append(tokens, token{kind: tokenNumber, text: source[i:end], start: i, end: end})
+			i = end
+		case c == '_' || unicode.IsLetter(c):
+			end := scan(source, i+size, isWordChar)
+			tokens = append(tokens, token{kind: tokenWord, text: source[i:end], start: i, end: end})
+			i = end
+		default:
+			return nil, &Error{Column: column(source, i), Message: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, start: len(source), end: len(source)}), nil
+}
+
+// scan returns the offset of the first rune at or after i that does not satisfy accept.
+func scan(source string, i int, accept func(rune) bool) int {
+	for i < len(source) {
+		c, size := utf8.DecodeRuneInString(source[i:])
+		if c == utf8.RuneError && size == 1 || !accept(c) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// column returns the 1-based column, counted in runes, of a byte offset into a rule.
+func column(source string, offset int) int {
+	return utf8.RuneCountInString(source[:offset]) + 1
+}
+
+func isWordChar(c rune) bool {
+	return c == '_' || c == '-' || c == '.' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// parser is a recursive descent parser for the grammar
+//
+//	expr       = and { OR and }
+//	and        = unary { AND unary }
+//	unary      = NOT unary | "(" expr ")" | comparison
+//	comparison = variable operator literal | variable [ NOT ] IN "(" literal { "," literal } ")"
+type parser struct {
+	source      string
+	tokens      []token
+	pos         int
+	perLine     bool
+	countsLines bool
+}
+
+// Parse parses and type checks a rule. Errors are of type *Error and point to the offending clause.
+func Parse(source string) (*Rule, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return nil, &Error{Column: 1, Message: "rule is empty"}
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{source: source, tokens: tokens}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, p.errorAt(next, fmt.Sprintf("unexpected %s, expected AND, OR or the end of the rule", next.describe()))
+	}
+	return &Rule{source: source, root: root, perLine: p.perLine, countsLines: p.countsLines}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorAt(t token, message string) *Error {
+	return &Error{Column: column(p.source, t.start), Message: message}
+}
+
+// spanOf returns the source between the start of one token and the end of the last token read.
+func (p *parser) spanOf(first token) span {
+	end := p.tokens[p.pos-1].end
+	return span{start: first.start, text: p.source[first.start:end]}
+}
+
+func (p *parser) expr() (node, error) {
+	first := p.peek()
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("OR") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right, src: p.spanOf(first)}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	first := p.peek()
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("AND") {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right, src: p.spanOf(first)}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (node, error) {
+	first := p.peek()
+	switch {
+	case first.isKeyword("NOT"):
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand, src: p.spanOf(first)}, nil
+	case first.kind == tokenLParen:
+		p.next()
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorAt(closing, fmt.Sprintf("expected \")\", found %s", closing.describe()))
+		}
+		return inner, nil
+	default:
+		return p.comparison()
+	}
+}
+
+func (p *parser) comparison() (node, error) {
+	name := p.next()
+	if name.kind != tokenWord || isKeyword(name.text) {
+		return nil, p.errorAt(name, fmt.Sprintf("expected a variable, found %s", name.describe()))
+	}
+	v, ok := variables[name.text]
+	if !ok {
+		return nil, p.errorAt(name, fmt.Sprintf("unknown variable %q", name.text))
+	}
+	p.perLine = p.perLine || v.perLine
+	p.countsLines = p.countsLines || v.countsLines
+
+	var op string
+	switch t := p.next(); {
+	case t.kind == tokenOperator:
+		op = t.text
+	case t.isKeyword("IN"):
+		op = "IN"
+	case t.isKeyword("NOT") && p.peek().isKeyword("IN"):
+		p.next()
+		op = "NOT IN"
+	default:
+		return nil, p.errorAt(t, fmt.Sprintf("expected a comparison operator after %s, found %s", name.text, t.describe()))
+	}
+
+	var literals []token
+	if op == "IN" || op == "NOT IN" {
+		if open := p.next(); open.kind != tokenLParen {
+			return nil, p.errorAt(open, fmt.Sprintf("expected \"(\" after %s, found %s", op, open.describe()))
+		}
+		for {
+			literal, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			literals = append(literals, literal)
+			if t := p.next(); t.kind == tokenRParen {
+				break
+			} else if t.kind != tokenComma {
+				return nil, p.errorAt(t, fmt.Sprintf("expected \",\" or \")\", found %s", t.describe()))
+			}
+		}
+	} else {
+		literal, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		literals = append(literals, literal)
+	}
+
+	c := &comparison{src: p.spanOf(name), variable: v, op: op}
+	for _, literal := range literals {
+		if err := typeCheck(name.text, v, op, literal); err != "" {
+			return nil, &Error{Column: column(p.source, c.src.start), Clause: c.src.text, Message: err}
+		}
+		c.literals = append(c.literals, literal.text)
+	}
+	return c, nil
+}
+
+func (p *parser) literal() (token, error) {
+	t := p.next()
+	if t.kind == tokenString || t.kind == tokenNumber || t.kind == tokenWord && !isKeyword(t.text) {
+		return t, nil
+	}
+	return token{}, p.errorAt(t, fmt.Sprintf("expected a value, found %s", t.describe()))
+}
+
+// typeCheck returns why a literal cannot be compared with a variable, or "" if it can.
+func typeCheck(name string, v variable, op string, literal token) string {
+	switch v.typ {
+	case typeNumber:
+		if _, err := parseNumber(literal.text); literal.kind != tokenNumber || err != nil {
+			return fmt.Sprintf("%s is a whole number and cannot be compared with %s", name, literal.describe())
+		}
+	case typeAmount:
+		if _, err := money.Parse(literal.text, money.DefaultCurrency); literal.kind != tokenNumber || err != nil {
+			return fmt.Sprintf("%s is an amount and cannot be compared with %s", name, literal.describe())
+		}
+	default:
+		if literal.kind == tokenNumber {
+			return fmt.Sprintf("%s is a string and cannot be compared with the number %s; quote it", name, literal.text)
+		}
+		if op != "=" && op != "!=" && op != "IN" && op != "NOT IN" {
+			return fmt.Sprintf("%s is a string and only supports =, !=, IN and NOT IN", name)
+		}
+	}
+	return ""
+}
+
+func parseNumber(s string) (int64, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
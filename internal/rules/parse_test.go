@@ -0,0 +1,111 @@
+package rules
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestLexSplitsRulesIntoTokens(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{"channel=app", []string{"channel", "=", "app"}},
+		{"quantity>=3 AND price<-1.5", []string{"quantity", ">=", "3", "AND", "price", "<", "-1.5"}},
+		{"user_id NOT IN ('u 1', \"u,2\")", []string{"user_id", "NOT", "IN", "(", "u 1", ",", "u,2", ")"}},
+		{"category != Médicaments", []string{"category", "!=", "Médicaments"}},
+		{"naïve_ß-2.x=Thé", []string{"naïve_ß-2.x", "=", "Thé"}},
+		{"category =　'茶'", []string{"category", "=", "茶"}},
+	}
+	for _, tt := range tests {
+		tokens, err := lex(tt.source)
+		if err != nil {
+			t.Errorf("lex(%q) returned %v", tt.source, err)
+			continue
+		}
+		var got []string
+		for _, token := range tokens[:len(tokens)-1] {
+			got = append(got, token.text)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("lex(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
+
+// Errors point to the offending token or clause, counting columns in runes rather than bytes.
+func TestParseReportsErrorsWithTheirColumn(t *testing.T) {
+	tests := []struct {
+		source  string
+		column  int
+		clause  string
+		message string
+	}{
+		// Lexing
+		{"", 1, "", "rule is empty"},
+		{"channel = 'app", 11, "", "unterminated string"},
+		{"channel ! app", 9, "", `unexpected "!", did you mean "!="?`},
+		{"channel = app #", 15, "", `unexpected character '#'`},
+		{"category = 'Thé' AND quantity ! 3", 31, "", `unexpected "!", did you mean "!="?`},
+		{"channel = \xff", 11, "", "invalid UTF-8"},
+
+		// Parsing
+		{"channel = app AND", 18, "", "expected a variable, found end of rule"},
+		{"colour = red", 1, "", `unknown variable "colour"`},
+		{"catégorie = Thé", 1, "", `unknown variable "catégorie"`},
+		{"category = Médicaments OR é = 1", 27, "", `unknown variable "é"`},
+		{"channel app", 9, "", `expected a comparison operator after channel, found "app"`},
+		{"channel IN app", 12, "", `expected "(" after IN, found "app"`},
+		{"channel IN (app web)", 17, "", `expected "," or ")", found "web"`},
+		{"(channel = app", 15, "", `expected ")", found end of rule`},
+		{"channel = app web", 15, "", `unexpected "web", expected AND, OR or the end of the rule`},
+		{"channel = AND", 11, "", `expected a value, found "AND"`},
+		{"channel = 'Thé' AND (", 22, "", "expected a variable, found end of rule"},
+
+		// Type checking
+		{"quantity >= 'three'", 1, "quantity >= 'three'", `quantity is a whole number and cannot be compared with "three"`},
+		{"item_count = 1.5", 1, "item_count = 1.5", `item_count is a whole number and cannot be compared with "1.5"`},
+		{"order_total > abc", 1, "order_total > abc", `order_total is an amount and cannot be compared with "abc"`},
+		{"channel = 5", 1, "channel = 5", "channel is a string and cannot be compared with the number 5; quote it"},
+		{"channel < app", 1, "channel < app", "channel is a string and only supports =, !=, IN and NOT IN"},
+		{"channel = app AND quantity IN (1, two)", 19, "quantity IN (1, two)", `quantity is a whole number and cannot be compared with "two"`},
+		{"category = 'Thé' AND price > x", 22, "price > x", `price is an amount and cannot be compared with "x"`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.source)
+		var ruleErr *Error
+		if !errors.As(err, &ruleErr) {
+			t.Errorf("Parse(%q) returned %v, want an *Error", tt.source, err)
+			continue
+		}
+		if ruleErr.Column != tt.column || ruleErr.Clause != tt.clause || ruleErr.Message != tt.message {
+			t.Errorf("Parse(%q) returned column %d, clause %q, %q, want column %d, clause %q, %q",
+				tt.source, ruleErr.Column, ruleErr.Clause, ruleErr.Message, tt.column, tt.clause, tt.message)
+		}
+	}
+}
+
+func TestParseAcceptsWellFormedRules(t *testing.T) {
+	tests := []struct {
+		source      string
+		countsLines bool
+	}{
+		{"channel = app", false},
+		{"  order_total >= 500  ", false},
+		{"category = Thé and not (quantity < 2 or price > 10.50)", true},
+		{"medicine_id in (med1, 'med 2', \"med3\")", false},
+		{"line_count > 1", true},
+		{"line_total >= 100", true},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.source)
+		if err != nil {
+			t.Errorf("Parse(%q) returned %v", tt.source, err)
+			continue
+		}
+		if rule.CountsLines() != tt.countsLines {
+			t.Errorf("Parse(%q).CountsLines() = %t, want %t", tt.source, rule.CountsLines(), tt.countsLines)
+		}
+	}
+}
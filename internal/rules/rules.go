@@ -0,0 +1,285 @@
+// Package rules implements the eligibility rules a coupon can carry, a small expression language over the
+// cart, the user and the request, for example:
+//
+//	category = Vitamins AND quantity >= 3 AND channel = app
+//
+// A rule is a comparison or a combination of comparisons with AND, OR, NOT and parentheses. A comparison
+// compares a variable with a literal using =, !=, <, <=, >, >=, IN (...) or NOT IN (...). Strings are quoted
+// with single or double quotes, or written bare when they look like identifiers, e.g. Vitamins or med1.
+// Keywords are case insensitive; variables are not.
+//
+// Variables are typed, and rules are type checked when they are parsed:
+//
+//	order_total   amount  the order total
+//	item_count    number  the number of units in the cart
+//	line_count    number  the number of lines in the cart
+//	channel       string  the channel the request came from, e.g. app or web
+//	user_id       string  the user placing the order
+//	medicine_id   string  a cart line's medicine
+//	category      string  a cart line's category
+//	quantity      number  a cart line's number of units
+//	price         amount  a cart line's unit price
+//	line_total    amount  a cart line's price times its quantity
+//
+// The last five describe a single cart line. A rule that uses any of them holds when some line of the cart
+// satisfies the whole rule, so "category = Vitamins AND quantity >= 3" asks for a line of at least three
+// units of vitamins.
+package rules
+
+import (
+	"cmp"
+	"coupon-system/internal/money"
+	"fmt"
+	"slices"
+)
+
+// Rule is a parsed and type checked eligibility rule.
+type Rule struct {
+	source      string
+	root        node
+	perLine     bool // Whether the rule uses line variables and so is checked against each cart line
+	countsLines bool // Whether the rule uses a variable that changes when a line is split in two
+}
+
+// Env is what a rule is evaluated against.
+type Env struct {
+	OrderTotal money.Money
+	Channel    string
+	UserID     string
+	Lines      []Line
+}
+
+// Line is a cart line as seen by a rule.
+type Line struct {
+	MedicineID string
+	Category   string
+	Price      money.Money // Unit price
+	Quantity   int
+}
+
+// Error is a rule that cannot be parsed or type checked, or a clause of a rule that is not met.
+type Error struct {
+	Column  int    // 1-based column of the clause in the rule
+	Clause  string // Source text of the clause
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Clause == "" {
+		return fmt.Sprintf("column %d: %s", e.Column, e.Message)
+	}
+	return fmt.Sprintf("column %d, %q: %s", e.Column, e.Clause, e.Message)
+}
+
+// CountsLines reports whether the rule can tell a cart line apart from the same units split over several
+// lines of the same medicine, category and price, which it can when it uses line_count, quantity or
+// line_total.
+func (r *Rule) CountsLines() bool {
+	return r.countsLines
+}
+
+// String returns the rule as it was written.
+func (r *Rule) String() string {
+	return r.source
+}
+
+// Evaluate checks the rule against the cart and request. It returns nil when the rule holds, or an *Error
+// naming the clause that is not met.
+func (r *Rule) Evaluate(env Env) error {
+	facts := newFacts(env)
+	if !r.perLine {
+		if failed := r.root.eval(facts); failed != nil {
+			return notMet(r.source, failed)
+		}
+		return nil
+	}
+
+	// Report the clause of the line that got furthest through the rule, which is the most useful one when
+	// several lines fail for different reasons
+	var furthest node
+	for _, line := range env.Lines {
+		facts.line = line
+		failed := r.root.eval(facts)
+		if failed == nil {
+			return nil
+		}
+		if furthest == nil || failed.span().start > furthest.span().start {
+			furthest = failed
+		}
+	}
+	if furthest == nil {
+		return &Error{Column: 1, Clause: r.source, Message: "the cart has no lines"}
+	}
+	return notMet(r.source, furthest)
+}
+
+func notMet(source string, failed node) error {
+	s := failed.span()
+	return &Error{Column: column(source, s.start), Clause: s.text, Message: "not met"}
+}
+
+// facts are the values of the variables while a rule is evaluated.
+type facts struct {
+	env       Env
+	itemCount int
+	line      Line
+}
+
+func newFacts(env Env) *facts {
+	f := &facts{env: env}
+	for _, line := range env.Lines {
+		f.itemCount += line.Quantity
+	}
+	return f
+}
+
+// valueType is the type of a variable.
+type valueType int
+
+const (
+	typeString valueType = iota
+	typeNumber
+	typeAmount
+)
+
+// variable is a value a rule can compare against.
+type variable struct {
+	typ         valueType
+	perLine     bool
+	countsLines bool // Whether the value changes when a line is split into lines of the same item and price
+	str         func(*facts) string
+	num         func(*facts) int64
+	amount      func(*facts) money.Money
+}
+
+var variables = map[string]variable{
+	"order_total": {typ: typeAmount, amount: func(f *facts) money.Money { return f.env.OrderTotal }},
+	"item_count":  {typ: typeNumber, num: func(f *facts) int64 { return int64(f.itemCount) }},
+	"line_count":  {typ: typeNumber, countsLines: true, num: func(f *facts) int64 { return int64(len(f.env.Lines)) }},
+	"channel":     {typ: typeString, str: func(f *facts) string { return f.env.Channel }},
+	"user_id":     {typ: typeString, str: func(f *facts) string { return f.env.UserID }},
+	"medicine_id": {typ: typeString, perLine: true, str: func(f *facts) string { return f.line.MedicineID }},
+	"category":    {typ: typeString, perLine: true, str: func(f *facts) string { return f.line.Category }},
+	"quantity":    {typ: typeNumber, perLine: true, countsLines: true, num: func(f *facts) int64 { return int64(f.line.Quantity) }},
+	"price":       {typ: typeAmount, perLine: true, amount: func(f *facts) money.Money { return f.line.Price }},
+	"line_total": {typ: typeAmount, perLine: true, countsLines: true, amount: func(f *facts) money.Money {
+		return f.line.Price.Mul(int64(f.line.Quantity))
+	}},
+}
+
+// span is the part of the rule's source a node was parsed from.
+type span struct {
+	start int // Byte offset into the rule's source
+	text  string
+}
+
+// node is a parsed expression. eval returns nil when the expression holds, or the node of the clause that
+// made it fail.
+type node interface {
+	eval(f *facts) node
+	span() span
+}
+
+type andNode struct {
+	src         span
+	left, right node
+}
+
+func (n *andNode) eval(f *facts) node {
+	if failed := n.left.eval(f); failed != nil {
+		return failed
+	}
+	return n.right.eval(f)
+}
+
+func (n *andNode) span() span { return n.src }
+
+type orNode struct {
+	src         span
+	left, right node
+}
+
+func (n *orNode) eval(f *facts) node {
+	if n.left.eval(f) == nil || n.right.eval(f) == nil {
+		return nil
+	}
+	return n
+}
+
+func (n *orNode) span() span { return n.src }
+
+type notNode struct {
+	src     span
+	operand node
+}
+
+func (n *notNode) eval(f *facts) node {
+	if n.operand.eval(f) == nil {
+		return n
+	}
+	return nil
+}
+
+func (n *notNode) span() span { return n.src }
+
+// comparison compares a variable with one or more literals. Amount literals are kept as written and parsed
+// in the currency of the value they are compared with.
+type comparison struct {
+	src      span
+	variable variable
+	op       string
+	literals []string
+}
+
+func (n *comparison) eval(f *facts) node {
+	if n.holds(f) {
+		return nil
+	}
+	return n
+}
+
+func (n *comparison) span() span { return n.src }
+
+func (n *comparison) holds(f *facts) bool {
+	switch n.op {
+	case "IN":
+		return slices.ContainsFunc(n.literals, func(literal string) bool { return n.compare(f, literal) == 0 })
+	case "NOT IN":
+		return !slices.ContainsFunc(n.literals, func(literal string) bool { return n.compare(f, literal) == 0 })
+	}
+
+	c := n.compare(f, n.literals[0])
+	switch n.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// compare compares the variable's value with a literal that was type checked against it.
+func (n *comparison) compare(f *facts, literal string) int {
+	switch n.variable.typ {
+	case typeNumber:
+		value, _ := parseNumber(literal)
+		return cmp.Compare(n.variable.num(f), value)
+	case typeAmount:
+		value := n.variable.amount(f)
+		currency := value.Currency
+		if currency == "" {
+			currency = money.DefaultCurrency
+		}
+		amount, _ := money.Parse(literal, currency)
+		return cmp.Compare(value.Amount, amount.Amount)
+	default:
+		return cmp.Compare(n.variable.str(f), literal)
+	}
+}
@@ -0,0 +1,98 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+
+	"coupon-system/internal/money"
+)
+
+func inr(amount string) money.Money {
+	return money.MustParse(amount, money.DefaultCurrency)
+}
+
+var testEnv = Env{
+	OrderTotal: inr("100.00"),
+	Channel:    "app",
+	UserID:     "u1",
+	Lines: []Line{
+		{MedicineID: "med1", Category: "Vitamins", Price: inr("10.00"), Quantity: 3},
+		{MedicineID: "med2", Category: "Thé", Price: inr("25.50"), Quantity: 2},
+	},
+}
+
+func TestEvaluateAppliesEachOperator(t *testing.T) {
+	tests := []struct {
+		source string
+		holds  bool
+	}{
+		{"channel = app", true},
+		{"channel = web", false},
+		{"channel != web", true},
+		{"channel != app", false},
+		{"item_count < 6", true},
+		{"item_count < 5", false},
+		{"item_count <= 5", true},
+		{"item_count <= 4", false},
+		{"order_total > 99.99", true},
+		{"order_total > 100", false},
+		{"order_total >= 100.00", true},
+		{"order_total >= 100.01", false},
+		{"user_id IN (u1, u2)", true},
+		{"user_id IN ('u2')", false},
+		{"channel NOT IN (web, pos)", true},
+		{"channel not in (app)", false},
+		{"category = Vitamins AND quantity >= 3", true},
+		{"category = Vitamins AND quantity > 3", false},
+		{"channel = web OR line_count = 2", true},
+		{"channel = web OR line_count = 1", false},
+		{"NOT channel = web", true},
+		{"NOT channel = app", false},
+		{"NOT (channel = web OR item_count > 5)", true},
+		{"(channel = web OR item_count > 4) AND price < 10", false},
+		{"category = Thé AND line_total = 51.00", true},
+		{"medicine_id = med2 AND price > 25", true},
+		{"medicine_id = med1 AND price > 25", false},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.source)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned %v", tt.source, err)
+		}
+		if err := rule.Evaluate(testEnv); (err == nil) != tt.holds {
+			t.Errorf("Evaluate(%q) returned %v, want the rule to hold: %t", tt.source, err, tt.holds)
+		}
+	}
+}
+
+// A rule that is not met names the clause that failed, for line rules the one of the line that got furthest.
+func TestEvaluateReportsTheClauseNotMet(t *testing.T) {
+	tests := []struct {
+		source  string
+		env     Env
+		column  int
+		clause  string
+		message string
+	}{
+		{"channel = app AND item_count > 5", testEnv, 19, "item_count > 5", "not met"},
+		{"channel = web OR line_count = 1", testEnv, 1, "channel = web OR line_count = 1", "not met"},
+		{"NOT channel = app", testEnv, 1, "NOT channel = app", "not met"},
+		{"category = 'Thé' AND quantity > 5", testEnv, 22, "quantity > 5", "not met"},
+		{"quantity > 1", Env{}, 1, "quantity > 1", "the cart has no lines"},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.source)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned %v", tt.source, err)
+		}
+		var ruleErr *Error
+		if err := rule.Evaluate(tt.env); !errors.As(err, &ruleErr) {
+			t.Errorf("Evaluate(%q) returned %v, want an *Error", tt.source, err)
+			continue
+		}
+		if ruleErr.Column != tt.column || ruleErr.Clause != tt.clause || ruleErr.Message != tt.message {
+			t.Errorf("Evaluate(%q) returned column %d, clause %q, %q, want column %d, clause %q, %q",
+				tt.source, ruleErr.Column, ruleErr.Clause, ruleErr.Message, tt.column, tt.clause, tt.message)
+		}
+	}
+}
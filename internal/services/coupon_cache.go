@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"coupon-system/internal/caching"
+	"coupon-system/internal/models"
+)
+
+// Cached applicable coupons results are tagged with the user they were computed for, with every coupon they
+// list and with every coupon they left out for being used up overall, so a change can drop just the results it
+// affects:
+//
+//   - creating or editing a coupon, or changing the exclusions, can make a coupon appear in any result, so it
+//     drops them all;
+//   - deleting a coupon only takes it out of the results that list it;
+//   - holding a coupon changes the user's usage of it, and, if the coupon has a total cap, can take it out of
+//     every result that lists it;
+//   - giving a hold back, or a hold expiring, changes the user's usage, and, if the coupon has a total cap, can
+//     bring it back into the results that left it out.
+//
+// Only edits drop every result, so the invalidations of a shared cache tell another server which kind of
+// change it made: dropping every result means coupons or the exclusions were edited, so the coupon index and
+// lookups are loaded again, and a deletion also drops the deleted coupon's tag, which no result has, so it is
+// taken out of both. Changes in usage need neither, since usage is read from the storage when matching coupons.
+//
+// Creating, editing or deleting a coupon and changing the exclusions also update the coupon index here, and
+// coupon changes drop the coupons looked up by code.
+
+// userCacheTag tags the cached results of a user.
+func userCacheTag(userID string) string {
+	return "user:" + userID
+}
+
+// couponCacheTag tags the cached results that list a coupon.
+func couponCacheTag(couponID string) string {
+	return "coupon:" + couponID
+}
+
+// deletedCouponTag marks the invalidations of deleted coupons. No result has it.
+func deletedCouponTag(couponID string) string {
+	return "deleted:" + couponID
+}
+
+// applicableCouponsTags returns the tags of a result computed for a user that lists coupons, leaving out the
+// used up coupons with the given IDs.
+func applicableCouponsTags(userID string, usedUp []string, coupons ...[]models.Coupon) []string {
+	tags := []string{userCacheTag(userID)}
+	for _, couponID := range usedUp {
+		tags = append(tags, couponCacheTag(couponID))
+	}
+	for _, list := range coupons {
+		for i := range list {
+			tags = append(tags, couponCacheTag(list[i].ID))
+		}
+	}
+	return tags
+}
+
+// ApplicableCouponsCacheStats reports how often applicable coupons results were served from the cache since
+// the service started.
+func (s *CouponService) ApplicableCouponsCacheStats() *models.CacheStatsResponse {
+	hits, misses, hitRate := s.applicableCouponsStats.Snapshot()
+	return &models.CacheStatsResponse{Hits: hits, Misses: misses, HitRate: hitRate}
+}
+
+// couponSaved updates the coupon index and drops every cached result after a coupon is created or edited.
+func (s *CouponService) couponSaved(ctx context.Context, couponID string) {
+	index, version := s.startCouponIndexChange()
+	if index != nil {
+		coupon, err := s.storage.GetCouponByID(ctx, couponID)
+		if err != nil {
+			log.Printf("failed to fetch coupon %s to update the coupon index, it will be loaded again: %v", couponID, err)
+			s.resetCouponIndex()
+		} else {
+			index.saveCoupon(version, couponID, coupon, time.Now())
+		}
+	}
+	s.forgetCouponLookups()
+	s.applicableCouponsCache.InvalidateAll()
+}
+
+// exclusionsChanged updates the coupon index and drops every cached result after the exclusions change.
+func (s *CouponService) exclusionsChanged(ctx context.Context) {
+	index, version := s.startCouponIndexChange()
+	if index != nil {
+		exclusions, err := s.getExclusions(ctx)
+		if err != nil {
+			log.Printf("failed to fetch the exclusions to update the coupon index, it will be loaded again: %v", err)
+			s.resetCouponIndex()
+		} else {
+			index.changeExclusions(version, exclusions)
+		}
+	}
+	s.applicableCouponsCache.InvalidateAll()
+}
+
+// couponDeleted takes a deleted coupon out of the coupon index and drops the cached results that list it.
+func (s *CouponService) couponDeleted(couponID string) {
+	s.forgetDeletedCoupon(couponID)
+	s.applicableCouponsCache.Invalidate(couponCacheTag(couponID), deletedCouponTag(couponID))
+}
+
+// forgetDeletedCoupon takes a deleted coupon out of the coupon index and drops the coupons looked up by code.
+func (s *CouponService) forgetDeletedCoupon(couponID string) {
+	if index, version := s.startCouponIndexChange(); index != nil {
+		index.saveCoupon(version, couponID, nil, time.Now())
+	}
+	s.forgetCouponLookups()
+}
+
+// holdsExpired drops the cached results that the sweeper giving back stale holds can make stale.
+func (s *CouponService) holdsExpired(expired []models.CouponRedemption) {
+	tags := make([]string, 0, 2*len(expired))
+	for _, redemption := range expired {
+		tags = append(tags, s.usageReturnedTags(redemption.UserID, redemption.CouponID)...)
+	}
+	slices.Sort(tags)
+	s.applicableCouponsCache.Invalidate(slices.Compact(tags)...)
+}
+
+// remoteInvalidation follows the coupon changes of another server sharing the cache: it reloads the coupon
+// index and drops the coupons looked up by code when the other server edited coupons or the exclusions, and
+// takes the coupons it deleted out of both. Changes in usage are ignored.
+func (s *CouponService) remoteInvalidation(invalidation caching.Invalidation) {
+	if invalidation.All {
+		s.resetCouponIndex()
+		s.forgetCouponLookups()
+		return
+	}
+	for _, tag := range invalidation.Tags {
+		couponID, ok := strings.CutPrefix(tag, deletedCouponTag(""))
+		if !ok {
+			continue
+		}
+		s.forgetDeletedCoupon(couponID)
+	}
+}
+
+// hasTotalCap reports whether holds of a coupon can use it up for every user.
+func hasTotalCap(coupon *models.Coupon) bool {
+	return coupon.MaxTotalUsage > 0 || coupon.UsageType == models.UsageTypeOneTime
+}
+
+// usageTaken drops the cached results that holds of coupons by a user can make stale.
+func (s *CouponService) usageTaken(userID string, coupons ...*models.Coupon) {
+	tags := []string{userCacheTag(userID)}
+	for _, coupon := range coupons {
+		if hasTotalCap(coupon) {
+			tags = append(tags, couponCacheTag(coupon.ID))
+		}
+	}
+	s.applicableCouponsCache.Invalidate(tags...)
+}
+
+// usageReturned drops the cached results that giving back a user's hold of a coupon can make stale.
+func (s *CouponService) usageReturned(userID string, couponID string) {
+	s.applicableCouponsCache.Invalidate(s.usageReturnedTags(userID, couponID)...)
+}
+
+// usageReturnedTags returns the tags of the cached results that giving back a user's hold of a coupon can make
+// stale. Whether the coupon has a total cap is looked up in the coupon index rather than the storage; when the
+// coupon is not in it, the results listing the coupon or leaving it out are dropped as if it had a cap.
+func (s *CouponService) usageReturnedTags(userID string, couponID string) []string {
+	s.indexMu.Lock()
+	index := s.index
+	s.indexMu.Unlock()
+
+	tags := []string{userCacheTag(userID)}
+	capped, found := false, false
+	if index != nil {
+		capped, found = index.hasTotalCap(couponID)
+	}
+	if capped || !found {
+		tags = append(tags, couponCacheTag(couponID))
+	}
+	return tags
+}
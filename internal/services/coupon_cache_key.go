@@ -0,0 +1,206 @@
+package services
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+)
+
+// cacheKeyProfile is what building applicable coupons cache keys needs to know about the current coupons:
+// the times at which they become valid or stop being valid, and whether their rules can tell split cart lines
+// apart. Between two validity changes the same coupons are valid, so requests made at any time between them
+// can share cached results.
+type cacheKeyProfile struct {
+	instants    []time.Time // Sorted; each is the first instant of a new validity
+	minutes     []int       // Sorted minutes of the local day at which a recurring window opens or closes
+	byDay       bool        // Whether some recurring window is limited to certain weekdays or weeks of the month
+	countsLines bool        // Whether some eligibility rule counts lines, so lines must not be merged
+	fingerprint string      // Digest of the above, telling profiles apart in cache keys
+}
+
+// validityBucket identifies the period between two validity changes that a time falls in.
+type validityBucket struct {
+	Instants    int // Number of the profile's instants at or before the time
+	Minutes     int // Number of the profile's minutes at or before the time's local minute
+	Weekday     int // Local weekday, or -1 when no window depends on it
+	WeekOfMonth int // Local week of the month counted from 0, or -1 when no window depends on it
+
+	// Fingerprint of the profile. Servers sharing the cache only share results while their profiles agree, so
+	// the results of a server that has not yet heard of a coupon change elsewhere are not served by the others.
+	Profile string
+}
+
+// cacheKeyProfileCounts counts what the active coupons contribute to the cache key profile, so that the profile
+// follows the changes of one coupon without going through every coupon again.
+type cacheKeyProfileCounts struct {
+	instants    sortedCounts[time.Time]
+	minutes     sortedCounts[int]
+	byDay       int // Coupons whose recurring window is limited to certain weekdays or weeks of the month
+	countsLines int // Coupons whose eligibility rule counts lines
+}
+
+func newCacheKeyProfileCounts() *cacheKeyProfileCounts {
+	return &cacheKeyProfileCounts{
+		instants: sortedCounts[time.Time]{
+			counts:  make(map[time.Time]int),
+			compare: time.Time.Compare,
+			hash:    func(t time.Time) uint64 { return mix(uint64(t.Unix())) ^ uint64(t.Nanosecond()) },
+		},
+		minutes: sortedCounts[int]{
+			counts:  make(map[int]int),
+			compare: cmp.Compare[int],
+			hash:    func(minute int) uint64 { return mix(uint64(minute)) },
+		},
+	}
+}
+
+// add counts an active coupon's contribution to the profile n times: 1 when it becomes active, -1 when it
+// stops being active.
+func (c *cacheKeyProfileCounts) add(coupon *models.Coupon, n int) {
+	// Coupons are valid before their expiry date, from the start of their time window and up to and including
+	// its end. Instants are counted in UTC without a monotonic reading, so that equal instants are equal keys.
+	c.instants.add(coupon.ExpiryDate.Round(0).UTC(), n)
+	if coupon.ValidTimeWindowStart != nil {
+		c.instants.add(coupon.ValidTimeWindowStart.Round(0).UTC(), n)
+	}
+	if coupon.ValidTimeWindowEnd != nil {
+		c.instants.add(coupon.ValidTimeWindowEnd.Add(time.Nanosecond).Round(0).UTC(), n)
+	}
+	if coupon.Recurrence != (models.Recurrence{}) {
+		c.minutes.add(coupon.Recurrence.StartMinute, n)
+		c.minutes.add(coupon.Recurrence.EndMinute, n)
+		if coupon.Recurrence.Days != 0 || coupon.Recurrence.Weeks != 0 {
+			c.byDay += n
+		}
+	}
+	if coupon.EligibilityRule != "" {
+		// A rule that does not parse fails every cart, however its lines are written
+		if rule, err := compileRule(coupon.EligibilityRule); err == nil && rule.CountsLines() {
+			c.countsLines += n
+		}
+	}
+}
+
+// profile returns the profile of the coupons counted so far. It does not change as more are counted.
+func (c *cacheKeyProfileCounts) profile() *cacheKeyProfile {
+	profile := &cacheKeyProfile{
+		instants:    c.instants.sorted,
+		minutes:     c.minutes.sorted,
+		byDay:       c.byDay > 0,
+		countsLines: c.countsLines > 0,
+	}
+	profile.fingerprint = fmt.Sprintf("%016x%016x %t %t", c.instants.sum, c.minutes.sum, profile.byDay, profile.countsLines)
+	return profile
+}
+
+// sortedCounts is a sorted set of values, each counted by how many coupons contribute it, and a fingerprint of
+// the set. The sorted values are copied whenever a value comes or goes, so that profiles keep theirs.
+type sortedCounts[T comparable] struct {
+	counts  map[T]int
+	sorted  []T
+	compare func(a, b T) int
+	hash    func(T) uint64
+	sum     uint64 // Sum of the hashes of the values in the set, whatever order they came in
+}
+
+// add counts a value n more times.
+func (c *sortedCounts[T]) add(value T, n int) {
+	before := c.counts[value]
+	after := before + n
+	if after > 0 {
+		c.counts[value] = after
+	} else {
+		delete(c.counts, value)
+	}
+
+	switch i, found := slices.BinarySearchFunc(c.sorted, value, c.compare); {
+	case before <= 0 && after > 0 && !found:
+		// Clipped, so that inserting copies the values
+		c.sorted = slices.Insert(slices.Clip(c.sorted), i, value)
+		c.sum += c.hash(value)
+	case before > 0 && after <= 0 && found:
+		c.sorted = slices.Delete(slices.Clone(c.sorted), i, i+1)
+		c.sum -= c.hash(value)
+	}
+}
+
+// mix scrambles the bits of x, so that the sums of close values hardly ever collide.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// bucket returns the validity bucket of a time, with recurring windows in a time zone. Times in the same
+// bucket see the same coupons as valid.
+func (p *cacheKeyProfile) bucket(timestamp time.Time, timeZone *time.Location) validityBucket {
+	local := timestamp.In(timeZone)
+	bucket := validityBucket{
+		Instants:    sort.Search(len(p.instants), func(i int) bool { return p.instants[i].After(timestamp) }),
+		Minutes:     sort.SearchInts(p.minutes, local.Hour()*60+local.Minute()+1),
+		Weekday:     -1,
+		WeekOfMonth: -1,
+		Profile:     p.fingerprint,
+	}
+	if p.byDay {
+		bucket.Weekday = int(local.Weekday())
+		bucket.WeekOfMonth = (local.Day() - 1) / 7
+	}
+	return bucket
+}
+
+// normalizeCart returns the cart lines sorted, with lines of the same medicine, category and price merged
+// unless a rule counts lines, and every line's quantity set. Carts that only differ in how they are written
+// normalize to the same cart, so applicable coupons are computed on the normalized cart to give them the same
+// result and cache key.
+func (p *cacheKeyProfile) normalizeCart(cartItems []models.CartItem) []models.CartItem {
+	type lineKey struct {
+		id, category string
+		price        money.Money
+	}
+	normalized := make([]models.CartItem, 0, len(cartItems))
+	merged := make(map[lineKey]int, len(cartItems))
+	for _, item := range cartItems {
+		item.Quantity = lineQuantity(item)
+		key := lineKey{item.ID, item.Category, item.Price}
+		if i, ok := merged[key]; ok && !p.countsLines {
+			normalized[i].Quantity += item.Quantity
+			continue
+		}
+		merged[key] = len(normalized)
+		normalized = append(normalized, item)
+	}
+
+	slices.SortStableFunc(normalized, func(a, b models.CartItem) int {
+		return cmp.Or(
+			cmp.Compare(a.ID, b.ID),
+			cmp.Compare(a.Category, b.Category),
+			cmp.Compare(a.Price.Currency, b.Price.Currency),
+			cmp.Compare(a.Price.Amount, b.Price.Amount),
+			cmp.Compare(a.Quantity, b.Quantity),
+		)
+	})
+	return normalized
+}
+
+// applicableCouponsCacheKey returns the cache key of a request for a user's applicable coupons, with the
+// normalized cart and the timestamp's validity bucket. It covers just the fields that affect the result.
+func applicableCouponsCacheKey(userID string, req *models.ApplicableCouponsRequest, cartItems []models.CartItem, bucket validityBucket) string {
+	var key strings.Builder
+	fmt.Fprintf(&key, "%q %d %q %q %q %+v", userID, req.OrderTotal.Amount, req.OrderTotal.Currency, req.Channel, req.Sort, bucket)
+	for _, item := range cartItems {
+		fmt.Fprintf(&key, " %q %q %d %q %d", item.ID, item.Category, item.Price.Amount, item.Price.Currency, item.Quantity)
+	}
+
+	hash := sha256.Sum256([]byte(key.String()))
+	return fmt.Sprintf("%x", hash)
+}
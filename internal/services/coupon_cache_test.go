@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"coupon-system/internal/caching"
+	"coupon-system/internal/models"
+	"coupon-system/internal/storage/database"
+)
+
+// Holding and giving back a coupon with a total cap only drops the cached results that list the coupon or
+// left it out for being used up, and those of the user.
+func TestUsageChangesDropOnlyTheResultsTheyAffect(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t)
+	for _, coupon := range []*models.CreateCouponRequest{
+		{CouponCode: "CAPPED", MaxTotalUsage: 1, ApplicableMedicineIDs: []string{"med1"}},
+		{CouponCode: "OTHER", ApplicableMedicineIDs: []string{"med9"}},
+	} {
+		coupon.ExpiryDate = time.Now().Add(time.Hour)
+		coupon.UsageType = models.UsageTypeMultiUse
+		coupon.DiscountType = "fixed_amount"
+		coupon.DiscountValue = "5.00"
+		if err := service.CreateCoupon(ctx, coupon); err != nil {
+			t.Fatalf("failed to create coupon %s: %v", coupon.CouponCode, err)
+		}
+	}
+
+	capped := []models.CartItem{{ID: "med1", Price: inr("100.00"), Quantity: 1}}
+	other := []models.CartItem{{ID: "med9", Price: inr("100.00"), Quantity: 1}}
+	timestamp := time.Now()
+	// request asks for a user's applicable coupons and reports whether they came from the cache
+	request := func(userID string, cart []models.CartItem) ([]string, bool) {
+		t.Helper()
+		_, missesBefore, _ := service.applicableCouponsStats.Snapshot()
+		response, err := service.GetApplicableCoupons(ctx, userID, &models.ApplicableCouponsRequest{CartItems: cart, OrderTotal: inr("100.00"), Timestamp: timestamp})
+		if err != nil {
+			t.Fatalf("GetApplicableCoupons failed: %v", err)
+		}
+		_, missesAfter, _ := service.applicableCouponsStats.Snapshot()
+		var codes []string
+		for _, coupon := range response.ApplicableCoupons {
+			codes = append(codes, coupon.CouponCode)
+		}
+		return codes, missesAfter == missesBefore
+	}
+	request("u2", capped)
+	request("u3", other)
+
+	hold, err := service.CreateRedemption(ctx, "u1", &models.CreateRedemptionRequest{
+		OrderID:               "o1",
+		ValidateCouponRequest: models.ValidateCouponRequest{CouponCode: "CAPPED", CartItems: capped, OrderTotal: inr("100.00")},
+	})
+	if err != nil {
+		t.Fatalf("failed to hold coupon: %v", err)
+	}
+	if codes, cached := request("u2", capped); cached || len(codes) != 0 {
+		t.Errorf("after the hold, u2 got %v, cached: %v; want no coupons, not cached", codes, cached)
+	}
+	if _, cached := request("u3", other); !cached {
+		t.Errorf("after the hold, u3's result was dropped")
+	}
+
+	if _, err := service.ReleaseRedemption(ctx, "u1", hold.RedemptionID); err != nil {
+		t.Fatalf("failed to release hold: %v", err)
+	}
+	if codes, cached := request("u2", capped); cached || len(codes) != 1 || codes[0] != "CAPPED" {
+		t.Errorf("after the release, u2 got %v, cached: %v; want CAPPED, not cached", codes, cached)
+	}
+	if _, cached := request("u3", other); !cached {
+		t.Errorf("after the release, u3's result was dropped")
+	}
+}
+
+// Giving back a hold of a coupon without a total cap keeps the other users' results that list it, and the
+// coupon is not read from the storage to find that out.
+func TestReleasingAnUncappedCouponKeepsOtherResults(t *testing.T) {
+	ctx := context.Background()
+	store := &couponReads{CouponStorage: database.NewMemoryStore()}
+	cache := caching.NewLRUCache[string, *models.ApplicableCouponsResponse](100, time.Minute)
+	service := NewCouponService(store, cache, time.Minute, time.UTC)
+	err := service.CreateCoupon(ctx, &models.CreateCouponRequest{
+		CouponCode:    "OPEN",
+		ExpiryDate:    time.Now().Add(time.Hour),
+		UsageType:     models.UsageTypeMultiUse,
+		DiscountType:  "fixed_amount",
+		DiscountValue: "5.00",
+	})
+	if err != nil {
+		t.Fatalf("failed to create coupon: %v", err)
+	}
+
+	cart := []models.CartItem{{ID: "med1", Price: inr("100.00"), Quantity: 1}}
+	applicable := &models.ApplicableCouponsRequest{CartItems: cart, OrderTotal: inr("100.00"), Timestamp: time.Now()}
+	if _, err := service.GetApplicableCoupons(ctx, "u2", applicable); err != nil {
+		t.Fatalf("GetApplicableCoupons failed: %v", err)
+	}
+	hold, err := service.CreateRedemption(ctx, "u1", &models.CreateRedemptionRequest{
+		OrderID:               "o1",
+		ValidateCouponRequest: models.ValidateCouponRequest{CouponCode: "OPEN", CartItems: cart, OrderTotal: inr("100.00")},
+	})
+	if err != nil {
+		t.Fatalf("failed to hold coupon: %v", err)
+	}
+
+	reads := store.reads.Load()
+	if _, err := service.ReleaseRedemption(ctx, "u1", hold.RedemptionID); err != nil {
+		t.Fatalf("failed to release hold: %v", err)
+	}
+	if got := store.reads.Load() - reads; got != 0 {
+		t.Errorf("releasing the hold read the coupon %d times, want none", got)
+	}
+	_, missesBefore, _ := service.applicableCouponsStats.Snapshot()
+	if _, err := service.GetApplicableCoupons(ctx, "u2", applicable); err != nil {
+		t.Fatalf("GetApplicableCoupons failed: %v", err)
+	}
+	if _, missesAfter, _ := service.applicableCouponsStats.Snapshot(); missesAfter != missesBefore {
+		t.Errorf("releasing u1's hold dropped u2's result")
+	}
+}
+
+// couponReads is a store that counts the coupons read by ID.
+type couponReads struct {
+	database.CouponStorage
+	reads atomic.Int64
+}
+
+func (s *couponReads) GetCouponByID(ctx context.Context, couponID string) (*models.Coupon, error) {
+	s.reads.Add(1)
+	return s.CouponStorage.GetCouponByID(ctx, couponID)
+}
+
+// Another server sharing the cache only makes this one forget a coupon when it deleted the coupon, not when
+// the coupon's usage changed.
+func TestRemoteInvalidationsFollowDeletions(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	cache := caching.NewLRUCache[string, *models.ApplicableCouponsResponse](100, time.Minute)
+	service := NewCouponService(store, cache, time.Minute, time.UTC)
+	err := service.CreateCoupon(ctx, &models.CreateCouponRequest{
+		CouponCode:    "GONE",
+		ExpiryDate:    time.Now().Add(time.Hour),
+		UsageType:     models.UsageTypeMultiUse,
+		DiscountType:  "fixed_amount",
+		DiscountValue: "5.00",
+	})
+	if err != nil {
+		t.Fatalf("failed to create coupon: %v", err)
+	}
+	coupon, err := service.lookupCoupon(ctx, "GONE")
+	if err != nil || coupon == nil {
+		t.Fatalf("lookupCoupon returned %v, %v; want the coupon", coupon, err)
+	}
+	// Deleted by another server
+	if _, err := store.DeleteCoupon(ctx, coupon.ID); err != nil {
+		t.Fatalf("failed to delete coupon: %v", err)
+	}
+
+	service.remoteInvalidation(caching.Invalidation{Tags: []string{userCacheTag("u1"), couponCacheTag(coupon.ID)}})
+	if got, err := service.lookupCoupon(ctx, "GONE"); got == nil || err != nil {
+		t.Errorf("after a change in usage, lookupCoupon returned %v, %v; want the cached coupon", got, err)
+	}
+	service.remoteInvalidation(caching.Invalidation{Tags: []string{couponCacheTag(coupon.ID), deletedCouponTag(coupon.ID)}})
+	if got, err := service.lookupCoupon(ctx, "GONE"); got != nil || err != nil {
+		t.Errorf("after a deletion, lookupCoupon returned %v, %v; want nil, nil", got, err)
+	}
+}
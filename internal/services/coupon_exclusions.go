@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"coupon-system/internal/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrExclusionNotFound is returned when a global exclusion does not exist.
+	ErrExclusionNotFound = errors.New("exclusion not found")
+	// ErrInvalidExclusion is returned when an exclusion does not name a medicine or category.
+	ErrInvalidExclusion = errors.New("invalid exclusion")
+)
+
+// ListExclusions fetches the global exclusion list.
+func (s *CouponService) ListExclusions(ctx context.Context) (*models.ListExclusionsResponse, error) {
+	exclusions, err := s.storage.ListExclusions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing exclusions: %w", err)
+	}
+
+	response := &models.ListExclusionsResponse{Exclusions: make([]models.ExclusionResponse, 0, len(exclusions))}
+	for i := range exclusions {
+		response.Exclusions = append(response.Exclusions, *newExclusionResponse(&exclusions[i]))
+	}
+	return response, nil
+}
+
+// CreateExclusion keeps a medicine or category out of the discount of every coupon.
+func (s *CouponService) CreateExclusion(ctx context.Context, req *models.CreateExclusionRequest) (*models.ExclusionResponse, error) {
+	exclusion := &models.DiscountExclusion{
+		ID:        uuid.New().String(),
+		Kind:      req.Kind,
+		ItemID:    strings.TrimSpace(req.ItemID),
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	}
+	if exclusion.Kind != models.ExclusionKindMedicine && exclusion.Kind != models.ExclusionKindCategory {
+		return nil, fmt.Errorf("%w: exclusion kind must be medicine or category", ErrInvalidExclusion)
+	}
+	if exclusion.ItemID == "" {
+		return nil, fmt.Errorf("%w: excluded item ID is required", ErrInvalidExclusion)
+	}
+
+	if err := s.storage.CreateExclusion(ctx, exclusion); err != nil {
+		return nil, fmt.Errorf("error creating exclusion: %w", err)
+	}
+	s.exclusionsChanged(ctx)
+	return newExclusionResponse(exclusion), nil
+}
+
+// DeleteExclusion lets coupons discount a medicine or category again.
+func (s *CouponService) DeleteExclusion(ctx context.Context, exclusionID string) error {
+	deleted, err := s.storage.DeleteExclusion(ctx, exclusionID)
+	if err != nil {
+		return fmt.Errorf("error deleting exclusion: %w", err)
+	}
+	if !deleted {
+		return ErrExclusionNotFound
+	}
+	s.exclusionsChanged(ctx)
+	return nil
+}
+
+// getExclusions fetches the global exclusion list for applying to coupons with withExclusions.
+func (s *CouponService) getExclusions(ctx context.Context) ([]models.DiscountExclusion, error) {
+	exclusions, err := s.storage.ListExclusions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching exclusions: %w", err)
+	}
+	return exclusions, nil
+}
+
+// withExclusions returns a copy of the coupon that also excludes everything on the global exclusion list, so
+// validators and discount calculators only have to look at the coupon. The coupon itself is left untouched.
+func withExclusions(coupon *models.Coupon, exclusions []models.DiscountExclusion) *models.Coupon {
+	excluded := *coupon
+	excluded.ExcludedMedicines = append([]models.Medicine(nil), coupon.ExcludedMedicines...)
+	excluded.ExcludedCategories = append([]models.Category(nil), coupon.ExcludedCategories...)
+	for _, exclusion := range exclusions {
+		switch exclusion.Kind {
+		case models.ExclusionKindMedicine:
+			excluded.ExcludedMedicines = append(excluded.ExcludedMedicines, models.Medicine{ID: exclusion.ItemID})
+		case models.ExclusionKindCategory:
+			excluded.ExcludedCategories = append(excluded.ExcludedCategories, models.Category{ID: exclusion.ItemID})
+		}
+	}
+	return &excluded
+}
+
+func newExclusionResponse(exclusion *models.DiscountExclusion) *models.ExclusionResponse {
+	return &models.ExclusionResponse{
+		ID:        exclusion.ID,
+		Kind:      exclusion.Kind,
+		ItemID:    exclusion.ItemID,
+		Reason:    exclusion.Reason,
+		CreatedAt: exclusion.CreatedAt,
+	}
+}
@@ -0,0 +1,490 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"coupon-system/internal/storage/database"
+)
+
+// couponIndex is an in-memory copy of the active coupons and the global exclusion list. It finds the coupons
+// that can apply to a cart without querying the database, the way CouponStorage.GetApplicableCoupons does, by
+// looking coupons up by the medicines and categories they target: a cart only visits the coupons that target
+// its lines and the general ones.
+//
+// Usage changes with every redemption, so it is not part of the index. The coupons found are filtered by their
+// current usage afterwards, which only queries the database for coupons that have usage limits.
+//
+// Coupon and exclusion changes are applied to the index in place, one coupon at a time. Each change has a
+// version of the service's index, taken before the change's data is read, and is only applied if the index
+// has not already seen a later change of the same coupon or exclusion list, nor been read after it.
+type couponIndex struct {
+	mu sync.RWMutex // Held for writing while a change is applied, and for reading while the index is used
+
+	loadedAt            uint64            // Version at which the coupons were read
+	changedAt           map[string]uint64 // Version of the last change applied to each coupon since, by ID
+	exclusionsChangedAt uint64            // Version of the last exclusion change applied since
+
+	active             map[string]*models.Coupon // Every active coupon, by ID
+	coupons            []indexedCoupon           // Coupons that can match carts, by position; nil where one was removed
+	positions          map[string]int            // Positions of the coupons, by ID
+	free               []int                     // Positions left by removed coupons, for the next coupons
+	byMedicine         map[string][]int          // Positions of the coupons targeting each medicine
+	byCategory         map[string][]int          // Positions of the coupons targeting each category
+	general            []int                     // Positions of the coupons targeting and excluding nothing
+	generalExcluding   []int                     // Positions of the coupons targeting nothing but excluding something
+	excludedMedicines  map[string]bool           // On the global exclusion list
+	excludedCategories map[string]bool           // On the global exclusion list
+	exclusions         []models.DiscountExclusion
+	profileCounts      *cacheKeyProfileCounts
+	profile            *cacheKeyProfile
+}
+
+// indexedCoupon is a coupon in the index, with what it excludes in sets.
+type indexedCoupon struct {
+	coupon             *models.Coupon
+	excludedMedicines  map[string]bool // Nil when the coupon excludes no medicine
+	excludedCategories map[string]bool // Nil when the coupon excludes no category
+}
+
+// Page size used when loading every coupon into the index.
+const couponIndexPageSize = 5000
+
+// newCouponIndex indexes the active coupons among coupons, read at a version of the service's index.
+func newCouponIndex(coupons []models.Coupon, exclusions []models.DiscountExclusion, version uint64) *couponIndex {
+	index := &couponIndex{
+		loadedAt:      version,
+		changedAt:     make(map[string]uint64),
+		active:        make(map[string]*models.Coupon),
+		positions:     make(map[string]int),
+		byMedicine:    make(map[string][]int),
+		byCategory:    make(map[string][]int),
+		profileCounts: newCacheKeyProfileCounts(),
+	}
+	index.setExclusions(exclusions)
+	for i := range coupons {
+		if coupons[i].IsActive {
+			index.add(&coupons[i])
+		}
+	}
+	index.profile = index.profileCounts.profile()
+	return index
+}
+
+// saveCoupon applies the change of a coupon made at a version: the coupon as it was read after the change, or
+// nil when it was deleted. Coupons that are inactive or expired at now are taken out, since they never apply.
+func (idx *couponIndex) saveCoupon(version uint64, couponID string, coupon *models.Coupon, now time.Time) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if version <= idx.loadedAt || version < idx.changedAt[couponID] {
+		return
+	}
+	idx.changedAt[couponID] = version
+
+	idx.remove(couponID)
+	if coupon != nil && coupon.IsActive && coupon.ExpiryDate.After(now) {
+		idx.add(coupon)
+	}
+	idx.profile = idx.profileCounts.profile()
+}
+
+// changeExclusions applies a change of the global exclusion list made at a version.
+func (idx *couponIndex) changeExclusions(version uint64, exclusions []models.DiscountExclusion) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if version <= idx.loadedAt || version < idx.exclusionsChangedAt {
+		return
+	}
+	idx.exclusionsChangedAt = version
+	idx.setExclusions(exclusions)
+}
+
+// setExclusions replaces the global exclusion list.
+func (idx *couponIndex) setExclusions(exclusions []models.DiscountExclusion) {
+	idx.exclusions = exclusions
+	idx.excludedMedicines = make(map[string]bool)
+	idx.excludedCategories = make(map[string]bool)
+	for _, exclusion := range exclusions {
+		switch exclusion.Kind {
+		case models.ExclusionKindMedicine:
+			idx.excludedMedicines[exclusion.ItemID] = true
+		case models.ExclusionKindCategory:
+			idx.excludedCategories[exclusion.ItemID] = true
+		}
+	}
+}
+
+// add indexes an active coupon. time_based coupons only apply with a time window or a recurring window, so
+// those without either only count towards the cache key profile.
+func (idx *couponIndex) add(coupon *models.Coupon) {
+	idx.active[coupon.ID] = coupon
+	idx.profileCounts.add(coupon, 1)
+	if coupon.UsageType == models.UsageTypeTimeBased && coupon.ValidTimeWindowStart == nil && coupon.ValidTimeWindowEnd == nil && coupon.Recurrence == (models.Recurrence{}) {
+		return
+	}
+
+	indexed := indexedCoupon{coupon: coupon}
+	for _, medicine := range coupon.ExcludedMedicines {
+		if indexed.excludedMedicines == nil {
+			indexed.excludedMedicines = make(map[string]bool)
+		}
+		indexed.excludedMedicines[medicine.ID] = true
+	}
+	for _, category := range coupon.ExcludedCategories {
+		if indexed.excludedCategories == nil {
+			indexed.excludedCategories = make(map[string]bool)
+		}
+		indexed.excludedCategories[category.ID] = true
+	}
+	position := len(idx.coupons)
+	if n := len(idx.free); n > 0 {
+		position = idx.free[n-1]
+		idx.free = idx.free[:n-1]
+		idx.coupons[position] = indexed
+	} else {
+		idx.coupons = append(idx.coupons, indexed)
+	}
+	idx.positions[coupon.ID] = position
+
+	switch {
+	case len(coupon.MedicineIDs) > 0 || len(coupon.Categories) > 0:
+	case indexed.excludedMedicines != nil || indexed.excludedCategories != nil:
+		idx.generalExcluding = append(idx.generalExcluding, position)
+		return
+	default:
+		idx.general = append(idx.general, position)
+		return
+	}
+	for _, medicine := range coupon.MedicineIDs {
+		idx.byMedicine[medicine.ID] = append(idx.byMedicine[medicine.ID], position)
+	}
+	for _, category := range coupon.Categories {
+		idx.byCategory[category.ID] = append(idx.byCategory[category.ID], position)
+	}
+}
+
+// remove takes the coupon of an ID out of the index, if it is in it.
+func (idx *couponIndex) remove(couponID string) {
+	coupon, ok := idx.active[couponID]
+	if !ok {
+		return
+	}
+	delete(idx.active, couponID)
+	idx.profileCounts.add(coupon, -1)
+	position, ok := idx.positions[couponID]
+	if !ok {
+		return
+	}
+	delete(idx.positions, couponID)
+	idx.coupons[position] = indexedCoupon{}
+	idx.free = append(idx.free, position)
+
+	isPosition := func(p int) bool { return p == position }
+	idx.general = slices.DeleteFunc(idx.general, isPosition)
+	idx.generalExcluding = slices.DeleteFunc(idx.generalExcluding, isPosition)
+	for _, medicine := range coupon.MedicineIDs {
+		if positions := slices.DeleteFunc(idx.byMedicine[medicine.ID], isPosition); len(positions) > 0 {
+			idx.byMedicine[medicine.ID] = positions
+		} else {
+			delete(idx.byMedicine, medicine.ID)
+		}
+	}
+	for _, category := range coupon.Categories {
+		if positions := slices.DeleteFunc(idx.byCategory[category.ID], isPosition); len(positions) > 0 {
+			idx.byCategory[category.ID] = positions
+		} else {
+			delete(idx.byCategory, category.ID)
+		}
+	}
+}
+
+// profileAndExclusions returns the cache key profile of the indexed coupons and the global exclusion list.
+// Neither changes afterwards; a change to the index replaces them.
+func (idx *couponIndex) profileAndExclusions() (*cacheKeyProfile, []models.DiscountExclusion) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.profile, idx.exclusions
+}
+
+// hasTotalCap reports whether holds of the indexed coupon of an ID can use it up for every user, and whether the
+// coupon is indexed at all.
+func (idx *couponIndex) hasTotalCap(couponID string) (capped, found bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	coupon, found := idx.active[couponID]
+	return found && hasTotalCap(coupon), found
+}
+
+// match returns the coupons that can apply to a cart at a time, with recurring windows checked in the time's
+// location, before their usage limits are checked: the coupons that are usable at the time and can discount a
+// line that neither they nor the global exclusion list exclude, and that they target, if they target anything.
+// Without lines, only general coupons apply. The coupons whose minimum order value the order total reaches are
+// sorted by code, and the others by minimum order value and code.
+func (idx *couponIndex) match(timestamp time.Time, orderTotal money.Money, cartItems []models.CartItem) (reached, missed []*models.Coupon) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	found := make([]bool, len(idx.coupons))
+	var positions []int
+	add := func(position int) {
+		if !found[position] {
+			found[position] = true
+			positions = append(positions, position)
+		}
+	}
+
+	if len(cartItems) == 0 {
+		// There is no line to target or exclude
+		for _, position := range slices.Concat(idx.general, idx.generalExcluding) {
+			add(position)
+		}
+	}
+	generalAdded := false
+	for i := range cartItems {
+		item := &cartItems[i]
+		if idx.excludedMedicines[item.ID] || idx.excludedCategories[item.Category] {
+			continue
+		}
+		// Any line not on the global exclusion list is enough for the coupons that exclude nothing
+		if !generalAdded {
+			for _, position := range idx.general {
+				add(position)
+			}
+			generalAdded = true
+		}
+		for _, candidates := range [][]int{idx.byMedicine[item.ID], idx.byCategory[item.Category], idx.generalExcluding} {
+			for _, position := range candidates {
+				if !found[position] && !idx.coupons[position].excludes(item) {
+					add(position)
+				}
+			}
+		}
+	}
+
+	for _, position := range positions {
+		coupon := idx.coupons[position].coupon
+		if !usableAt(coupon, timestamp) {
+			continue
+		}
+		if coupon.MinOrderValue.Amount <= orderTotal.Amount {
+			reached = append(reached, coupon)
+		} else {
+			missed = append(missed, coupon)
+		}
+	}
+	slices.SortFunc(reached, func(a, b *models.Coupon) int { return strings.Compare(a.CouponCode, b.CouponCode) })
+	slices.SortFunc(missed, func(a, b *models.Coupon) int {
+		return cmp.Or(cmp.Compare(a.MinOrderValue.Amount, b.MinOrderValue.Amount), strings.Compare(a.CouponCode, b.CouponCode))
+	})
+	return reached, missed
+}
+
+// excludes reports whether the coupon excludes a cart line.
+func (c *indexedCoupon) excludes(item *models.CartItem) bool {
+	return c.excludedMedicines[item.ID] || c.excludedCategories[item.Category]
+}
+
+// usableAt reports whether an indexed coupon can be used at a time: before its expiry date, within its time
+// window and within its recurring window in the time's location.
+func usableAt(coupon *models.Coupon, timestamp time.Time) bool {
+	switch {
+	case !coupon.ExpiryDate.After(timestamp):
+		return false
+	case coupon.ValidTimeWindowStart != nil && coupon.ValidTimeWindowStart.After(timestamp):
+		return false
+	case coupon.ValidTimeWindowEnd != nil && coupon.ValidTimeWindowEnd.Before(timestamp):
+		return false
+	}
+	return inRecurrence(coupon.Recurrence, timestamp)
+}
+
+// matchCoupons matches the index against a validation input, recording the user's usage of the coupons it
+// looks up in the input. It also returns the IDs of the coupons it left out for being used up overall.
+func (s *CouponService) matchCoupons(ctx context.Context, index *couponIndex, input *ValidationInput) (applicable, nearMisses []models.Coupon, usedUp []string, err error) {
+	reached, missed := index.match(input.Timestamp.In(s.timeZone), input.OrderTotal, input.CartItems)
+	applicable, usedUp, err = s.withinUsageLimits(ctx, input, reached, -1, usedUp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nearMisses, usedUp, err = s.withinUsageLimits(ctx, input, missed, maxNearMisses, usedUp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return applicable, nearMisses, usedUp, nil
+}
+
+// Smallest number of coupons whose usage withinUsageLimits looks up at a time.
+const usageLookupBatchSize = 100
+
+// withinUsageLimits returns copies of the first limit coupons, or of all of them when limit is negative, that
+// have uses left overall and for the input's user by their current usage. Only coupons with usage limits are
+// looked up, a batch at a time until the limit is reached; those that are gone are left out. The user's usage
+// of the coupons looked up is recorded in the input, so validating them does not look it up again, and the IDs
+// of those left out for being used up overall are appended to usedUp.
+func (s *CouponService) withinUsageLimits(ctx context.Context, input *ValidationInput, coupons []*models.Coupon, limit int, usedUp []string) ([]models.Coupon, []string, error) {
+	if limit < 0 {
+		limit = len(coupons)
+	}
+	limited := func(coupon *models.Coupon) bool {
+		return coupon.MaxTotalUsage > 0 || coupon.MaxUsagePerUser > 0 || coupon.UsageType == models.UsageTypeOneTime
+	}
+
+	filtered := make([]models.Coupon, 0, min(limit, len(coupons)))
+	for len(coupons) > 0 && len(filtered) < limit {
+		batch := coupons[:min(len(coupons), max(limit-len(filtered), usageLookupBatchSize))]
+		coupons = coupons[len(batch):]
+
+		var couponIDs []string
+		for _, coupon := range batch {
+			if limited(coupon) {
+				couponIDs = append(couponIDs, coupon.ID)
+			}
+		}
+		var usages map[string]database.CouponUsage
+		if len(couponIDs) > 0 {
+			var err error
+			usages, err = s.storage.GetCouponUsages(ctx, input.UserID, couponIDs)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error fetching coupon usages: %w", err)
+			}
+		}
+
+		for _, coupon := range batch {
+			if len(filtered) == limit {
+				break
+			}
+			if !limited(coupon) {
+				filtered = append(filtered, *coupon)
+				continue
+			}
+			usage, ok := usages[coupon.ID]
+			if ok {
+				if input.UserUsages == nil {
+					input.UserUsages = make(map[string]int)
+				}
+				input.UserUsages[coupon.ID] = usage.UserUsage
+			}
+			switch {
+			case !ok:
+			case coupon.MaxTotalUsage > 0 && usage.TotalUsage >= coupon.MaxTotalUsage,
+				coupon.UsageType == models.UsageTypeOneTime && usage.TotalUsage > 0:
+				usedUp = append(usedUp, coupon.ID)
+			case coupon.MaxUsagePerUser > 0 && usage.UserUsage >= coupon.MaxUsagePerUser:
+			default:
+				current := *coupon
+				current.CurrentTotalUsage = usage.TotalUsage
+				filtered = append(filtered, current)
+			}
+		}
+	}
+	return filtered, usedUp, nil
+}
+
+// getCouponIndex returns the index of the current coupons, loading it when there is none. Only one request
+// loads it at a time; the others wait for it.
+func (s *CouponService) getCouponIndex(ctx context.Context) (*couponIndex, error) {
+	s.indexMu.Lock()
+	index := s.index
+	s.indexMu.Unlock()
+	if index != nil {
+		return index, nil
+	}
+
+	s.indexLoadMu.Lock()
+	defer s.indexLoadMu.Unlock()
+	s.indexMu.Lock()
+	index, version := s.index, s.indexVersion
+	s.indexMu.Unlock()
+	if index != nil {
+		return index, nil
+	}
+
+	index, err := s.loadCouponIndex(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	s.setCouponIndex(index, version)
+	return index, nil
+}
+
+// loadCouponIndex reads the active coupons that have not expired and the global exclusion list at a version,
+// and indexes them.
+func (s *CouponService) loadCouponIndex(ctx context.Context, version uint64) (*couponIndex, error) {
+	var coupons []models.Coupon
+	filter := database.CouponFilter{Status: database.CouponStatusActive, Now: time.Now(), Limit: couponIndexPageSize}
+	for ; ; filter.Offset += couponIndexPageSize {
+		page, _, err := s.storage.ListCoupons(ctx, filter)
+		if err != nil {
+			return nil, fmt.Errorf("error loading coupons for the coupon index: %w", err)
+		}
+		coupons = append(coupons, page...)
+		if len(page) < couponIndexPageSize {
+			break
+		}
+	}
+	exclusions, err := s.getExclusions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newCouponIndex(coupons, exclusions, version), nil
+}
+
+// setCouponIndex keeps an index loaded at a version, unless coupons changed while it was loading.
+func (s *CouponService) setCouponIndex(index *couponIndex, version uint64) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if version == s.indexVersion {
+		s.index = index
+	}
+}
+
+// startCouponIndexChange starts a change of the coupons or the exclusions and returns its version, to apply the
+// change to the index with once its data is read. Loads of the index that started before do not keep what they
+// read.
+func (s *CouponService) startCouponIndexChange() (*couponIndex, uint64) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	s.indexVersion++
+	return s.index, s.indexVersion
+}
+
+// resetCouponIndex drops the index, so the next request loads it again.
+func (s *CouponService) resetCouponIndex() {
+	s.indexMu.Lock()
+	s.index = nil
+	s.indexVersion++
+	s.indexMu.Unlock()
+}
+
+// StartCouponIndexRefresher starts a background goroutine that reloads the coupon index at every interval until
+// ctx is cancelled, so that changes made by other servers are picked up even when no cache is shared with them.
+func (s *CouponService) StartCouponIndexRefresher(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.indexMu.Lock()
+				version := s.indexVersion
+				s.indexMu.Unlock()
+				index, err := s.loadCouponIndex(ctx, version)
+				if err != nil {
+					log.Printf("failed to refresh the coupon index: %v", err)
+					continue
+				}
+				s.setCouponIndex(index, version)
+			}
+		}
+	}()
+}
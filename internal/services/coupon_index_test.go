@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"coupon-system/internal/storage/database"
+
+	"github.com/google/uuid"
+)
+
+// Size of the benchmark's store and carts, and number of medicines and categories they pick from.
+const (
+	benchmarkCoupons    = 50_000
+	benchmarkCarts      = 200
+	benchmarkCartLines  = 100
+	benchmarkMedicines  = 5000
+	benchmarkCategories = 200
+)
+
+// benchmarkNow is the time of the benchmark's requests.
+var benchmarkNow = time.Date(2030, time.January, 9, 12, 0, 0, 0, time.UTC)
+
+// The benchmark's store, service and carts, set up once for every run of the benchmark.
+var (
+	benchmarkOnce     sync.Once
+	benchmarkStore    database.CouponStorage
+	benchmarkService  *CouponService
+	benchmarkCartList [][]models.CartItem
+)
+
+// setUpBenchmark fills an in-memory store with generated coupons and loads the coupon index of a service that
+// caches nothing, once for every benchmark.
+func setUpBenchmark(b *testing.B) {
+	benchmarkOnce.Do(func() {
+		ctx := context.Background()
+		random := rand.New(rand.NewPCG(1, 2))
+		benchmarkStore = database.NewMemoryStore()
+		for i := range benchmarkCoupons {
+			if err := benchmarkStore.CreateCoupon(ctx, newBenchmarkCoupon(random, i)); err != nil {
+				b.Fatalf("failed to create coupon: %v", err)
+			}
+		}
+		for range benchmarkCarts {
+			benchmarkCartList = append(benchmarkCartList, newBenchmarkCart(random))
+		}
+		// Every request is a cache miss
+		benchmarkService = NewCouponService(benchmarkStore, noCache{}, time.Minute, time.UTC)
+		if _, err := benchmarkService.getCouponIndex(ctx); err != nil {
+			b.Fatalf("failed to load the coupon index: %v", err)
+		}
+	})
+}
+
+// BenchmarkGetApplicableCoupons measures finding the applicable coupons for carts of 100 lines among 50,000
+// active coupons: matching them through the coupon index, through the storage query the index replaced, and
+// whole requests on a cache miss, which also check every matched coupon's validators and discounts. It also
+// measures how long the index takes to follow the edit of one coupon.
+//
+//	go test -run '^$' -bench GetApplicableCoupons ./internal/services
+func BenchmarkGetApplicableCoupons(b *testing.B) {
+	setUpBenchmark(b)
+	ctx := context.Background()
+	index, err := benchmarkService.getCouponIndex(ctx)
+	if err != nil {
+		b.Fatalf("failed to load the coupon index: %v", err)
+	}
+
+	b.Run("index", func(b *testing.B) {
+		for i := range b.N {
+			cart := benchmarkCartList[i%len(benchmarkCartList)]
+			input := &ValidationInput{UserID: "u1", CartItems: cart, OrderTotal: cartTotal(cart), Timestamp: benchmarkNow}
+			if _, _, _, err := benchmarkService.matchCoupons(ctx, index, input); err != nil {
+				b.Fatalf("failed to match coupons: %v", err)
+			}
+		}
+	})
+	b.Run("storage query", func(b *testing.B) {
+		for i := range b.N {
+			cart := benchmarkCartList[i%len(benchmarkCartList)]
+			medicineIDs, categoryIDs := make([]string, len(cart)), make([]string, len(cart))
+			for j, item := range cart {
+				medicineIDs[j], categoryIDs[j] = item.ID, item.Category
+			}
+			if _, err := benchmarkStore.GetApplicableCoupons(ctx, benchmarkNow, cartTotal(cart), medicineIDs, categoryIDs, "u1"); err != nil {
+				b.Fatalf("failed to query applicable coupons: %v", err)
+			}
+			if _, err := benchmarkStore.GetNearMissCoupons(ctx, benchmarkNow, cartTotal(cart), medicineIDs, categoryIDs, "u1", maxNearMisses); err != nil {
+				b.Fatalf("failed to query near misses: %v", err)
+			}
+		}
+	})
+	b.Run("coupon change", func(b *testing.B) {
+		// Saving a coupon again as it is leaves the index as it was for the other benchmarks
+		coupons, _, err := benchmarkStore.ListCoupons(ctx, database.CouponFilter{Limit: 100})
+		if err != nil {
+			b.Fatalf("failed to list coupons: %v", err)
+		}
+		for i := range b.N {
+			benchmarkService.couponSaved(ctx, coupons[i%len(coupons)].ID)
+		}
+	})
+	b.Run("request", func(b *testing.B) {
+		for i := range b.N {
+			cart := benchmarkCartList[i%len(benchmarkCartList)]
+			req := &models.ApplicableCouponsRequest{CartItems: cart, OrderTotal: cartTotal(cart), Timestamp: benchmarkNow}
+			if _, err := benchmarkService.GetApplicableCoupons(ctx, "u1", req); err != nil {
+				b.Fatalf("GetApplicableCoupons failed: %v", err)
+			}
+		}
+	})
+}
+
+// Applying coupon changes to a loaded index one at a time leaves it matching carts and building cache keys as an
+// index loaded afterwards would.
+func TestCouponIndexFollowsCouponChanges(t *testing.T) {
+	random := rand.New(rand.NewPCG(3, 4))
+	// newCoupon returns a generated coupon with its own expiry date and recurring window, so that changes of
+	// coupons also change the cache key profile
+	newCoupon := func(i int) *models.Coupon {
+		coupon := newBenchmarkCoupon(random, i)
+		coupon.ExpiryDate = benchmarkNow.Add(time.Duration(1+random.IntN(500)) * time.Hour)
+		if coupon.Recurrence != (models.Recurrence{}) {
+			coupon.Recurrence.StartMinute = random.IntN(12) * 60
+		}
+		return coupon
+	}
+	current := make(map[string]*models.Coupon)
+	var loaded []models.Coupon
+	for i := range 2000 {
+		coupon := newCoupon(i)
+		current[coupon.ID] = coupon
+		loaded = append(loaded, *coupon)
+	}
+	index := newCouponIndex(loaded, nil, 0)
+
+	ids := slices.Sorted(maps.Keys(current))
+	for version := uint64(1); version <= 3000; version++ {
+		couponID := ids[random.IntN(len(ids))]
+		var saved *models.Coupon
+		switch random.IntN(5) {
+		case 0: // Edited
+			saved = newCoupon(random.IntN(10_000))
+			saved.ID, saved.CouponCode = couponID, current[couponID].CouponCode
+		case 1: // Deactivated or activated
+			edited := *current[couponID]
+			edited.IsActive = !edited.IsActive
+			saved = &edited
+		case 2: // Expired
+			edited := *current[couponID]
+			edited.ExpiryDate = benchmarkNow.Add(-time.Hour)
+			saved = &edited
+		case 3: // Deleted
+			ids = slices.DeleteFunc(ids, func(id string) bool { return id == couponID })
+		case 4: // Created
+			saved = newCoupon(10_000 + int(version))
+			couponID = saved.ID
+			ids = append(ids, couponID)
+		}
+		if saved != nil {
+			current[couponID] = saved
+		} else {
+			delete(current, couponID)
+		}
+		var copied *models.Coupon
+		if saved != nil {
+			c := *saved
+			copied = &c
+		}
+		index.saveCoupon(version, couponID, copied, benchmarkNow)
+	}
+
+	var reloaded []models.Coupon
+	for _, coupon := range current {
+		if coupon.ExpiryDate.After(benchmarkNow) {
+			reloaded = append(reloaded, *coupon)
+		}
+	}
+	fresh := newCouponIndex(reloaded, nil, 0)
+
+	profile, _ := index.profileAndExclusions()
+	freshProfile, _ := fresh.profileAndExclusions()
+	if profile.fingerprint != freshProfile.fingerprint || !slices.Equal(profile.minutes, freshProfile.minutes) ||
+		!slices.EqualFunc(profile.instants, freshProfile.instants, time.Time.Equal) {
+		t.Errorf("the updated index's cache key profile differs from a fresh index's")
+	}
+	codes := func(coupons []*models.Coupon) []string {
+		codes := make([]string, 0, len(coupons))
+		for _, coupon := range coupons {
+			codes = append(codes, coupon.CouponCode)
+		}
+		return codes
+	}
+	for i := range 50 {
+		var cart []models.CartItem
+		if i > 0 {
+			cart = newBenchmarkCart(random)
+		}
+		for _, timestamp := range []time.Time{benchmarkNow, benchmarkNow.Add(-9 * time.Hour)} {
+			reached, missed := index.match(timestamp, cartTotal(cart), cart)
+			freshReached, freshMissed := fresh.match(timestamp, cartTotal(cart), cart)
+			if !slices.Equal(codes(reached), codes(freshReached)) || !slices.Equal(codes(missed), codes(freshMissed)) {
+				t.Fatalf("cart %d at %v matches %d and misses %d coupons in the updated index, %d and %d in a fresh one",
+					i, timestamp, len(reached), len(missed), len(freshReached), len(freshMissed))
+			}
+		}
+	}
+}
+
+// A change is not applied after a later change of the same coupon, nor to an index read after it.
+func TestCouponIndexSkipsStaleChanges(t *testing.T) {
+	random := rand.New(rand.NewPCG(5, 6))
+	original := newBenchmarkCoupon(random, 0)
+	edited := *original
+	edited.CouponCode = "EDITED"
+
+	index := newCouponIndex([]models.Coupon{*original}, nil, 10)
+	index.saveCoupon(10, original.ID, nil, benchmarkNow)
+	if index.active[original.ID] == nil {
+		t.Fatalf("a deletion read before the index was loaded took the coupon out")
+	}
+	index.saveCoupon(12, original.ID, &edited, benchmarkNow)
+	index.saveCoupon(11, original.ID, nil, benchmarkNow)
+	if coupon := index.active[original.ID]; coupon == nil || coupon.CouponCode != "EDITED" {
+		t.Errorf("a deletion read before a later edit undid it: the coupon is %v", coupon)
+	}
+}
+
+// newBenchmarkCoupon returns the i-th generated coupon. Most coupons target a few medicines or a category, some
+// are general, and some have a minimum order value, exclusions, usage limits or a recurring window.
+func newBenchmarkCoupon(random *rand.Rand, i int) *models.Coupon {
+	coupon := &models.Coupon{
+		ID:              uuid.New().String(),
+		CouponCode:      fmt.Sprintf("BENCH%06d", i),
+		ExpiryDate:      benchmarkNow.AddDate(1, 0, 0),
+		UsageType:       models.UsageTypeMultiUse,
+		MinOrderValue:   money.New(0, money.DefaultCurrency),
+		DiscountType:    "percentage",
+		DiscountAmount:  money.New(0, money.DefaultCurrency),
+		DiscountPercent: money.Percent(100 * (1 + random.IntN(30))),
+		IsActive:        true,
+		CreatedAt:       benchmarkNow,
+		UpdatedAt:       benchmarkNow,
+	}
+
+	switch n := random.IntN(100); {
+	case n < 55:
+		for range 1 + random.IntN(5) {
+			coupon.MedicineIDs = append(coupon.MedicineIDs, models.Medicine{ID: benchmarkMedicineID(random)})
+		}
+		slices.SortFunc(coupon.MedicineIDs, func(a, b models.Medicine) int { return strings.Compare(a.ID, b.ID) })
+		coupon.MedicineIDs = slices.CompactFunc(coupon.MedicineIDs, func(a, b models.Medicine) bool { return a.ID == b.ID })
+	case n < 95:
+		coupon.Categories = []models.Category{{ID: benchmarkCategoryID(random)}}
+	}
+	if random.IntN(10) == 0 {
+		coupon.ExcludedMedicines = []models.Medicine{{ID: benchmarkMedicineID(random)}}
+	}
+	if random.IntN(3) == 0 {
+		coupon.MinOrderValue = money.New(int64(random.IntN(50))*100_00, money.DefaultCurrency)
+	}
+	if random.IntN(5) == 0 {
+		coupon.MaxUsagePerUser = 1 + random.IntN(3)
+		coupon.MaxTotalUsage = 1000
+	}
+	if random.IntN(10) == 0 {
+		coupon.Recurrence = models.Recurrence{Days: 0b0111110, StartMinute: 9 * 60, EndMinute: 18 * 60}
+	}
+	return coupon
+}
+
+// newBenchmarkCart returns a cart of distinct medicines.
+func newBenchmarkCart(random *rand.Rand) []models.CartItem {
+	cart := make([]models.CartItem, 0, benchmarkCartLines)
+	seen := make(map[string]bool, benchmarkCartLines)
+	for len(cart) < benchmarkCartLines {
+		id := benchmarkMedicineID(random)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		cart = append(cart, models.CartItem{
+			ID:       id,
+			Category: benchmarkCategoryID(random),
+			Price:    money.New(int64(1+random.IntN(500))*100, money.DefaultCurrency),
+			Quantity: 1 + random.IntN(3),
+		})
+	}
+	return cart
+}
+
+func cartTotal(cart []models.CartItem) money.Money {
+	total := money.New(0, money.DefaultCurrency)
+	for _, item := range cart {
+		total = total.Add(item.Price.Mul(int64(item.Quantity)))
+	}
+	return total
+}
+
+func benchmarkMedicineID(random *rand.Rand) string {
+	return fmt.Sprintf("med%d", random.IntN(benchmarkMedicines))
+}
+
+func benchmarkCategoryID(random *rand.Rand) string {
+	return fmt.Sprintf("cat%d", random.IntN(benchmarkCategories))
+}
+
+// noCache is an applicable coupons cache that keeps nothing, so every request is a miss.
+type noCache struct{}
+
+func (noCache) Get(string) (*models.ApplicableCouponsResponse, bool)                   { return nil, false }
+func (noCache) Set(string, *models.ApplicableCouponsResponse)                          {}
+func (noCache) Delete(string)                                                          {}
+func (noCache) Version() uint64                                                        { return 0 }
+func (noCache) SetTagged(uint64, string, *models.ApplicableCouponsResponse, ...string) {}
+func (noCache) Invalidate(...string)                                                   {}
+func (noCache) InvalidateAll()                                                         {}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"coupon-system/internal/caching"
+	"coupon-system/internal/models"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Coupons looked up by code, to validate, hold or stack them, are cached in the process, and so are the codes
+// that have no coupon, so that neither the same code requested again nor guessed codes reach the storage every
+// time. Concurrent lookups of a code that is not cached share a single storage read. Unknown codes are kept
+// apart from coupons, for a shorter time, so a burst of guesses cannot evict the coupons in use and a coupon
+// created on another server is found soon. Every entry is dropped whenever a coupon is created, edited or
+// deleted, here or, with a shared applicable coupons cache, on another server.
+const (
+	couponLookupCacheSize = 10_000
+	couponLookupTTL       = time.Minute
+	unknownCouponCodeTTL  = 10 * time.Second
+)
+
+// couponLookupCache caches coupons by code and the codes that have none.
+type couponLookupCache struct {
+	coupons *caching.LRUCache[string, *models.Coupon]
+	unknown *caching.LRUCache[string, struct{}]
+	loads   singleflight.Group
+}
+
+func newCouponLookupCache() *couponLookupCache {
+	return &couponLookupCache{
+		coupons: caching.NewLRUCache[string, *models.Coupon](couponLookupCacheSize, couponLookupTTL),
+		unknown: caching.NewLRUCache[string, struct{}](couponLookupCacheSize, unknownCouponCodeTTL),
+	}
+}
+
+// lookupCoupon returns the coupon with a code, or nil if there is none, from the cache when it can. The usage
+// of a cached coupon with a total cap is read again, since holds change it without dropping the entry.
+func (s *CouponService) lookupCoupon(ctx context.Context, couponCode string) (*models.Coupon, error) {
+	lookups := s.couponLookups
+	if coupon, ok := lookups.coupons.Get(couponCode); ok {
+		return s.withCurrentUsage(ctx, coupon)
+	}
+	if _, ok := lookups.unknown.Get(couponCode); ok {
+		return nil, nil
+	}
+
+	result, err, _ := lookups.loads.Do(couponCode, func() (any, error) {
+		// Entries loaded from coupons that change during the read are not kept
+		version, unknownVersion := lookups.coupons.Version(), lookups.unknown.Version()
+		// The read is shared, so one caller giving up does not fail the others
+		coupon, err := s.storage.GetCouponByCode(context.WithoutCancel(ctx), couponCode)
+		if err != nil {
+			return nil, err
+		}
+		if coupon == nil {
+			lookups.unknown.SetTagged(unknownVersion, couponCode, struct{}{})
+			return (*models.Coupon)(nil), nil
+		}
+		lookups.coupons.SetTagged(version, couponCode, coupon)
+		return coupon, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	coupon := result.(*models.Coupon)
+	if coupon == nil {
+		return nil, nil
+	}
+	// Callers share the cached coupon, so each gets a copy
+	current := *coupon
+	return &current, nil
+}
+
+// withCurrentUsage returns a copy of a cached coupon with its current total usage, or nil if the coupon was
+// deleted since it was cached. Coupons without a total cap are not looked up.
+func (s *CouponService) withCurrentUsage(ctx context.Context, coupon *models.Coupon) (*models.Coupon, error) {
+	current := *coupon
+	if coupon.MaxTotalUsage == 0 && coupon.UsageType != models.UsageTypeOneTime {
+		return &current, nil
+	}
+	usages, err := s.storage.GetCouponUsages(ctx, "", []string{coupon.ID})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching coupon usage: %w", err)
+	}
+	usage, ok := usages[coupon.ID]
+	if !ok {
+		return nil, nil
+	}
+	current.CurrentTotalUsage = usage.TotalUsage
+	return &current, nil
+}
+
+// forgetCouponLookups drops every cached coupon and unknown code, after coupons change.
+func (s *CouponService) forgetCouponLookups() {
+	s.couponLookups.coupons.InvalidateAll()
+	s.couponLookups.unknown.InvalidateAll()
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"cmp"
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"fmt"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// Number of near misses reported with the applicable coupons.
+	maxNearMisses = 3
+	// Number of stackable coupons, best first, that are tried in combination when looking for the best choice.
+	// Every subset is tried, so this keeps the search to a few thousand combinations.
+	maxStackSearchSize = 10
+)
+
+// rankBySavings orders applicable coupons by the discount they give, largest first, ties broken by coupon code,
+// and numbers them from 1.
+func rankBySavings(coupons []models.ApplicableCoupon) {
+	slices.SortStableFunc(coupons, func(a, b models.ApplicableCoupon) int {
+		if c := b.Discount.Cmp(a.Discount); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.CouponCode, b.CouponCode)
+	})
+	for i := range coupons {
+		coupons[i].Rank = i + 1
+	}
+}
+
+// findBestCoupon returns the coupon, or combination of stackable coupons, that saves the most on the cart. A
+// combination only wins if it saves strictly more than every smaller choice, and only combinations in which
+// every coupon applies are considered. It returns nil when no coupon gives a discount.
+func findBestCoupon(coupons []models.Coupon, discounts []DiscountResult, cartItems []models.CartItem, orderTotal money.Money) *models.BestCoupon {
+	var best *models.BestCoupon
+	for i := range coupons {
+		if discounts[i].Total.IsPositive() && (best == nil || best.Discount.LessThan(discounts[i].Total)) {
+			best = &models.BestCoupon{CouponCodes: []string{coupons[i].CouponCode}, Discount: discounts[i].Total}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	var stackable []int
+	for i := range coupons {
+		if coupons[i].Stackable && discounts[i].Total.IsPositive() {
+			stackable = append(stackable, i)
+		}
+	}
+	slices.SortStableFunc(stackable, func(a, b int) int {
+		return discounts[b].Total.Cmp(discounts[a].Total)
+	})
+	stackable = stackable[:min(len(stackable), maxStackSearchSize)]
+
+	bestSize := 1
+	for subset := uint(1); subset < 1<<len(stackable); subset++ {
+		size := bits.OnesCount(subset)
+		if size < 2 {
+			continue
+		}
+
+		combination := make([]*models.Coupon, 0, size)
+		for n, i := range stackable {
+			if subset&(1<<n) != 0 {
+				combination = append(combination, &coupons[i])
+			}
+		}
+
+		applied, rejected := applyStack(combination, cartItems, orderTotal)
+		if len(rejected) > 0 {
+			continue
+		}
+		var total money.Money
+		for _, stacked := range applied {
+			total = total.Add(stacked.result.Total)
+		}
+
+		if c := total.Cmp(best.Discount); c > 0 || c == 0 && size < bestSize {
+			codes := make([]string, 0, len(applied))
+			for _, stacked := range applied {
+				codes = append(codes, stacked.coupon.CouponCode)
+			}
+			best = &models.BestCoupon{CouponCodes: codes, Discount: total, Stacked: true}
+			bestSize = size
+		}
+	}
+	return best
+}
+
+// newNearMissCoupon describes how much more the order needs for a coupon to apply.
+func newNearMissCoupon(coupon *models.Coupon, orderTotal money.Money) models.NearMissCoupon {
+	needed := coupon.MinOrderValue.Sub(orderTotal)
+	return models.NearMissCoupon{
+		CouponCode:    coupon.CouponCode,
+		DiscountValue: discountValue(coupon),
+		DiscountType:  coupon.DiscountType,
+		MinOrderValue: coupon.MinOrderValue,
+		AmountNeeded:  needed,
+		Message:       fmt.Sprintf("Add %s %s more to unlock %s", needed.Currency, needed, coupon.CouponCode),
+	}
+}
+
+// setTierProgress reports the tier a tiered coupon reaches and the next tier up with how much more it needs.
+func setTierProgress(applicable *models.ApplicableCoupon, coupon *models.Coupon, orderTotal money.Money) {
+	reached, next := reachedTier(coupon, orderTotal)
+	if reached != nil {
+		tier := newDiscountTier(reached)
+		applicable.TierReached = &tier
+	}
+	if next != nil {
+		applicable.NextTier = &models.NextDiscountTier{
+			DiscountTier: newDiscountTier(next),
+			AmountNeeded: next.Threshold.Sub(orderTotal),
+		}
+	}
+}
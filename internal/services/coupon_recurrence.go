@@ -0,0 +1,121 @@
+package services
+
+import (
+	"coupon-system/internal/models"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// weekdayNames are the day names recurring windows use, indexed by time.Weekday.
+var weekdayNames = [...]string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+const (
+	minutesPerDay = 24 * 60
+	// Number of calendar weeks of the month a recurring window can pick from. Only months starting late in the
+	// week reach week 6.
+	weeksPerMonth = 6
+)
+
+// newRecurrence converts a recurring window from a request into the form coupons store.
+func newRecurrence(window *models.RecurringWindow) (models.Recurrence, error) {
+	var recurrence models.Recurrence
+	if window == nil {
+		return recurrence, nil
+	}
+
+	for _, day := range window.Days {
+		i := slices.Index(weekdayNames[:], day)
+		if i < 0 {
+			return recurrence, fmt.Errorf("%w: unknown day %q in recurring window", ErrInvalidCoupon, day)
+		}
+		recurrence.Days |= 1 << i
+	}
+	for _, week := range window.WeeksOfMonth {
+		if week < 1 || week > weeksPerMonth {
+			return recurrence, fmt.Errorf("%w: weeks of the month must be between 1 and %d", ErrInvalidCoupon, weeksPerMonth)
+		}
+		recurrence.Weeks |= 1 << (week - 1)
+	}
+
+	if (window.StartTime == "") != (window.EndTime == "") {
+		return recurrence, fmt.Errorf("%w: recurring window needs both a start and an end time", ErrInvalidCoupon)
+	}
+	if window.StartTime == "" {
+		return recurrence, nil
+	}
+	start, err := parseTimeOfDay(window.StartTime, false)
+	if err != nil {
+		return recurrence, err
+	}
+	end, err := parseTimeOfDay(window.EndTime, true)
+	if err != nil {
+		return recurrence, err
+	}
+	if start == end {
+		return recurrence, fmt.Errorf("%w: recurring window must end at a different time than it starts", ErrInvalidCoupon)
+	}
+	recurrence.StartMinute, recurrence.EndMinute = start, end
+	return recurrence, nil
+}
+
+// parseTimeOfDay parses a time of day such as "18:30" into minutes after midnight. End times can also be "24:00".
+func parseTimeOfDay(s string, isEnd bool) (int, error) {
+	if isEnd && s == "24:00" {
+		return minutesPerDay, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: time of day %q must look like 18:30", ErrInvalidCoupon, s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// newRecurringWindow converts a coupon's recurrence for responses, or returns nil if it has none.
+func newRecurringWindow(recurrence models.Recurrence) *models.RecurringWindow {
+	if recurrence == (models.Recurrence{}) {
+		return nil
+	}
+
+	window := &models.RecurringWindow{}
+	for i, name := range weekdayNames {
+		if recurrence.Days&(1<<i) != 0 {
+			window.Days = append(window.Days, name)
+		}
+	}
+	for week := 1; week <= weeksPerMonth; week++ {
+		if recurrence.Weeks&(1<<(week-1)) != 0 {
+			window.WeeksOfMonth = append(window.WeeksOfMonth, week)
+		}
+	}
+	if recurrence.StartMinute != recurrence.EndMinute {
+		window.StartTime = formatTimeOfDay(recurrence.StartMinute)
+		window.EndTime = formatTimeOfDay(recurrence.EndMinute)
+	}
+	return window
+}
+
+func formatTimeOfDay(minute int) string {
+	return fmt.Sprintf("%02d:%02d", minute/60, minute%60)
+}
+
+// inRecurrence reports whether a local time falls within a recurrence. The days and calendar weeks are those of
+// the local date, so a window past midnight continues into the next day only if that day is also allowed.
+func inRecurrence(recurrence models.Recurrence, local time.Time) bool {
+	if recurrence.Days != 0 && recurrence.Days&(1<<int(local.Weekday())) == 0 {
+		return false
+	}
+	if recurrence.Weeks != 0 && recurrence.Weeks&(1<<(models.WeekOfMonth(local)-1)) == 0 {
+		return false
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	switch {
+	case recurrence.StartMinute == recurrence.EndMinute:
+		return true
+	case recurrence.StartMinute < recurrence.EndMinute:
+		return minute >= recurrence.StartMinute && minute < recurrence.EndMinute
+	default:
+		return minute >= recurrence.StartMinute || minute < recurrence.EndMinute
+	}
+}
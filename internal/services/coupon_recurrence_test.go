@@ -0,0 +1,46 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"coupon-system/internal/models"
+)
+
+// Weeks of the month are calendar weeks from Monday to Sunday, so a recurring window on the weekend of week 1
+// never pairs days of two different weekends, whichever weekday the month starts on.
+func TestWeekendOfFirstWeek(t *testing.T) {
+	window, err := newRecurrence(&models.RecurringWindow{Days: []string{"saturday", "sunday"}, WeeksOfMonth: []int{1}})
+	if err != nil {
+		t.Fatalf("failed to create recurrence: %v", err)
+	}
+	tests := []struct {
+		date     string
+		wantWeek int
+		wantIn   bool
+	}{
+		{"2026-08-01", 1, true},  // The 1st is a Saturday
+		{"2026-08-02", 1, true},  // and the Sunday after it
+		{"2026-08-08", 2, false}, // The next Saturday
+		{"2026-02-01", 1, true},  // The 1st is a Sunday, ending a weekend that started in January
+		{"2026-02-07", 2, false}, // so the next Saturday is in week 2
+		{"2026-02-08", 2, false},
+		{"2026-01-03", 1, true}, // The 1st is a Thursday
+		{"2026-01-04", 1, true},
+		{"2026-03-30", 6, false}, // A 31-day month starting on a Sunday ends in week 6
+	}
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			date, err := time.Parse(time.DateOnly, tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if week := models.WeekOfMonth(date); week != tt.wantWeek {
+				t.Errorf("%s is in week %d, want week %d", tt.date, week, tt.wantWeek)
+			}
+			if in := inRecurrence(window, date.Add(12*time.Hour)); in != tt.wantIn {
+				t.Errorf("%s is in the window: %v, want %v", tt.date, in, tt.wantIn)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"coupon-system/internal/rules"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// Eligibility rules are parsed once and kept by their source, so that validating a coupon, which an applicable
+// coupons request does for every candidate, does not parse its rule again. Rules are parsed when a coupon is
+// saved and when the coupon index is loaded; a rule dropped from the cache is parsed again on its next use.
+const compiledRuleCacheSize = 10_000
+
+// compiledRule is a parsed rule, or the error parsing it failed with.
+type compiledRule struct {
+	rule *rules.Rule
+	err  error
+}
+
+var compiledRules = func() *lru.Cache[string, compiledRule] {
+	cache, err := lru.New[string, compiledRule](compiledRuleCacheSize)
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}()
+
+// compileRule returns the eligibility rule parsed from source, parsing it only if it is not cached. Rules are
+// only read once parsed, so the same one can be evaluated by concurrent requests.
+func compileRule(source string) (*rules.Rule, error) {
+	if compiled, ok := compiledRules.Get(source); ok {
+		return compiled.rule, compiled.err
+	}
+	rule, err := rules.Parse(source)
+	compiledRules.Add(source, compiledRule{rule: rule, err: err})
+	return rule, err
+}
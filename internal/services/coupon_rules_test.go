@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"coupon-system/internal/models"
+)
+
+// A coupon's eligibility rule is parsed when the coupon is saved, and validating the coupon evaluates that
+// parsed rule rather than parsing it again.
+func TestEligibilityRulesAreParsedOnce(t *testing.T) {
+	const source = "category = Vitamins AND quantity >= 3"
+	ctx := context.Background()
+	service := newTestService(t)
+	err := service.CreateCoupon(ctx, &models.CreateCouponRequest{
+		CouponCode:      "VITAMINS3",
+		ExpiryDate:      time.Now().Add(time.Hour),
+		UsageType:       models.UsageTypeMultiUse,
+		DiscountType:    "fixed_amount",
+		DiscountValue:   "5.00",
+		EligibilityRule: source,
+	})
+	if err != nil {
+		t.Fatalf("failed to create coupon: %v", err)
+	}
+	coupon := &models.Coupon{EligibilityRule: source}
+	compiled, ok := compiledRules.Get(source)
+	if !ok || compiled.err != nil {
+		t.Fatalf("saving the coupon did not cache its parsed rule")
+	}
+	if rule, _ := compileRule(source); rule != compiled.rule {
+		t.Errorf("the rule was parsed again")
+	}
+
+	validator := NewEligibilityRuleValidator()
+	tests := []struct {
+		name       string
+		cart       []models.CartItem
+		wantReason string // Empty when the rule is met
+	}{
+		{"met", []models.CartItem{{ID: "med1", Category: "Vitamins", Price: inr("10.00"), Quantity: 3}}, ""},
+		{"not met", []models.CartItem{{ID: "med1", Category: "Vitamins", Price: inr("10.00"), Quantity: 2}}, models.ReasonRuleNotMet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.Validate(ctx, coupon, &ValidationInput{CartItems: tt.cart, OrderTotal: inr("30.00")})
+			var validationErr *ValidationError
+			switch {
+			case tt.wantReason == "" && err != nil:
+				t.Errorf("Validate returned %v, want nil", err)
+			case tt.wantReason != "" && (!errors.As(err, &validationErr) || validationErr.Code != tt.wantReason):
+				t.Errorf("Validate returned %v, want reason %q", err, tt.wantReason)
+			}
+		})
+	}
+	if after, _ := compiledRules.Get(source); after.rule != compiled.rule {
+		t.Errorf("validating the coupon parsed its rule again")
+	}
+}
@@ -4,213 +4,1003 @@ import (
 	"context"
 	"coupon-system/internal/caching"
 	"coupon-system/internal/models"
+	"coupon-system/internal/money"
 	"coupon-system/internal/storage/database"
-	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
+	"log"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	// ErrCouponNotFound is returned when no coupon matches the requested code.
+	ErrCouponNotFound = errors.New("coupon not found")
+	// ErrCouponNotApplicable is returned when a coupon fails validation against the cart it is being held for.
+	ErrCouponNotApplicable = errors.New("coupon not applicable")
+	// ErrInvalidCoupon is returned when a coupon's settings are missing or contradict each other.
+	ErrInvalidCoupon = errors.New("invalid coupon")
+	// ErrRedemptionNotFound is returned when a redemption does not exist or belongs to another user.
+	ErrRedemptionNotFound = errors.New("redemption not found")
+	// ErrInvalidCart is returned when a cart has a negative price, quantity or order total, or an order total
+	// below its lines.
+	ErrInvalidCart = errors.New("invalid cart")
+)
+
 type CouponService struct {
 	storage                database.CouponStorage
-	applicableCouponsCache caching.Cache[string, *models.ApplicableCouponsResponse]
+	applicableCouponsCache caching.TaggedCache[string, *models.ApplicableCouponsResponse]
+	redemptionHoldTTL      time.Duration
+	timeZone               *time.Location // Zone of the local times in recurring windows
+	validators             *ValidatorRegistry
+	applicableCouponsStats caching.Stats
+
+	indexMu      sync.Mutex
+	indexLoadMu  sync.Mutex   // Held while loading the index, so only one request loads it
+	index        *couponIndex // Nil until loaded, and again when it cannot follow a change
+	indexVersion uint64       // Incremented whenever coupons or the exclusions change, or the index is reset
+
+	couponLookups *couponLookupCache
 }
 
-func NewCouponService(storage database.CouponStorage, applicableCouponsCache caching.Cache[string, *models.ApplicableCouponsResponse]) *CouponService {
-	return &CouponService{
+func NewCouponService(storage database.CouponStorage, applicableCouponsCache caching.TaggedCache[string, *models.ApplicableCouponsResponse], redemptionHoldTTL time.Duration, timeZone *time.Location) *CouponService {
+	s := &CouponService{
 		storage:                storage,
 		applicableCouponsCache: applicableCouponsCache,
+		redemptionHoldTTL:      redemptionHoldTTL,
+		timeZone:               timeZone,
+		validators:             NewDefaultValidatorRegistry(storage, timeZone),
+		couponLookups:          newCouponLookupCache(),
+	}
+	// Another server sharing the cache drops every result when coupons change there
+	if notifier, ok := applicableCouponsCache.(caching.InvalidationNotifier); ok {
+		notifier.OnInvalidate(s.remoteInvalidation)
 	}
+	return s
+}
+
+// Validators returns the registry of the validators coupons run, so more can be registered at startup.
+func (s *CouponService) Validators() *ValidatorRegistry {
+	return s.validators
 }
 
+const (
+	// Page size used by ListCoupons when the request does not set one.
+	defaultCouponPageSize = 20
+	// Largest discount a percentage coupon can give: 100%, in basis points.
+	maxDiscountPercent money.Percent = 100_00
+)
+
 // CreateCoupon handles the creation of a new coupon.
 func (s *CouponService) CreateCoupon(ctx context.Context, req *models.CreateCouponRequest) error {
-	if req.CouponCode == "" {
-		return fmt.Errorf("coupon code is required")
-	}
-	if req.ExpiryDate.IsZero() {
-		return fmt.Errorf("expiry date is required")
-	}
-	if req.DiscountType == "" {
-		return fmt.Errorf("discount type is required")
-	}
-	if req.DiscountValue <= 0 {
-		return fmt.Errorf("discount value must be greater than 0")
-	}
-
 	couponID := uuid.New().String()
 
 	coupon := &models.Coupon{
 		ID:                   couponID,
-		CouponCode:           req.CouponCode,
+		CouponCode:           strings.TrimSpace(req.CouponCode),
 		ExpiryDate:           req.ExpiryDate,
 		UsageType:            req.UsageType,
-		MinOrderValue:        req.MinOrderValue,
+		MinOrderValue:        money.New(req.MinOrderValue.Amount, money.DefaultCurrency),
 		ValidTimeWindowStart: req.ValidTimeWindowStart,
 		ValidTimeWindowEnd:   req.ValidTimeWindowEnd,
 		TermsAndConditions:   req.TermsAndConditions,
+		EligibilityRule:      strings.TrimSpace(req.EligibilityRule),
 		DiscountType:         req.DiscountType,
-		DiscountValue:        req.DiscountValue,
+		BuyQuantity:          req.BuyQuantity,
+		GetQuantity:          req.GetQuantity,
+		MaxDiscountAmount:    money.New(req.MaxDiscountAmount.Amount, money.DefaultCurrency),
 		MaxUsagePerUser:      req.MaxUsagePerUser,
 		MaxTotalUsage:        req.MaxTotalUsage,
+		IsActive:             true,
+		Stackable:            req.Stackable,
+		ExclusivityGroup:     strings.TrimSpace(req.ExclusivityGroup),
+		Priority:             req.Priority,
 		CreatedAt:            time.Now(),
 		UpdatedAt:            time.Now(),
 	}
+	setCouponAssociations(coupon, req.ApplicableMedicineIDs, req.ApplicableCategories)
+	setBuyXGetYSets(coupon, req.TriggerMedicineIDs, req.TriggerCategories, req.RewardMedicineIDs, req.RewardCategories)
+	coupon.ExcludedMedicines = toMedicines(req.ExcludedMedicineIDs)
+	coupon.ExcludedCategories = toCategories(req.ExcludedCategories)
+
+	recurrence, err := newRecurrence(req.RecurringWindow)
+	if err != nil {
+		return err
+	}
+	coupon.Recurrence = recurrence
+
+	if err := setDiscountValue(coupon, req.DiscountValue); err != nil {
+		return err
+	}
+	if err := setTiers(coupon, req.Tiers); err != nil {
+		return err
+	}
+	setUsageLimits(coupon)
+	if err := validateCouponSettings(coupon); err != nil {
+		return err
+	}
+
+	if err := s.storage.CreateCoupon(ctx, coupon); err != nil {
+		return err
+	}
+	s.couponSaved(ctx, coupon.ID)
+	return nil
+}
+
+// GetCoupon fetches a coupon by its ID.
+func (s *CouponService) GetCoupon(ctx context.Context, couponID string) (*models.CouponResponse, error) {
+	coupon, err := s.getCouponByID(ctx, couponID)
+	if err != nil {
+		return nil, err
+	}
+	return newCouponResponse(coupon), nil
+}
+
+// ListCoupons fetches one page of the coupons matching the request's filters.
+func (s *CouponService) ListCoupons(ctx context.Context, req *models.ListCouponsRequest) (*models.ListCouponsResponse, error) {
+	page := req.Page
+	if page == 0 {
+		page = 1
+	}
+	pageSize := req.PageSize
+	if pageSize == 0 {
+		pageSize = defaultCouponPageSize
+	}
+	if req.ExpiresAfter != nil && req.ExpiresBefore != nil && req.ExpiresAfter.After(*req.ExpiresBefore) {
+		return nil, fmt.Errorf("%w: expires_after must not be later than expires_before", ErrInvalidCoupon)
+	}
+
+	coupons, total, err := s.storage.ListCoupons(ctx, database.CouponFilter{
+		Status:        req.Status,
+		UsageType:     req.UsageType,
+		ExpiresAfter:  req.ExpiresAfter,
+		ExpiresBefore: req.ExpiresBefore,
+		MedicineID:    strings.TrimSpace(req.MedicineID),
+		Category:      strings.TrimSpace(req.Category),
+		Now:           time.Now(),
+		Offset:        (page - 1) * pageSize,
+		Limit:         pageSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing coupons: %w", err)
+	}
+
+	response := &models.ListCouponsResponse{
+		Coupons:  make([]models.CouponResponse, 0, len(coupons)),
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	}
+	for i := range coupons {
+		response.Coupons = append(response.Coupons, *newCouponResponse(&coupons[i]))
+	}
+	return response, nil
+}
+
+// UpdateCoupon applies the fields set in the request to an existing coupon.
+func (s *CouponService) UpdateCoupon(ctx context.Context, couponID string, req *models.UpdateCouponRequest) (*models.CouponResponse, error) {
+	coupon, err := s.getCouponByID(ctx, couponID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.CouponCode != nil {
+		coupon.CouponCode = strings.TrimSpace(*req.CouponCode)
+	}
+	if req.ExpiryDate != nil {
+		coupon.ExpiryDate = *req.ExpiryDate
+	}
+	if req.UsageType != nil {
+		coupon.UsageType = *req.UsageType
+	}
+	if req.MinOrderValue != nil {
+		coupon.MinOrderValue = money.New(req.MinOrderValue.Amount, money.DefaultCurrency)
+	}
+	if req.ValidTimeWindowStart != nil {
+		coupon.ValidTimeWindowStart = req.ValidTimeWindowStart
+	}
+	if req.ValidTimeWindowEnd != nil {
+		coupon.ValidTimeWindowEnd = req.ValidTimeWindowEnd
+	}
+	if req.RecurringWindow != nil {
+		recurrence, err := newRecurrence(req.RecurringWindow)
+		if err != nil {
+			return nil, err
+		}
+		coupon.Recurrence = recurrence
+	}
+	if req.TermsAndConditions != nil {
+		coupon.TermsAndConditions = *req.TermsAndConditions
+	}
+	if req.EligibilityRule != nil {
+		coupon.EligibilityRule = strings.TrimSpace(*req.EligibilityRule)
+	}
+	// The discount value is kept as entered when only the discount type changes
+	discount := discountValue(coupon)
+	if req.DiscountValue != nil {
+		discount = *req.DiscountValue
+	}
+	if req.DiscountType != nil {
+		coupon.DiscountType = *req.DiscountType
+	}
+	if err := setDiscountValue(coupon, discount); err != nil {
+		return nil, err
+	}
+	tiers := tiersOf(coupon)
+	if req.Tiers != nil {
+		tiers = *req.Tiers
+	}
+	if err := setTiers(coupon, tiers); err != nil {
+		return nil, err
+	}
+	if req.BuyQuantity != nil {
+		coupon.BuyQuantity = *req.BuyQuantity
+	}
+	if req.GetQuantity != nil {
+		coupon.GetQuantity = *req.GetQuantity
+	}
+	if req.MaxDiscountAmount != nil {
+		coupon.MaxDiscountAmount = money.New(req.MaxDiscountAmount.Amount, money.DefaultCurrency)
+	}
+	if req.MaxUsagePerUser != nil {
+		coupon.MaxUsagePerUser = *req.MaxUsagePerUser
+	}
+	if req.MaxTotalUsage != nil {
+		coupon.MaxTotalUsage = *req.MaxTotalUsage
+	}
+	if req.IsActive != nil {
+		coupon.IsActive = *req.IsActive
+	}
+	if req.Stackable != nil {
+		coupon.Stackable = *req.Stackable
+	}
+	if req.ExclusivityGroup != nil {
+		coupon.ExclusivityGroup = strings.TrimSpace(*req.ExclusivityGroup)
+	}
+	if req.Priority != nil {
+		coupon.Priority = *req.Priority
+	}
+
+	medicineIDs := getMedicineIDs(coupon)
+	if req.ApplicableMedicineIDs != nil {
+		medicineIDs = *req.ApplicableMedicineIDs
+	}
+	categories := getCategoryIDs(coupon)
+	if req.ApplicableCategories != nil {
+		categories = *req.ApplicableCategories
+	}
+	setCouponAssociations(coupon, medicineIDs, categories)
+
+	triggerMedicineIDs, triggerCategories := medicineIDsOf(coupon.TriggerMedicines), categoryIDsOf(coupon.TriggerCategories)
+	if req.TriggerMedicineIDs != nil {
+		triggerMedicineIDs = *req.TriggerMedicineIDs
+	}
+	if req.TriggerCategories != nil {
+		triggerCategories = *req.TriggerCategories
+	}
+	rewardMedicineIDs, rewardCategories := medicineIDsOf(coupon.RewardMedicines), categoryIDsOf(coupon.RewardCategories)
+	if req.RewardMedicineIDs != nil {
+		rewardMedicineIDs = *req.RewardMedicineIDs
+	}
+	if req.RewardCategories != nil {
+		rewardCategories = *req.RewardCategories
+	}
+	setBuyXGetYSets(coupon, triggerMedicineIDs, triggerCategories, rewardMedicineIDs, rewardCategories)
+
+	if req.ExcludedMedicineIDs != nil {
+		coupon.ExcludedMedicines = toMedicines(*req.ExcludedMedicineIDs)
+	}
+	if req.ExcludedCategories != nil {
+		coupon.ExcludedCategories = toCategories(*req.ExcludedCategories)
+	}
+	coupon.UpdatedAt = time.Now()
 
-	for _, id := range req.ApplicableMedicineIDs {
-		coupon.MedicineIDs = append(coupon.MedicineIDs, models.Medicine{ID: strings.TrimSpace(id)})
+	setUsageLimits(coupon)
+	if err := validateCouponSettings(coupon); err != nil {
+		return nil, err
 	}
-	for _, category := range req.ApplicableCategories {
-		coupon.Categories = append(coupon.Categories, models.Category{ID: strings.TrimSpace(category)})
+
+	if err := s.storage.UpdateCoupon(ctx, coupon); err != nil {
+		return nil, fmt.Errorf("error updating coupon: %w", err)
 	}
+	s.couponSaved(ctx, coupon.ID)
+	return newCouponResponse(coupon), nil
+}
 
-	return s.storage.CreateCoupon(ctx, coupon)
+// DeleteCoupon removes a coupon so it can no longer be found or applied.
+func (s *CouponService) DeleteCoupon(ctx context.Context, couponID string) error {
+	deleted, err := s.storage.DeleteCoupon(ctx, couponID)
+	if err != nil {
+		return fmt.Errorf("error deleting coupon: %w", err)
+	}
+	if !deleted {
+		return ErrCouponNotFound
+	}
+	s.couponDeleted(couponID)
+	return nil
 }
 
-// ValidateCoupon handles the validation of a coupon against a cart.
+// ValidateCoupon handles the validation of a coupon against a cart. It has no side effects;
+// use CreateRedemption to actually hold the coupon for an order.
 func (s *CouponService) ValidateCoupon(ctx context.Context, userID string, req *models.ValidateCouponRequest) (*models.ValidateCouponResponse, error) {
-	coupon, err := s.storage.GetCouponByCode(ctx, req.CouponCode)
+	if err := validateCart(req.CartItems, req.OrderTotal); err != nil {
+		return nil, err
+	}
+	coupon, err := s.getCoupon(ctx, req.CouponCode)
+	if err != nil {
+		if errors.Is(err, ErrCouponNotFound) {
+			failure := models.ValidationFailure{Code: models.ReasonCouponNotFound, Message: "Coupon not found"}
+			return &models.ValidateCouponResponse{IsValid: false, Message: failure.Message, Reason: failure.Code, Failures: []models.ValidationFailure{failure}}, nil
+		}
+		return nil, err
+	}
+	exclusions, err := s.getExclusions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	coupon = withExclusions(coupon, exclusions)
+
+	failures, err := s.runValidators(ctx, coupon, newValidationInput(userID, req), req.CollectAllFailures)
+	if err != nil {
+		return nil, err
+	}
+	if len(failures) > 0 {
+		return &models.ValidateCouponResponse{
+			IsValid:  false,
+			Message:  failures[0].Message,
+			Reason:   failures[0].Code,
+			Failures: failures,
+		}, nil
+	}
+
+	// Calculate discount
+	discount := calculateDiscount(coupon, req.CartItems, req.OrderTotal)
+
+	discountDetails := &models.DiscountDetails{
+		ItemsDiscount: discount.Total,
+		TotalDiscount: discount.Total,
+		CappedBy:      discount.CappedBy,
+		Lines:         discount.Lines,
+	}
+
+	return &models.ValidateCouponResponse{IsValid: true, Discount: discountDetails, Message: "Coupon is valid"}, nil
+}
+
+// CreateRedemption validates a coupon against the cart and holds it for the order until the hold is
+// committed, released or expires. The hold counts against the coupon's usage limits while it is active.
+func (s *CouponService) CreateRedemption(ctx context.Context, userID string, req *models.CreateRedemptionRequest) (*models.RedemptionResponse, error) {
+	if err := validateCart(req.CartItems, req.OrderTotal); err != nil {
+		return nil, err
+	}
+	coupon, err := s.getCoupon(ctx, req.CouponCode)
+	if err != nil {
+		return nil, err
+	}
+	exclusions, err := s.getExclusions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	coupon = withExclusions(coupon, exclusions)
+
+	// Holds are checked against the server's clock, so a client cannot hold an expired coupon by sending an
+	// earlier time
+	input := newValidationInput(userID, &req.ValidateCouponRequest)
+	input.Timestamp = time.Now()
+	failures, err := s.runValidators(ctx, coupon, input, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCouponNotApplicable, failures[0].Message)
+	}
+
+	discount := calculateDiscount(coupon, req.CartItems, req.OrderTotal)
+
+	now := time.Now()
+	redemption := &models.CouponRedemption{
+		ID:            uuid.New().String(),
+		CouponID:      coupon.ID,
+		CouponCode:    coupon.CouponCode,
+		UserID:        userID,
+		OrderID:       req.OrderID,
+		Status:        models.RedemptionStatusHeld,
+		TotalDiscount: discount.Total,
+		ExpiresAt:     now.Add(s.redemptionHoldTTL),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	holdID := redemption.ID
+	if err := s.storage.CreateRedemption(ctx, redemption); err != nil {
+		return nil, fmt.Errorf("error holding coupon: %w", err)
+	}
+	// A retry for the order gets the hold already placed, as it was placed: its discount was worked out for the
+	// cart of the first attempt, and no use was taken this time
+	if redemption.ID != holdID {
+		return newRedemptionResponse(redemption), nil
+	}
+	s.usageTaken(userID, coupon)
+
+	response := newRedemptionResponse(redemption)
+	response.Discount.CappedBy = discount.CappedBy
+	response.Discount.Lines = discount.Lines
+	return response, nil
+}
+
+// CommitRedemption confirms a held redemption once checkout succeeds.
+func (s *CouponService) CommitRedemption(ctx context.Context, userID string, redemptionID string) (*models.RedemptionResponse, error) {
+	if err := s.checkRedemptionOwner(ctx, userID, redemptionID); err != nil {
+		return nil, err
+	}
+
+	redemption, err := s.storage.CommitRedemption(ctx, redemptionID, time.Now())
+	// A hold that expired before it could be committed is given back, and the commit still fails
+	if errors.Is(err, database.ErrRedemptionNotHeld) && redemption != nil && redemption.Status == models.RedemptionStatusExpired {
+		s.usageReturned(userID, redemption.CouponID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error committing redemption: %w", err)
+	}
+	if redemption == nil {
+		return nil, ErrRedemptionNotFound
+	}
+
+	return newRedemptionResponse(redemption), nil
+}
+
+// ReleaseRedemption gives a held redemption back when checkout is abandoned.
+func (s *CouponService) ReleaseRedemption(ctx context.Context, userID string, redemptionID string) (*models.RedemptionResponse, error) {
+	if err := s.checkRedemptionOwner(ctx, userID, redemptionID); err != nil {
+		return nil, err
+	}
+
+	redemption, err := s.storage.ReleaseRedemption(ctx, redemptionID)
+	if err != nil {
+		return nil, fmt.Errorf("error releasing redemption: %w", err)
+	}
+	if redemption == nil {
+		return nil, ErrRedemptionNotFound
+	}
+	s.usageReturned(userID, redemption.CouponID)
+
+	return newRedemptionResponse(redemption), nil
+}
+
+// StartRedemptionSweeper expires stale holds every interval until ctx is cancelled.
+func (s *CouponService) StartRedemptionSweeper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				expired, err := s.storage.ExpireRedemptions(ctx, now)
+				if err != nil {
+					log.Printf("failed to expire stale redemptions: %v", err)
+					continue
+				}
+				if len(expired) > 0 {
+					log.Printf("expired %d stale redemptions", len(expired))
+					s.holdsExpired(expired)
+				}
+			}
+		}
+	}()
+}
+
+// validateCart rejects carts that discounts cannot be worked out for: carts with a negative unit price,
+// quantity or order total, and carts whose order total is less than their lines add up to.
+func validateCart(cartItems []models.CartItem, orderTotal money.Money) error {
+	if orderTotal.IsNegative() {
+		return fmt.Errorf("%w: order total cannot be negative", ErrInvalidCart)
+	}
+	var linesTotal money.Money
+	for i, item := range cartItems {
+		if item.Price.IsNegative() {
+			return fmt.Errorf("%w: cart item %d has a negative price", ErrInvalidCart, i)
+		}
+		if item.Quantity < 0 {
+			return fmt.Errorf("%w: cart item %d has a negative quantity", ErrInvalidCart, i)
+		}
+		linesTotal = linesTotal.Add(lineTotal(item))
+	}
+	if orderTotal.LessThan(linesTotal) {
+		return fmt.Errorf("%w: order total %s is less than the cart items' total of %s", ErrInvalidCart, orderTotal, linesTotal)
+	}
+	return nil
+}
+
+// getCoupon fetches a coupon by code, returning ErrCouponNotFound when it does not exist.
+func (s *CouponService) getCoupon(ctx context.Context, couponCode string) (*models.Coupon, error) {
+	coupon, err := s.lookupCoupon(ctx, couponCode)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return &models.ValidateCouponResponse{IsValid: false, Message: "Coupon not found"}, nil
+			return nil, ErrCouponNotFound
 		}
 		return nil, fmt.Errorf("error fetching coupon: %w", err)
 	}
 
 	if coupon == nil {
-		return &models.ValidateCouponResponse{IsValid: false, Message: "Coupon not found"}, nil
+		return nil, ErrCouponNotFound
 	}
+	return coupon, nil
+}
 
-	validators := []CouponValidator{
-		NewExpiryDateValidator(),
-		NewMinOrderValueValidator(),
-		NewApplicableCategoriesValidator(),
-		NewMaxUsagePerUserValidator(s.storage, userID),
-		NewMaxTotalUsageValidator(),
+// getCouponByID fetches a coupon by ID, returning ErrCouponNotFound when it does not exist.
+func (s *CouponService) getCouponByID(ctx context.Context, couponID string) (*models.Coupon, error) {
+	coupon, err := s.storage.GetCouponByID(ctx, couponID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching coupon: %w", err)
 	}
+	if coupon == nil {
+		return nil, ErrCouponNotFound
+	}
+	return coupon, nil
+}
 
-	for _, validator := range validators {
-		err := validator.Validate(coupon, req)
+// runValidators runs the coupon's validators from the registry and returns the first failure, or every failure
+// when collectAll is set. The error is only set when a rule could not be checked or the request was cancelled.
+func (s *CouponService) runValidators(ctx context.Context, coupon *models.Coupon, input *ValidationInput, collectAll bool) ([]models.ValidationFailure, error) {
+	var failures []models.ValidationFailure
+	for _, validator := range s.validators.ValidatorsFor(coupon) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := validator.Validate(ctx, coupon, input)
+		if err == nil {
+			continue
+		}
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			return nil, err
+		}
+		failures = append(failures, validationErr.ValidationFailure)
+		if !collectAll {
+			break
+		}
+	}
+	return failures, nil
+}
 
+// checkRedemptionOwner makes sure the redemption exists and was placed by the user.
+func (s *CouponService) checkRedemptionOwner(ctx context.Context, userID string, redemptionID string) error {
+	redemption, err := s.storage.GetRedemption(ctx, redemptionID)
+	if err != nil {
+		return fmt.Errorf("error fetching redemption: %w", err)
+	}
+	if redemption == nil || redemption.UserID != userID {
+		return ErrRedemptionNotFound
+	}
+	return nil
+}
+
+// validateCouponSettings checks a coupon's settings before it is created or updated.
+func validateCouponSettings(coupon *models.Coupon) error {
+	if coupon.CouponCode == "" {
+		return fmt.Errorf("%w: coupon code is required", ErrInvalidCoupon)
+	}
+	if coupon.ExpiryDate.IsZero() {
+		return fmt.Errorf("%w: expiry date is required", ErrInvalidCoupon)
+	}
+	if coupon.DiscountType == "" {
+		return fmt.Errorf("%w: discount type is required", ErrInvalidCoupon)
+	}
+	if (coupon.DiscountType == "percentage" || coupon.DiscountType == "buy_x_get_y") && coupon.DiscountPercent <= 0 || coupon.DiscountType == "fixed_amount" && !coupon.DiscountAmount.IsPositive() {
+		return fmt.Errorf("%w: discount value must be greater than 0", ErrInvalidCoupon)
+	}
+	if coupon.DiscountType == "buy_x_get_y" && (coupon.BuyQuantity <= 0 || coupon.GetQuantity <= 0) {
+		return fmt.Errorf("%w: buy and get quantities must be greater than 0", ErrInvalidCoupon)
+	}
+	if coupon.DiscountType == "tiered" && len(coupon.Tiers) == 0 {
+		return fmt.Errorf("%w: tiered coupons need at least one tier", ErrInvalidCoupon)
+	}
+	if coupon.DiscountPercent > maxDiscountPercent {
+		return fmt.Errorf("%w: percentage discount cannot exceed 100", ErrInvalidCoupon)
+	}
+	if coupon.MaxDiscountAmount.IsNegative() {
+		return fmt.Errorf("%w: maximum discount amount cannot be negative", ErrInvalidCoupon)
+	}
+	if coupon.MinOrderValue.IsNegative() {
+		return fmt.Errorf("%w: minimum order value cannot be negative", ErrInvalidCoupon)
+	}
+	if coupon.MaxUsagePerUser < 0 || coupon.MaxTotalUsage < 0 {
+		return fmt.Errorf("%w: usage limits cannot be negative", ErrInvalidCoupon)
+	}
+	if coupon.MaxTotalUsage > 0 && coupon.MaxUsagePerUser > coupon.MaxTotalUsage {
+		return fmt.Errorf("%w: max usage per user cannot exceed max total usage", ErrInvalidCoupon)
+	}
+	switch coupon.UsageType {
+	case models.UsageTypeOneTime:
+		if coupon.MaxTotalUsage > 1 {
+			return fmt.Errorf("%w: one_time coupons can be redeemed once in all, so max total usage must be 0 or 1; use multi_use with max usage per user 1 for a coupon each user can redeem once", ErrInvalidCoupon)
+		}
+	case models.UsageTypeMultiUse:
+	case models.UsageTypeTimeBased:
+		if !hasTimeWindow(coupon) {
+			return fmt.Errorf("%w: time_based coupons need a valid time window or a recurring window", ErrInvalidCoupon)
+		}
+	default:
+		return fmt.Errorf("%w: usage type must be one_time, multi_use or time_based", ErrInvalidCoupon)
+	}
+	if coupon.ValidTimeWindowStart != nil && coupon.ValidTimeWindowEnd != nil && coupon.ValidTimeWindowEnd.Before(*coupon.ValidTimeWindowStart) {
+		return fmt.Errorf("%w: valid time window ends before it starts", ErrInvalidCoupon)
+	}
+	if coupon.EligibilityRule != "" {
+		if _, err := compileRule(coupon.EligibilityRule); err != nil {
+			return fmt.Errorf("%w: eligibility rule: %v", ErrInvalidCoupon, err)
+		}
+	}
+	return nil
+}
+
+// setUsageLimits applies the usage limits a coupon's usage type implies: a "one_time" coupon can be redeemed
+// once in all, so its total usage limit is 1.
+func setUsageLimits(coupon *models.Coupon) {
+	if coupon.UsageType == models.UsageTypeOneTime && coupon.MaxTotalUsage == 0 {
+		coupon.MaxTotalUsage = 1
+	}
+}
+
+// hasTimeWindow reports whether a coupon is limited to a valid time window or a recurring window.
+func hasTimeWindow(coupon *models.Coupon) bool {
+	return coupon.ValidTimeWindowStart != nil || coupon.ValidTimeWindowEnd != nil || coupon.Recurrence != (models.Recurrence{})
+}
+
+// setDiscountValue stores the discount value as a percentage or an amount, depending on the coupon's discount type.
+func setDiscountValue(coupon *models.Coupon, value json.Number) error {
+	coupon.DiscountAmount = money.New(0, money.DefaultCurrency)
+	coupon.DiscountPercent = 0
+
+	switch coupon.DiscountType {
+	case "percentage", "buy_x_get_y":
+		percent, err := money.ParsePercent(value.String())
+		if err != nil {
+			return fmt.Errorf("%w: discount value: %v", ErrInvalidCoupon, err)
+		}
+		coupon.DiscountPercent = percent
+	case "fixed_amount":
+		amount, err := money.Parse(value.String(), money.DefaultCurrency)
 		if err != nil {
-			return &models.ValidateCouponResponse{
-				IsValid: false,
-				Message: err.Error(),
-			}, nil
+			return fmt.Errorf("%w: discount value: %v", ErrInvalidCoupon, err)
 		}
+		coupon.DiscountAmount = amount
 	}
+	return nil
+}
 
-	// Calculate discount
-	itemsDiscount := calculateDiscount(coupon, req.CartItems, req.OrderTotal)
+// setTiers replaces the tiers of a tiered coupon, ordered by threshold, and makes the lowest threshold the
+// coupon's minimum order value. Other coupons never have tiers.
+func setTiers(coupon *models.Coupon, tiers []models.DiscountTier) error {
+	coupon.Tiers = nil
+	if coupon.DiscountType != "tiered" {
+		return nil
+	}
 
-	discountDetails := &models.DiscountDetails{
-		ItemsDiscount: itemsDiscount,
-		TotalDiscount: itemsDiscount,
+	for _, tier := range tiers {
+		if tier.DiscountType != "percentage" && tier.DiscountType != "fixed_amount" {
+			return fmt.Errorf("%w: tier discount type must be percentage or fixed_amount", ErrInvalidCoupon)
+		}
+		// Parse the tier's discount the same way as a coupon's own
+		reward := &models.Coupon{DiscountType: tier.DiscountType}
+		if err := setDiscountValue(reward, tier.DiscountValue); err != nil {
+			return err
+		}
+		if reward.DiscountPercent <= 0 && !reward.DiscountAmount.IsPositive() {
+			return fmt.Errorf("%w: tier discount value must be greater than 0", ErrInvalidCoupon)
+		}
+		if reward.DiscountPercent > maxDiscountPercent {
+			return fmt.Errorf("%w: tier percentage discount cannot exceed 100", ErrInvalidCoupon)
+		}
+		if tier.MinOrderValue.IsNegative() {
+			return fmt.Errorf("%w: tier minimum order value cannot be negative", ErrInvalidCoupon)
+		}
+
+		coupon.Tiers = append(coupon.Tiers, models.CouponTier{
+			CouponID:        coupon.ID,
+			Threshold:       money.New(tier.MinOrderValue.Amount, money.DefaultCurrency),
+			DiscountType:    reward.DiscountType,
+			DiscountAmount:  reward.DiscountAmount,
+			DiscountPercent: reward.DiscountPercent,
+		})
 	}
 
-	err = s.storage.UpdateCouponUsage(ctx, coupon, userID)
-	if err != nil {
-		return nil, fmt.Errorf("error updating coupon usage: %w", err)
+	slices.SortStableFunc(coupon.Tiers, func(a, b models.CouponTier) int {
+		return a.Threshold.Cmp(b.Threshold)
+	})
+	for i := range coupon.Tiers {
+		if i > 0 && coupon.Tiers[i].Threshold.Cmp(coupon.Tiers[i-1].Threshold) == 0 {
+			return fmt.Errorf("%w: two tiers have the minimum order value %s", ErrInvalidCoupon, coupon.Tiers[i].Threshold)
+		}
+		coupon.Tiers[i].Position = i
 	}
+	if len(coupon.Tiers) > 0 {
+		coupon.MinOrderValue = coupon.Tiers[0].Threshold
+	}
+	return nil
+}
 
-	return &models.ValidateCouponResponse{IsValid: true, Discount: discountDetails, Message: "Coupon applied successfully"}, nil
+// tiersOf returns a coupon's tiers as API clients see them.
+func tiersOf(coupon *models.Coupon) []models.DiscountTier {
+	var tiers []models.DiscountTier
+	for i := range coupon.Tiers {
+		tiers = append(tiers, newDiscountTier(&coupon.Tiers[i]))
+	}
+	return tiers
 }
 
-// GetApplicableCoupons fetches all coupons applicable to a given cart.
+func newDiscountTier(tier *models.CouponTier) models.DiscountTier {
+	reward := tierCoupon(&models.Coupon{}, tier)
+	return models.DiscountTier{
+		MinOrderValue: tier.Threshold,
+		DiscountType:  tier.DiscountType,
+		DiscountValue: discountValue(reward),
+	}
+}
+
+// discountValue returns the coupon's discount as the single number API clients see: the percentage off for
+// percentage and buy-X-get-Y coupons, or the amount off for fixed amount coupons.
+func discountValue(coupon *models.Coupon) json.Number {
+	if coupon.DiscountType == "percentage" || coupon.DiscountType == "buy_x_get_y" {
+		return json.Number(coupon.DiscountPercent.String())
+	}
+	return json.Number(coupon.DiscountAmount.String())
+}
+
+// setCouponAssociations replaces the coupon's medicines and categories, skipping blanks and duplicates.
+func setCouponAssociations(coupon *models.Coupon, medicineIDs []string, categories []string) {
+	coupon.MedicineIDs = toMedicines(medicineIDs)
+	coupon.Categories = toCategories(categories)
+}
+
+// setBuyXGetYSets replaces the trigger and reward sets of a buy-X-get-Y coupon. Other coupons never have them.
+func setBuyXGetYSets(coupon *models.Coupon, triggerMedicineIDs, triggerCategories, rewardMedicineIDs, rewardCategories []string) {
+	if coupon.DiscountType != "buy_x_get_y" {
+		coupon.BuyQuantity, coupon.GetQuantity = 0, 0
+		triggerMedicineIDs, triggerCategories, rewardMedicineIDs, rewardCategories = nil, nil, nil, nil
+	}
+	coupon.TriggerMedicines = toMedicines(triggerMedicineIDs)
+	coupon.TriggerCategories = toCategories(triggerCategories)
+	coupon.RewardMedicines = toMedicines(rewardMedicineIDs)
+	coupon.RewardCategories = toCategories(rewardCategories)
+}
+
+// toMedicines turns medicine IDs into medicines, skipping blanks and duplicates.
+func toMedicines(ids []string) []models.Medicine {
+	medicines := []models.Medicine{}
+	for _, id := range ids {
+		medicine := models.Medicine{ID: strings.TrimSpace(id)}
+		if medicine.ID != "" && !slices.Contains(medicines, medicine) {
+			medicines = append(medicines, medicine)
+		}
+	}
+	return medicines
+}
+
+// toCategories turns category IDs into categories, skipping blanks and duplicates.
+func toCategories(ids []string) []models.Category {
+	categories := []models.Category{}
+	for _, id := range ids {
+		category := models.Category{ID: strings.TrimSpace(id)}
+		if category.ID != "" && !slices.Contains(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
+
+func getMedicineIDs(coupon *models.Coupon) []string {
+	return medicineIDsOf(coupon.MedicineIDs)
+}
+
+func getCategoryIDs(coupon *models.Coupon) []string {
+	return categoryIDsOf(coupon.Categories)
+}
+
+func medicineIDsOf(medicines []models.Medicine) []string {
+	medicineIDs := make([]string, 0, len(medicines))
+	for _, medicine := range medicines {
+		medicineIDs = append(medicineIDs, medicine.ID)
+	}
+	return medicineIDs
+}
+
+func categoryIDsOf(categories []models.Category) []string {
+	categoryIDs := make([]string, 0, len(categories))
+	for _, category := range categories {
+		categoryIDs = append(categoryIDs, category.ID)
+	}
+	return categoryIDs
+}
+
+func newCouponResponse(coupon *models.Coupon) *models.CouponResponse {
+	return &models.CouponResponse{
+		ID:                    coupon.ID,
+		CouponCode:            coupon.CouponCode,
+		ExpiryDate:            coupon.ExpiryDate,
+		UsageType:             coupon.UsageType,
+		ApplicableMedicineIDs: getMedicineIDs(coupon),
+		ApplicableCategories:  getCategoryIDs(coupon),
+		Currency:              coupon.MinOrderValue.Currency,
+		MinOrderValue:         coupon.MinOrderValue,
+		ValidTimeWindowStart:  coupon.ValidTimeWindowStart,
+		ValidTimeWindowEnd:    coupon.ValidTimeWindowEnd,
+		TermsAndConditions:    coupon.TermsAndConditions,
+		EligibilityRule:       coupon.EligibilityRule,
+		DiscountType:          coupon.DiscountType,
+		DiscountValue:         discountValue(coupon),
+		BuyQuantity:           coupon.BuyQuantity,
+		GetQuantity:           coupon.GetQuantity,
+		TriggerMedicineIDs:    medicineIDsOf(coupon.TriggerMedicines),
+		TriggerCategories:     categoryIDsOf(coupon.TriggerCategories),
+		RewardMedicineIDs:     medicineIDsOf(coupon.RewardMedicines),
+		RewardCategories:      categoryIDsOf(coupon.RewardCategories),
+		Tiers:                 tiersOf(coupon),
+		ExcludedMedicineIDs:   medicineIDsOf(coupon.ExcludedMedicines),
+		ExcludedCategories:    categoryIDsOf(coupon.ExcludedCategories),
+		MaxDiscountAmount:     coupon.MaxDiscountAmount,
+		MaxUsagePerUser:       coupon.MaxUsagePerUser,
+		MaxTotalUsage:         coupon.MaxTotalUsage,
+		CurrentTotalUsage:     coupon.CurrentTotalUsage,
+		IsActive:              coupon.IsActive,
+		Stackable:             coupon.Stackable,
+		ExclusivityGroup:      coupon.ExclusivityGroup,
+		Priority:              coupon.Priority,
+		CreatedAt:             coupon.CreatedAt,
+		UpdatedAt:             coupon.UpdatedAt,
+		RecurringWindow:       newRecurringWindow(coupon.Recurrence),
+	}
+}
+
+func newRedemptionResponse(redemption *models.CouponRedemption) *models.RedemptionResponse {
+	return &models.RedemptionResponse{
+		RedemptionID: redemption.ID,
+		CouponCode:   redemption.CouponCode,
+		OrderID:      redemption.OrderID,
+		Status:       redemption.Status,
+		ExpiresAt:    redemption.ExpiresAt,
+		Discount: &models.DiscountDetails{
+			ItemsDiscount: redemption.TotalDiscount,
+			TotalDiscount: redemption.TotalDiscount,
+		},
+	}
+}
+
+// GetApplicableCoupons fetches all coupons applicable to a given cart, the coupon or combination of coupons
+// that saves the most, and the coupons the cart would unlock with a larger order.
 func (s *CouponService) GetApplicableCoupons(ctx context.Context, userID string, req *models.ApplicableCouponsRequest) (*models.ApplicableCouponsResponse, error) {
-	cacheKey := generateApplicableCouponsCacheKey(userID, req)
+	if err := validateCart(req.CartItems, req.OrderTotal); err != nil {
+		return nil, err
+	}
+	// Read before loading anything, so a result loaded from data that changes in the meantime is not cached
+	cacheVersion := s.applicableCouponsCache.Version()
+	index, err := s.getCouponIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profile, exclusions := index.profileAndExclusions()
+	cartItems := profile.normalizeCart(req.CartItems)
+	cacheKey := applicableCouponsCacheKey(userID, req, cartItems, profile.bucket(req.Timestamp, s.timeZone))
 	if cachedResponse, found := s.applicableCouponsCache.Get(cacheKey); found {
+		s.applicableCouponsStats.Hit()
 		return cachedResponse, nil
 	}
-
-	coupons, err := s.storage.GetApplicableCoupons(ctx, req.Timestamp, req.OrderTotal, getMedicineIDsFromCart(req.CartItems), getCategoryIDsFromCart(req.CartItems), userID)
+	s.applicableCouponsStats.Miss()
+
+	input := &ValidationInput{
+		UserID:     userID,
+		CartItems:  cartItems,
+		OrderTotal: req.OrderTotal,
+		Timestamp:  req.Timestamp,
+		Channel:    req.Channel,
+	}
+	coupons, nearMissCoupons, usedUp, err := s.matchCoupons(ctx, index, input)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching all coupons: %w", err)
+		return nil, err
 	}
 
+	// The index only narrows the coupons down: each still runs its validators, as when it is validated alone,
+	// which also checks what the index cannot, such as eligibility rules and the units a buy-X-get-Y reward needs
+
 	var applicableCoupons []models.ApplicableCoupon
-	for _, coupon := range coupons {
+	applicable := make([]models.Coupon, 0, len(coupons))
+	discounts := make([]DiscountResult, 0, len(coupons))
+	for i := range coupons {
+		coupon := *withExclusions(&coupons[i], exclusions)
+		failures, err := s.runValidators(ctx, &coupon, input, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(failures) > 0 {
+			continue
+		}
+		discount := calculateDiscount(&coupon, cartItems, req.OrderTotal)
+		applicable = append(applicable, coupon)
+		discounts = append(discounts, discount)
 		applicableCoupons = append(applicableCoupons, models.ApplicableCoupon{
 			CouponCode:    coupon.CouponCode,
-			DiscountValue: coupon.DiscountValue,
+			DiscountValue: discountValue(&coupon),
 			DiscountType:  coupon.DiscountType,
-			Discount:      calculateDiscount(&coupon, req.CartItems, req.OrderTotal),
+			Discount:      discount.Total,
+			CappedBy:      discount.CappedBy,
 		})
+		if coupon.DiscountType == "tiered" {
+			setTierProgress(&applicableCoupons[len(applicableCoupons)-1], &coupon, req.OrderTotal)
+		}
+	}
+	if req.Sort == models.ApplicableCouponsSortSavings {
+		rankBySavings(applicableCoupons)
+	}
+
+	var nearMisses []models.NearMissCoupon
+	listedNearMisses := make([]models.Coupon, 0, len(nearMissCoupons))
+	for _, coupon := range nearMissCoupons {
+		// Leave out coupons that would still fail once the order reaches the minimum
+		unlocked := *input
+		unlocked.OrderTotal = coupon.MinOrderValue
+		failures, err := s.runValidators(ctx, &coupon, &unlocked, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(failures) > 0 {
+			continue
+		}
+		nearMisses = append(nearMisses, newNearMissCoupon(&coupon, req.OrderTotal))
+		listedNearMisses = append(listedNearMisses, coupon)
 	}
 
-	response := &models.ApplicableCouponsResponse{ApplicableCoupons: applicableCoupons}
-	s.applicableCouponsCache.Set(cacheKey, response)
+	response := &models.ApplicableCouponsResponse{
+		ApplicableCoupons: applicableCoupons,
+		Best:              findBestCoupon(applicable, discounts, cartItems, req.OrderTotal),
+		NearMisses:        nearMisses,
+	}
+	s.applicableCouponsCache.SetTagged(cacheVersion, cacheKey, response, applicableCouponsTags(userID, usedUp, applicable, listedNearMisses)...)
 	return response, nil
 }
 
-func calculateDiscount(coupon *models.Coupon, cartItems []models.CartItem, orderTotal float64) float64 {
-	discountFor := []string{}
-
-	for _, item := range cartItems {
-		if slices.Contains(coupon.MedicineIDs, models.Medicine{ID: item.ID}) {
-			discountFor = append(discountFor, "medicine")
-		}
-		if slices.Contains(coupon.Categories, models.Category{ID: item.Category}) {
-			discountFor = append(discountFor, "category")
-		}
+// calculateDiscount picks the discount that applies to the cart: the reward units for buy-X-get-Y coupons, the
+// reached tier's discount for tiered coupons, a discount on the whole order for general coupons, or the larger
+// of the medicine and category discounts for targeted coupons. Targeted coupons only ever discount matching lines.
+func calculateDiscount(coupon *models.Coupon, cartItems []models.CartItem, orderTotal money.Money) DiscountResult {
+	switch coupon.DiscountType {
+	case "buy_x_get_y":
+		return NewBuyXGetYDiscount(coupon, cartItems).CalculateDiscount()
+	case "tiered":
+		return NewTieredDiscount(coupon, cartItems, orderTotal).CalculateDiscount()
 	}
 
-	if len(discountFor) == 0 {
-		generalDiscount := NewGeneralDiscount(coupon, orderTotal)
+	if len(coupon.MedicineIDs) == 0 && len(coupon.Categories) == 0 {
+		generalDiscount := NewGeneralDiscount(coupon, cartItems, orderTotal)
 		return generalDiscount.CalculateDiscount()
 	}
 
 	discountCalculator := []Discount{}
 
-	if slices.Contains(discountFor, "medicine") {
+	if len(coupon.MedicineIDs) > 0 {
 		medicineDiscount := NewMedicineDiscount(coupon, cartItems)
 		discountCalculator = append(discountCalculator, medicineDiscount)
 	}
 
-	if slices.Contains(discountFor, "category") {
+	if len(coupon.Categories) > 0 {
 		categoryDiscount := NewCategoryDiscount(coupon, cartItems)
 		discountCalculator = append(discountCalculator, categoryDiscount)
 	}
 
-	var maxDiscount float64
+	var maxDiscount DiscountResult
 
 	for _, discount := range discountCalculator {
-		maxDiscount = math.Max(maxDiscount, discount.CalculateDiscount())
-	}
-
-	return math.Max(maxDiscount, 0)
-}
-
-func getMedicineIDsFromCart(cartItems []models.CartItem) []string {
-	medicineIDs := make([]string, 0, len(cartItems))
-	for _, item := range cartItems {
-		medicineIDs = append(medicineIDs, item.ID)
-	}
-	return medicineIDs
-}
-
-func getCategoryIDsFromCart(cartItems []models.CartItem) []string {
-	categoryIDs := make([]string, 0, len(cartItems))
-	for _, item := range cartItems {
-		categoryIDs = append(categoryIDs, item.Category)
+		if result := discount.CalculateDiscount(); maxDiscount.Total.LessThan(result.Total) {
+			maxDiscount = result
+		}
 	}
-	return categoryIDs
-}
-
-func generateApplicableCouponsCacheKey(userID string, req *models.ApplicableCouponsRequest) string {
-	// Use a combination of userID and the request parameters to create a unique key.
-	// Be mindful of the order of items in slices for consistent key generation.
-	// Marshalling the request can create a consistent string representation.
-	reqBytes, _ := json.Marshal(req)
-	data := userID + string(reqBytes)
 
-	hash := sha256.Sum256([]byte(data))
-	return fmt.Sprintf("%x", hash)
+	return maxDiscount
 }
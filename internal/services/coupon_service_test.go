@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"coupon-system/internal/caching"
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"coupon-system/internal/storage/database"
+)
+
+// newTestService returns a service over an empty in-memory store.
+func newTestService(t *testing.T) *CouponService {
+	t.Helper()
+	cache := caching.NewLRUCache[string, *models.ApplicableCouponsResponse](100, time.Minute)
+	return NewCouponService(database.NewMemoryStore(), cache, time.Minute, time.UTC)
+}
+
+func inr(amount string) money.Money {
+	return money.MustParse(amount, money.DefaultCurrency)
+}
+
+func TestInvalidCartsAreRejected(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t)
+	err := service.CreateCoupon(ctx, &models.CreateCouponRequest{
+		CouponCode:    "BOGO",
+		ExpiryDate:    time.Now().Add(time.Hour),
+		UsageType:     models.UsageTypeMultiUse,
+		DiscountType:  "buy_x_get_y",
+		DiscountValue: "100",
+		BuyQuantity:   1,
+		GetQuantity:   1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create coupon: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		cart       []models.CartItem
+		orderTotal money.Money
+	}{
+		{"negative price", []models.CartItem{{ID: "med1", Price: inr("-10.00"), Quantity: 2}}, inr("0.00")},
+		{"negative quantity", []models.CartItem{{ID: "med1", Price: inr("10.00"), Quantity: -2}}, inr("0.00")},
+		{"negative order total", []models.CartItem{{ID: "med1", Price: inr("10.00"), Quantity: 2}}, inr("-20.00")},
+		{"order total below the lines", []models.CartItem{{ID: "med1", Price: inr("10.00"), Quantity: 2}}, inr("19.99")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validate := &models.ValidateCouponRequest{CouponCode: "BOGO", CartItems: tt.cart, OrderTotal: tt.orderTotal, Timestamp: time.Now()}
+			if _, err := service.ValidateCoupon(ctx, "u1", validate); !errors.Is(err, ErrInvalidCart) {
+				t.Errorf("ValidateCoupon returned %v, want ErrInvalidCart", err)
+			}
+			hold := &models.CreateRedemptionRequest{OrderID: "o1", ValidateCouponRequest: *validate}
+			if _, err := service.CreateRedemption(ctx, "u1", hold); !errors.Is(err, ErrInvalidCart) {
+				t.Errorf("CreateRedemption returned %v, want ErrInvalidCart", err)
+			}
+			stack := &models.ValidateCouponStackRequest{CouponCodes: []string{"BOGO"}, CartItems: tt.cart, OrderTotal: tt.orderTotal, Timestamp: time.Now()}
+			if _, err := service.ValidateCouponStack(ctx, "u1", stack); !errors.Is(err, ErrInvalidCart) {
+				t.Errorf("ValidateCouponStack returned %v, want ErrInvalidCart", err)
+			}
+			applicable := &models.ApplicableCouponsRequest{CartItems: tt.cart, OrderTotal: tt.orderTotal, Timestamp: time.Now()}
+			if _, err := service.GetApplicableCoupons(ctx, "u1", applicable); !errors.Is(err, ErrInvalidCart) {
+				t.Errorf("GetApplicableCoupons returned %v, want ErrInvalidCart", err)
+			}
+		})
+	}
+}
+
+// codeValidator rejects the coupons with the given codes.
+type codeValidator []string
+
+func (v codeValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if slices.Contains(v, coupon.CouponCode) {
+		return newValidationError("BLOCKED", nil, "coupon is blocked")
+	}
+	return nil
+}
+
+// Validators registered on the service decide which coupons are applicable and which are near misses, as they
+// decide which coupons are valid.
+func TestApplicableCouponsRunRegisteredValidators(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t)
+	for _, coupon := range []struct {
+		code          string
+		minOrderValue string
+	}{{"OPEN", "0.00"}, {"BLOCKED", "0.00"}, {"OPEN500", "500.00"}, {"BLOCKED500", "500.00"}} {
+		err := service.CreateCoupon(ctx, &models.CreateCouponRequest{
+			CouponCode:    coupon.code,
+			ExpiryDate:    time.Now().Add(time.Hour),
+			UsageType:     models.UsageTypeMultiUse,
+			DiscountType:  "fixed_amount",
+			DiscountValue: "5.00",
+			MinOrderValue: inr(coupon.minOrderValue),
+		})
+		if err != nil {
+			t.Fatalf("failed to create coupon %s: %v", coupon.code, err)
+		}
+	}
+	service.Validators().Register(codeValidator{"BLOCKED", "BLOCKED500"})
+
+	response, err := service.GetApplicableCoupons(ctx, "u1", &models.ApplicableCouponsRequest{
+		CartItems:  []models.CartItem{{ID: "med1", Price: inr("100.00"), Quantity: 1}},
+		OrderTotal: inr("100.00"),
+		Timestamp:  time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("GetApplicableCoupons failed: %v", err)
+	}
+	var applicable, nearMisses []string
+	for _, coupon := range response.ApplicableCoupons {
+		applicable = append(applicable, coupon.CouponCode)
+	}
+	for _, coupon := range response.NearMisses {
+		nearMisses = append(nearMisses, coupon.CouponCode)
+	}
+	if !slices.Equal(applicable, []string{"OPEN"}) {
+		t.Errorf("applicable coupons are %v, want [OPEN]", applicable)
+	}
+	if !slices.Equal(nearMisses, []string{"OPEN500"}) {
+		t.Errorf("near misses are %v, want [OPEN500]", nearMisses)
+	}
+}
+
+// Committing a hold that has expired fails, and the use it held is given back: a coupon it used up is
+// applicable again to other users, including to those whose applicable coupons were cached meanwhile.
+func TestCommittingAnExpiredHoldGivesItsUseBack(t *testing.T) {
+	ctx := context.Background()
+	cache := caching.NewLRUCache[string, *models.ApplicableCouponsResponse](100, time.Minute)
+	service := NewCouponService(database.NewMemoryStore(), cache, 10*time.Millisecond, time.UTC)
+	err := service.CreateCoupon(ctx, &models.CreateCouponRequest{
+		CouponCode:    "LAST",
+		ExpiryDate:    time.Now().Add(time.Hour),
+		UsageType:     models.UsageTypeMultiUse,
+		DiscountType:  "fixed_amount",
+		DiscountValue: "5.00",
+		MaxTotalUsage: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create coupon: %v", err)
+	}
+
+	cart := []models.CartItem{{ID: "med1", Price: inr("100.00"), Quantity: 1}}
+	applicable := &models.ApplicableCouponsRequest{CartItems: cart, OrderTotal: inr("100.00"), Timestamp: time.Now()}
+	applicableCodes := func(userID string) []string {
+		t.Helper()
+		response, err := service.GetApplicableCoupons(ctx, userID, applicable)
+		if err != nil {
+			t.Fatalf("GetApplicableCoupons failed: %v", err)
+		}
+		var codes []string
+		for _, coupon := range response.ApplicableCoupons {
+			codes = append(codes, coupon.CouponCode)
+		}
+		return codes
+	}
+
+	hold, err := service.CreateRedemption(ctx, "u1", &models.CreateRedemptionRequest{
+		OrderID:               "o1",
+		ValidateCouponRequest: models.ValidateCouponRequest{CouponCode: "LAST", CartItems: cart, OrderTotal: inr("100.00")},
+	})
+	if err != nil {
+		t.Fatalf("failed to hold coupon: %v", err)
+	}
+	if codes := applicableCodes("u2"); len(codes) != 0 {
+		t.Fatalf("applicable coupons while the coupon is held are %v, want none", codes)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := service.CommitRedemption(ctx, "u1", hold.RedemptionID); !errors.Is(err, database.ErrRedemptionNotHeld) {
+		t.Fatalf("committing an expired hold returned %v, want ErrRedemptionNotHeld", err)
+	}
+	if codes := applicableCodes("u2"); !slices.Equal(codes, []string{"LAST"}) {
+		t.Errorf("applicable coupons after the hold expired are %v, want [LAST]", codes)
+	}
+}
+
+// A retried hold for an order gets the hold already placed as it was placed, whatever cart the retry sends,
+// and takes no other use: the user's cached applicable coupons are kept.
+func TestRetriedHoldsReturnTheHoldAsPlaced(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t)
+	err := service.CreateCoupon(ctx, &models.CreateCouponRequest{
+		CouponCode:    "TENPERCENT",
+		ExpiryDate:    time.Now().Add(time.Hour),
+		UsageType:     models.UsageTypeMultiUse,
+		DiscountType:  "percentage",
+		DiscountValue: "10",
+	})
+	if err != nil {
+		t.Fatalf("failed to create coupon: %v", err)
+	}
+
+	hold := func(cart []models.CartItem, orderTotal string) *models.RedemptionResponse {
+		t.Helper()
+		response, err := service.CreateRedemption(ctx, "u1", &models.CreateRedemptionRequest{
+			OrderID:               "o1",
+			ValidateCouponRequest: models.ValidateCouponRequest{CouponCode: "TENPERCENT", CartItems: cart, OrderTotal: inr(orderTotal)},
+		})
+		if err != nil {
+			t.Fatalf("failed to hold coupon: %v", err)
+		}
+		return response
+	}
+	first := hold([]models.CartItem{{ID: "med1", Price: inr("100.00"), Quantity: 1}}, "100.00")
+
+	applicable := &models.ApplicableCouponsRequest{CartItems: []models.CartItem{{ID: "med1", Price: inr("100.00"), Quantity: 1}}, OrderTotal: inr("100.00"), Timestamp: time.Now()}
+	if _, err := service.GetApplicableCoupons(ctx, "u1", applicable); err != nil {
+		t.Fatalf("GetApplicableCoupons failed: %v", err)
+	}
+
+	retry := hold([]models.CartItem{{ID: "med1", Price: inr("50.00"), Quantity: 2}, {ID: "med2", Price: inr("100.00"), Quantity: 1}}, "200.00")
+	switch {
+	case retry.RedemptionID != first.RedemptionID:
+		t.Errorf("the retry placed hold %s, want the first hold %s", retry.RedemptionID, first.RedemptionID)
+	case retry.Discount.TotalDiscount.Cmp(inr("10.00")) != 0:
+		t.Errorf("the retry's discount is %s, want the first hold's 10.00", retry.Discount.TotalDiscount)
+	case len(retry.Discount.Lines) != 0 || retry.Discount.CappedBy != "":
+		t.Errorf("the retry's discount is split as %+v, capped by %q, worked out for its own cart", retry.Discount.Lines, retry.Discount.CappedBy)
+	}
+	if coupon, err := service.storage.GetCouponByCode(ctx, "TENPERCENT"); err != nil || coupon == nil || coupon.CurrentTotalUsage != 1 {
+		t.Errorf("GetCouponByCode returned %+v, %v; want a coupon used once", coupon, err)
+	}
+
+	_, missesBefore, _ := service.applicableCouponsStats.Snapshot()
+	if _, err := service.GetApplicableCoupons(ctx, "u1", applicable); err != nil {
+		t.Fatalf("GetApplicableCoupons failed: %v", err)
+	}
+	if _, missesAfter, _ := service.applicableCouponsStats.Snapshot(); missesAfter != missesBefore {
+		t.Errorf("the retry dropped the user's cached applicable coupons")
+	}
+}
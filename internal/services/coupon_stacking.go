@@ -0,0 +1,298 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// stackedDiscount is a coupon that made it into a stack and the discount it gives.
+type stackedDiscount struct {
+	coupon *models.Coupon
+	result DiscountResult
+}
+
+// ValidateCouponStack validates several coupons used together against a cart. Like ValidateCoupon it has
+// no side effects; use CreateStackRedemption to hold the applied coupons for an order.
+func (s *CouponService) ValidateCouponStack(ctx context.Context, userID string, req *models.ValidateCouponStackRequest) (*models.ValidateCouponStackResponse, error) {
+	applied, rejected, err := s.evaluateStack(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.ValidateCouponStackResponse{
+		IsValid:         len(applied) > 0,
+		AppliedCoupons:  make([]models.AppliedCoupon, 0, len(applied)),
+		RejectedCoupons: rejected,
+	}
+	for _, stacked := range applied {
+		response.AppliedCoupons = append(response.AppliedCoupons, models.AppliedCoupon{
+			CouponCode: stacked.coupon.CouponCode,
+			Priority:   stacked.coupon.Priority,
+			Discount:   stacked.result.Total,
+			CappedBy:   stacked.result.CappedBy,
+			Lines:      stacked.result.Lines,
+		})
+	}
+	if len(applied) > 0 {
+		response.Discount = combineStackedDiscounts(applied)
+	}
+	return response, nil
+}
+
+// CreateStackRedemption holds every coupon of a stack that applies to the cart for the order. Either all of
+// them are held or, if a usage limit is reached in the meantime, none are.
+func (s *CouponService) CreateStackRedemption(ctx context.Context, userID string, req *models.CreateStackRedemptionRequest) (*models.StackRedemptionResponse, error) {
+	// Like single holds, stacks are checked against the server's clock rather than the request's timestamp
+	stack := req.ValidateCouponStackRequest
+	stack.Timestamp = time.Now()
+	applied, rejected, err := s.evaluateStack(ctx, userID, &stack)
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) == 0 {
+		reasons := make([]string, 0, len(rejected))
+		for _, r := range rejected {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", r.CouponCode, r.Message))
+		}
+		return nil, fmt.Errorf("%w: %s", ErrCouponNotApplicable, strings.Join(reasons, "; "))
+	}
+
+	now := time.Now()
+	redemptions := make([]*models.CouponRedemption, 0, len(applied))
+	for _, stacked := range applied {
+		redemptions = append(redemptions, &models.CouponRedemption{
+			ID:            uuid.New().String(),
+			CouponID:      stacked.coupon.ID,
+			CouponCode:    stacked.coupon.CouponCode,
+			UserID:        userID,
+			OrderID:       req.OrderID,
+			Status:        models.RedemptionStatusHeld,
+			TotalDiscount: stacked.result.Total,
+			ExpiresAt:     now.Add(s.redemptionHoldTTL),
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+	}
+
+	holdIDs := make([]string, 0, len(redemptions))
+	for _, redemption := range redemptions {
+		holdIDs = append(holdIDs, redemption.ID)
+	}
+	if err := s.storage.CreateRedemptions(ctx, redemptions); err != nil {
+		return nil, fmt.Errorf("error holding coupons: %w", err)
+	}
+
+	// Holds a retry for the order finds already placed are returned as they were placed, without taking a use
+	response := &models.StackRedemptionResponse{
+		Redemptions:     make([]models.RedemptionResponse, 0, len(redemptions)),
+		RejectedCoupons: rejected,
+	}
+	heldCoupons := make([]*models.Coupon, 0, len(applied))
+	var retried bool
+	var total money.Money
+	for i, redemption := range redemptions {
+		redemptionResponse := newRedemptionResponse(redemption)
+		if redemption.ID == holdIDs[i] {
+			heldCoupons = append(heldCoupons, applied[i].coupon)
+			redemptionResponse.Discount.CappedBy = applied[i].result.CappedBy
+			redemptionResponse.Discount.Lines = applied[i].result.Lines
+		} else {
+			retried = true
+		}
+		total = total.Add(redemption.TotalDiscount)
+		response.Redemptions = append(response.Redemptions, *redemptionResponse)
+	}
+	if len(heldCoupons) > 0 {
+		s.usageTaken(userID, heldCoupons...)
+	}
+
+	// Held discounts worked out for another cart cannot be split across this one's lines
+	response.Discount = combineStackedDiscounts(applied)
+	if retried {
+		response.Discount = &models.DiscountDetails{ItemsDiscount: total, TotalDiscount: total}
+	}
+	return response, nil
+}
+
+// evaluateStack validates every code of the stack against the cart and then applies the valid coupons with applyStack.
+func (s *CouponService) evaluateStack(ctx context.Context, userID string, req *models.ValidateCouponStackRequest) ([]stackedDiscount, []models.RejectedCoupon, error) {
+	if err := validateCart(req.CartItems, req.OrderTotal); err != nil {
+		return nil, nil, err
+	}
+	exclusions, err := s.getExclusions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	input := &ValidationInput{
+		UserID:     userID,
+		CartItems:  req.CartItems,
+		OrderTotal: req.OrderTotal,
+		Timestamp:  req.Timestamp,
+		Channel:    req.Channel,
+	}
+	rejected := []models.RejectedCoupon{}
+	var candidates []*models.Coupon
+	seen := make(map[string]bool, len(req.CouponCodes))
+
+	for _, code := range req.CouponCodes {
+		code = strings.TrimSpace(code)
+		if seen[code] {
+			rejected = append(rejected, models.RejectedCoupon{CouponCode: code, Reason: models.StackRejectionDuplicate, Message: "coupon code given more than once"})
+			continue
+		}
+		seen[code] = true
+
+		coupon, err := s.getCoupon(ctx, code)
+		if err != nil {
+			if errors.Is(err, ErrCouponNotFound) {
+				rejected = append(rejected, models.RejectedCoupon{CouponCode: code, Reason: models.StackRejectionNotFound, Message: "Coupon not found"})
+				continue
+			}
+			return nil, nil, err
+		}
+
+		coupon = withExclusions(coupon, exclusions)
+
+		failures, err := s.runValidators(ctx, coupon, input, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(failures) > 0 {
+			rejected = append(rejected, models.RejectedCoupon{CouponCode: code, Reason: models.StackRejectionNotApplicable, Message: failures[0].Message, Failure: &failures[0]})
+			continue
+		}
+		candidates = append(candidates, coupon)
+	}
+
+	applied, conflicts := applyStack(candidates, req.CartItems, req.OrderTotal)
+	return applied, append(rejected, conflicts...), nil
+}
+
+// applyStack applies coupons from the highest priority down, ties broken by coupon code. Each coupon is
+// discounted from what the coupons before it left of the order. A coupon is left out when it conflicts with
+// one that was already applied: when either of them is not stackable, or both are in the same exclusivity
+// group. It is also left out when nothing is left for it to discount.
+func applyStack(coupons []*models.Coupon, cartItems []models.CartItem, orderTotal money.Money) ([]stackedDiscount, []models.RejectedCoupon) {
+	candidates := slices.Clone(coupons)
+	slices.SortStableFunc(candidates, func(a, b *models.Coupon) int {
+		if a.Priority != b.Priority {
+			return cmp.Compare(b.Priority, a.Priority)
+		}
+		return strings.Compare(a.CouponCode, b.CouponCode)
+	})
+
+	remainingLines := make([]money.Money, len(cartItems))
+	for i, item := range cartItems {
+		remainingLines[i] = lineTotal(item)
+	}
+	remainingTotal := orderTotal
+
+	var applied []stackedDiscount
+	var rejected []models.RejectedCoupon
+	for _, coupon := range candidates {
+		if conflict := findStackConflict(coupon, applied); conflict != nil {
+			rejected = append(rejected, *conflict)
+			continue
+		}
+
+		result := calculateDiscount(coupon, remainingCart(cartItems, remainingLines), remainingTotal)
+		if !result.Total.IsPositive() {
+			rejected = append(rejected, models.RejectedCoupon{
+				CouponCode: coupon.CouponCode,
+				Reason:     models.StackRejectionNotApplicable,
+				Message:    "no discount left to give after the coupons applied before it",
+			})
+			continue
+		}
+
+		// Report the lines as they are in the cart rather than what was left of them
+		for i := range result.Lines {
+			item := cartItems[result.Lines[i].LineIndex]
+			result.Lines[i].Quantity = lineQuantity(item)
+			result.Lines[i].LineTotal = lineTotal(item)
+			remainingLines[result.Lines[i].LineIndex] = remainingLines[result.Lines[i].LineIndex].Sub(result.Lines[i].Discount)
+		}
+		remainingTotal = remainingTotal.Sub(result.Total)
+		applied = append(applied, stackedDiscount{coupon: coupon, result: result})
+	}
+
+	return applied, rejected
+}
+
+// findStackConflict returns why a coupon cannot join the coupons already applied, or nil if it can.
+func findStackConflict(coupon *models.Coupon, applied []stackedDiscount) *models.RejectedCoupon {
+	for _, stacked := range applied {
+		other := stacked.coupon
+		var message string
+		switch {
+		case !coupon.Stackable:
+			message = fmt.Sprintf("%s cannot be combined with other coupons", coupon.CouponCode)
+		case !other.Stackable:
+			message = fmt.Sprintf("%s cannot be combined with other coupons", other.CouponCode)
+		case coupon.ExclusivityGroup != "" && coupon.ExclusivityGroup == other.ExclusivityGroup:
+			message = fmt.Sprintf("%s and %s are both in exclusivity group %q", coupon.CouponCode, other.CouponCode, coupon.ExclusivityGroup)
+		default:
+			continue
+		}
+
+		return &models.RejectedCoupon{
+			CouponCode:    coupon.CouponCode,
+			Reason:        models.StackRejectionConflict,
+			ConflictsWith: other.CouponCode,
+			Message:       message,
+		}
+	}
+	return nil
+}
+
+// remainingCart returns the cart as it is left after earlier discounts. A discounted line becomes a single unit
+// priced at what is left of it, while untouched lines keep their units so buy-X-get-Y coupons can count them.
+func remainingCart(cartItems []models.CartItem, remainingLines []money.Money) []models.CartItem {
+	remaining := make([]models.CartItem, len(cartItems))
+	for i, item := range cartItems {
+		remaining[i] = item
+		if remainingLines[i].Cmp(lineTotal(item)) != 0 {
+			remaining[i] = models.CartItem{ID: item.ID, Category: item.Category, Price: remainingLines[i], Quantity: 1}
+		}
+	}
+	return remaining
+}
+
+// combineStackedDiscounts adds up the discounts of a stack, line by line.
+func combineStackedDiscounts(applied []stackedDiscount) *models.DiscountDetails {
+	var total money.Money
+	lines := make(map[int]models.LineDiscount)
+	for _, stacked := range applied {
+		total = total.Add(stacked.result.Total)
+		for _, line := range stacked.result.Lines {
+			if combined, ok := lines[line.LineIndex]; ok {
+				line.Discount = combined.Discount.Add(line.Discount)
+			}
+			lines[line.LineIndex] = line
+		}
+	}
+
+	combinedLines := make([]models.LineDiscount, 0, len(lines))
+	for _, line := range lines {
+		combinedLines = append(combinedLines, line)
+	}
+	slices.SortFunc(combinedLines, func(a, b models.LineDiscount) int {
+		return cmp.Compare(a.LineIndex, b.LineIndex)
+	})
+
+	return &models.DiscountDetails{
+		ItemsDiscount: total,
+		TotalDiscount: total,
+		Lines:         combinedLines,
+	}
+}
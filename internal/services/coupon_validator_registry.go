@@ -0,0 +1,71 @@
+package services
+
+import (
+	"coupon-system/internal/models"
+	"coupon-system/internal/storage/database"
+	"time"
+)
+
+// ValidatorRegistry composes the validators each coupon runs. Validators registered for every coupon run
+// first, in the order they were registered, followed by those registered for the coupon's usage type and then
+// those registered for the coupon itself. Validators must be registered before the registry is used to
+// validate coupons.
+type ValidatorRegistry struct {
+	common      []CouponValidator
+	byUsageType map[string][]CouponValidator
+	byCoupon    map[string][]CouponValidator
+}
+
+// NewValidatorRegistry creates an empty ValidatorRegistry.
+func NewValidatorRegistry() *ValidatorRegistry {
+	return &ValidatorRegistry{
+		byUsageType: make(map[string][]CouponValidator),
+		byCoupon:    make(map[string][]CouponValidator),
+	}
+}
+
+// NewDefaultValidatorRegistry creates a ValidatorRegistry with the validators every coupon runs and those that
+// enforce the usage types. Recurring windows are checked in the given time zone.
+func NewDefaultValidatorRegistry(storage database.CouponStorage, timeZone *time.Location) *ValidatorRegistry {
+	registry := NewValidatorRegistry()
+	registry.Register(
+		NewActiveValidator(),
+		NewExpiryDateValidator(),
+		NewValidTimeWindowValidator(),
+		NewRecurringWindowValidator(timeZone),
+		NewMinOrderValueValidator(),
+		NewApplicableItemsValidator(),
+		NewApplicableCategoriesValidator(),
+		NewExclusionValidator(),
+		NewBuyXGetYValidator(),
+		NewEligibilityRuleValidator(),
+		NewMaxUsagePerUserValidator(storage),
+		NewMaxTotalUsageValidator(),
+	)
+	registry.RegisterForUsageType(models.UsageTypeOneTime, NewSingleRedemptionValidator())
+	registry.RegisterForUsageType(models.UsageTypeTimeBased, NewTimeWindowRequiredValidator())
+	return registry
+}
+
+// Register adds validators that every coupon runs.
+func (r *ValidatorRegistry) Register(validators ...CouponValidator) {
+	r.common = append(r.common, validators...)
+}
+
+// RegisterForUsageType adds validators that coupons of a usage type run, e.g. "one_time".
+func (r *ValidatorRegistry) RegisterForUsageType(usageType string, validators ...CouponValidator) {
+	r.byUsageType[usageType] = append(r.byUsageType[usageType], validators...)
+}
+
+// RegisterForCoupon adds validators that only the coupon with the given ID runs.
+func (r *ValidatorRegistry) RegisterForCoupon(couponID string, validators ...CouponValidator) {
+	r.byCoupon[couponID] = append(r.byCoupon[couponID], validators...)
+}
+
+// ValidatorsFor returns the validators a coupon runs, in order.
+func (r *ValidatorRegistry) ValidatorsFor(coupon *models.Coupon) []CouponValidator {
+	validators := make([]CouponValidator, 0, len(r.common)+len(r.byUsageType[coupon.UsageType])+len(r.byCoupon[coupon.ID]))
+	validators = append(validators, r.common...)
+	validators = append(validators, r.byUsageType[coupon.UsageType]...)
+	return append(validators, r.byCoupon[coupon.ID]...)
+}
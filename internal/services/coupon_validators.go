@@ -3,13 +3,68 @@ package services
 import (
 	"context"
 	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"coupon-system/internal/rules"
 	"coupon-system/internal/storage/database"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 )
 
-// CouponValidator defines the interface for coupon validation rules.
+// ValidationInput is what a coupon is validated against: the user, their cart and the request.
+type ValidationInput struct {
+	UserID     string
+	CartItems  []models.CartItem
+	OrderTotal money.Money
+	Timestamp  time.Time
+	Channel    string
+	UserUsages map[string]int // Times the user has used coupons, by ID, when already looked up
+}
+
+// newValidationInput takes the validation input from a validation request.
+func newValidationInput(userID string, req *models.ValidateCouponRequest) *ValidationInput {
+	return &ValidationInput{
+		UserID:     userID,
+		CartItems:  req.CartItems,
+		OrderTotal: req.OrderTotal,
+		Timestamp:  req.Timestamp,
+		Channel:    req.Channel,
+	}
+}
+
+// CouponValidator defines the interface for coupon validation rules. A coupon that fails a rule is reported
+// with a *ValidationError; any other error means the rule could not be checked. The context is the request's,
+// so validators that look data up stop when the request is cancelled.
 type CouponValidator interface {
-	Validate(coupon *models.Coupon, req *models.ValidateCouponRequest) error
+	Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error
+}
+
+// ValidationError is a validation rule a coupon fails, with a stable reason code.
+type ValidationError struct {
+	models.ValidationFailure
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func newValidationError(code string, params map[string]any, message string) *ValidationError {
+	return &ValidationError{models.ValidationFailure{Code: code, Message: message, Params: params}}
+}
+
+// ActiveValidator validates if the coupon has not been deactivated.
+type ActiveValidator struct{}
+
+func NewActiveValidator() *ActiveValidator {
+	return &ActiveValidator{}
+}
+
+func (v *ActiveValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if !coupon.IsActive {
+		return newValidationError(models.ReasonInactive, nil, "coupon is inactive")
+	}
+	return nil
 }
 
 // ExpiryDateValidator validates if the coupon has expired.
@@ -18,9 +73,9 @@ type ExpiryDateValidator struct{}
 func NewExpiryDateValidator() *ExpiryDateValidator {
 	return &ExpiryDateValidator{}
 }
-func (v *ExpiryDateValidator) Validate(coupon *models.Coupon, req *models.ValidateCouponRequest) error {
-	if req.Timestamp.After(coupon.ExpiryDate) {
-		return fmt.Errorf("coupon has expired")
+func (v *ExpiryDateValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if input.Timestamp.After(coupon.ExpiryDate) {
+		return newValidationError(models.ReasonExpired, map[string]any{"expiry_date": coupon.ExpiryDate}, "coupon has expired")
 	}
 	return nil
 }
@@ -32,9 +87,10 @@ func NewMinOrderValueValidator() *MinOrderValueValidator {
 	return &MinOrderValueValidator{}
 }
 
-func (v *MinOrderValueValidator) Validate(coupon *models.Coupon, req *models.ValidateCouponRequest) error {
-	if req.OrderTotal < coupon.MinOrderValue {
-		return fmt.Errorf("minimum order value of %.2f required", coupon.MinOrderValue)
+func (v *MinOrderValueValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if input.OrderTotal.LessThan(coupon.MinOrderValue) {
+		params := map[string]any{"min_order_value": coupon.MinOrderValue, "amount_needed": coupon.MinOrderValue.Sub(input.OrderTotal)}
+		return newValidationError(models.ReasonMinOrderNotMet, params, fmt.Sprintf("minimum order value of %s required", coupon.MinOrderValue))
 	}
 	return nil
 }
@@ -46,62 +102,129 @@ func NewValidTimeWindowValidator() *ValidTimeWindowValidator {
 	return &ValidTimeWindowValidator{}
 }
 
-func (v *ValidTimeWindowValidator) Validate(coupon *models.Coupon, req *models.ValidateCouponRequest) error {
-	if coupon.ValidTimeWindowStart != nil && req.Timestamp.Before(*coupon.ValidTimeWindowStart) {
-		return fmt.Errorf("coupon is not yet valid")
+func (v *ValidTimeWindowValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if coupon.ValidTimeWindowStart != nil && input.Timestamp.Before(*coupon.ValidTimeWindowStart) {
+		return newValidationError(models.ReasonNotYetActive, map[string]any{"valid_from": *coupon.ValidTimeWindowStart}, "coupon is not yet valid")
 	}
-	if coupon.ValidTimeWindowEnd != nil && req.Timestamp.After(*coupon.ValidTimeWindowEnd) {
-		return fmt.Errorf("coupon is no longer valid")
+	if coupon.ValidTimeWindowEnd != nil && input.Timestamp.After(*coupon.ValidTimeWindowEnd) {
+		return newValidationError(models.ReasonNoLongerActive, map[string]any{"valid_until": *coupon.ValidTimeWindowEnd}, "coupon is no longer valid")
 	}
 	return nil
 }
 
-// ApplicableItemsValidator validates if the coupon is applicable to any items in the cart by medicine ID.
+// RecurringWindowValidator validates if the coupon is used within its recurring window, in local time.
+type RecurringWindowValidator struct {
+	location *time.Location
+}
+
+func NewRecurringWindowValidator(location *time.Location) *RecurringWindowValidator {
+	return &RecurringWindowValidator{location: location}
+}
+
+func (v *RecurringWindowValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if !inRecurrence(coupon.Recurrence, input.Timestamp.In(v.location)) {
+		params := map[string]any{"recurring_window": newRecurringWindow(coupon.Recurrence), "time_zone": v.location.String()}
+		return newValidationError(models.ReasonOutsideRecurringWindow, params, "coupon is not valid at this time of the week or month")
+	}
+	return nil
+}
+
+// ApplicableItemsValidator validates if the coupon is applicable to any items in the cart by medicine ID. A coupon
+// that targets categories as well also applies to items in those categories.
 type ApplicableItemsValidator struct{}
 
 func NewApplicableItemsValidator() *ApplicableItemsValidator {
 	return &ApplicableItemsValidator{}
 }
 
-func (v *ApplicableItemsValidator) Validate(coupon *models.Coupon, req *models.ValidateCouponRequest) error {
-	if len(coupon.MedicineIDs) > 0 {
-		found := false
-		for _, medicine := range coupon.MedicineIDs {
-			for _, item := range req.CartItems {
-				if medicine.ID == item.ID {
-					found = true
-					break
-				}
-			}
-		}
-		if !found {
-			return fmt.Errorf("coupon not applicable to any items in the cart")
-		}
+func (v *ApplicableItemsValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if len(coupon.MedicineIDs) > 0 && !slices.ContainsFunc(input.CartItems, func(item models.CartItem) bool { return isTargetedItem(coupon, item) }) {
+		return newValidationError(models.ReasonNoApplicableItems, nil, "coupon not applicable to any items in the cart")
 	}
 	return nil
 }
 
-// ApplicableCategoriesValidator validates if the coupon is applicable to any categories in the cart.
+// ApplicableCategoriesValidator validates if the coupon is applicable to any categories in the cart. Coupons that
+// also target medicines are left to ApplicableItemsValidator.
 type ApplicableCategoriesValidator struct{}
 
 func NewApplicableCategoriesValidator() *ApplicableCategoriesValidator {
 	return &ApplicableCategoriesValidator{}
 }
 
-func (v *ApplicableCategoriesValidator) Validate(coupon *models.Coupon, req *models.ValidateCouponRequest) error {
-	if len(coupon.Categories) > 0 {
-		found := false
-		for _, category := range coupon.Categories {
-			for _, item := range req.CartItems {
-				if category.ID == item.Category {
-					found = true
-					break
-				}
+func (v *ApplicableCategoriesValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if len(coupon.Categories) > 0 && len(coupon.MedicineIDs) == 0 && !slices.ContainsFunc(input.CartItems, func(item models.CartItem) bool { return isTargetedItem(coupon, item) }) {
+		return newValidationError(models.ReasonNoApplicableCategories, nil, "coupon not applicable to any categories in the cart")
+	}
+	return nil
+}
+
+// ExclusionValidator validates if the cart has at least one line the coupon targets that is not excluded from discounts.
+type ExclusionValidator struct{}
+
+func NewExclusionValidator() *ExclusionValidator {
+	return &ExclusionValidator{}
+}
+
+func (v *ExclusionValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	targeted := false
+	for _, item := range input.CartItems {
+		if isTargetedItem(coupon, item) {
+			if !isExcludedItem(coupon, item) {
+				return nil
 			}
+			targeted = true
 		}
-		if !found {
-			return fmt.Errorf("coupon not applicable to any categories in the cart")
+	}
+	// A cart without any line the coupon targets fails the applicable items and categories validators instead
+	if !targeted {
+		return nil
+	}
+	return newValidationError(models.ReasonItemsExcluded, nil, "items in the cart are excluded from discounts")
+}
+
+// BuyXGetYValidator validates if the cart has enough units for a buy-X-get-Y coupon to give its reward.
+type BuyXGetYValidator struct{}
+
+func NewBuyXGetYValidator() *BuyXGetYValidator {
+	return &BuyXGetYValidator{}
+}
+
+func (v *BuyXGetYValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if coupon.DiscountType == "buy_x_get_y" && len(NewBuyXGetYDiscount(coupon, input.CartItems).CalculateDiscount().Lines) == 0 {
+		params := map[string]any{"buy_quantity": coupon.BuyQuantity, "get_quantity": coupon.GetQuantity}
+		return newValidationError(models.ReasonNotEnoughUnits, params, fmt.Sprintf("buy %d, get %d requires more eligible units in the cart", coupon.BuyQuantity, coupon.GetQuantity))
+	}
+	return nil
+}
+
+// EligibilityRuleValidator validates if the cart and request meet the coupon's eligibility rule, if it has one.
+type EligibilityRuleValidator struct{}
+
+func NewEligibilityRuleValidator() *EligibilityRuleValidator {
+	return &EligibilityRuleValidator{}
+}
+
+func (v *EligibilityRuleValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if coupon.EligibilityRule == "" {
+		return nil
+	}
+	rule, err := compileRule(coupon.EligibilityRule)
+	if err != nil {
+		return newValidationError(models.ReasonRuleInvalid, nil, fmt.Sprintf("coupon has an invalid eligibility rule: %v", err))
+	}
+
+	env := rules.Env{OrderTotal: input.OrderTotal, Channel: input.Channel, UserID: input.UserID}
+	for _, item := range input.CartItems {
+		env.Lines = append(env.Lines, rules.Line{MedicineID: item.ID, Category: item.Category, Price: item.Price, Quantity: lineQuantity(item)})
+	}
+	if err := rule.Evaluate(env); err != nil {
+		var params map[string]any
+		var ruleErr *rules.Error
+		if errors.As(err, &ruleErr) {
+			params = map[string]any{"clause": ruleErr.Clause, "column": ruleErr.Column}
 		}
+		return newValidationError(models.ReasonRuleNotMet, params, fmt.Sprintf("eligibility rule: %v", err))
 	}
 	return nil
 }
@@ -109,21 +232,24 @@ func (v *ApplicableCategoriesValidator) Validate(coupon *models.Coupon, req *mod
 // MaxUsagePerUserValidator validates if the user has exceeded the maximum usage limit for this coupon.
 type MaxUsagePerUserValidator struct {
 	storage database.CouponStorage
-	userID  string
 }
 
-func NewMaxUsagePerUserValidator(storage database.CouponStorage, userID string) *MaxUsagePerUserValidator {
-	return &MaxUsagePerUserValidator{storage: storage, userID: userID}
+func NewMaxUsagePerUserValidator(storage database.CouponStorage) *MaxUsagePerUserValidator {
+	return &MaxUsagePerUserValidator{storage: storage}
 }
 
-func (v *MaxUsagePerUserValidator) Validate(coupon *models.Coupon, req *models.ValidateCouponRequest) error {
+func (v *MaxUsagePerUserValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
 	if coupon.MaxUsagePerUser > 0 {
-		userUsage, err := v.storage.GetUserUsageForCoupon(context.Background(), v.userID, coupon.ID)
-		if err != nil {
-			return fmt.Errorf("error checking user usage")
+		userUsage, ok := input.UserUsages[coupon.ID]
+		if !ok {
+			var err error
+			userUsage, err = v.storage.GetUserUsageForCoupon(ctx, input.UserID, coupon.ID)
+			if err != nil {
+				return fmt.Errorf("error checking user usage: %w", err)
+			}
 		}
 		if userUsage >= coupon.MaxUsagePerUser {
-			return fmt.Errorf("maximum usage per user exceeded")
+			return newValidationError(models.ReasonUserLimitReached, map[string]any{"max_usage_per_user": coupon.MaxUsagePerUser}, "maximum usage per user exceeded")
 		}
 	}
 	return nil
@@ -136,9 +262,38 @@ func NewMaxTotalUsageValidator() *MaxTotalUsageValidator {
 	return &MaxTotalUsageValidator{}
 }
 
-func (v *MaxTotalUsageValidator) Validate(coupon *models.Coupon, req *models.ValidateCouponRequest) error {
+func (v *MaxTotalUsageValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
 	if coupon.MaxTotalUsage > 0 && coupon.CurrentTotalUsage >= coupon.MaxTotalUsage {
-		return fmt.Errorf("maximum total usage exceeded")
+		return newValidationError(models.ReasonTotalLimitReached, map[string]any{"max_total_usage": coupon.MaxTotalUsage}, "maximum total usage exceeded")
+	}
+	return nil
+}
+
+// SingleRedemptionValidator validates if a "one_time" coupon has not been redeemed yet, by anyone.
+type SingleRedemptionValidator struct{}
+
+func NewSingleRedemptionValidator() *SingleRedemptionValidator {
+	return &SingleRedemptionValidator{}
+}
+
+func (v *SingleRedemptionValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if coupon.CurrentTotalUsage > 0 {
+		return newValidationError(models.ReasonAlreadyRedeemed, nil, "coupon has already been redeemed")
+	}
+	return nil
+}
+
+// TimeWindowRequiredValidator validates if a "time_based" coupon has a time window to be used in. Coupons
+// created before usage types were enforced may lack one.
+type TimeWindowRequiredValidator struct{}
+
+func NewTimeWindowRequiredValidator() *TimeWindowRequiredValidator {
+	return &TimeWindowRequiredValidator{}
+}
+
+func (v *TimeWindowRequiredValidator) Validate(ctx context.Context, coupon *models.Coupon, input *ValidationInput) error {
+	if !hasTimeWindow(coupon) {
+		return newValidationError(models.ReasonNoTimeWindow, nil, "time-based coupon has no time window")
 	}
 	return nil
 }
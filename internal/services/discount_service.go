@@ -1,12 +1,21 @@
 package services
 
 import (
+	"cmp"
 	"coupon-system/internal/models"
+	"coupon-system/internal/money"
 	"slices"
 )
 
 type Discount interface {
-	CalculateDiscount() float64
+	CalculateDiscount() DiscountResult
+}
+
+// DiscountResult is the discount a coupon gives a cart and how it is split across the cart lines.
+type DiscountResult struct {
+	Total    money.Money
+	Lines    []models.LineDiscount
+	CappedBy string // One of the models.DiscountCappedBy constants when a cap reduced the discount
 }
 
 type MedicineDiscount struct {
@@ -21,15 +30,12 @@ func NewMedicineDiscount(coupon *models.Coupon, cartItems []models.CartItem) *Me
 	}
 }
 
-func (md *MedicineDiscount) CalculateDiscount() float64 {
-	var discountApplicableMeds float64
-	for _, item := range md.cartItems {
-		if slices.Contains(md.coupon.MedicineIDs, models.Medicine{ID: item.ID}) {
-			discountApplicableMeds += item.Price
-		}
-	}
+func (md *MedicineDiscount) CalculateDiscount() DiscountResult {
+	eligible := eligibleLines(md.coupon, md.cartItems, func(item models.CartItem) bool {
+		return slices.Contains(md.coupon.MedicineIDs, models.Medicine{ID: item.ID})
+	})
 
-	return calculateDiscountValue(discountApplicableMeds, md.coupon.DiscountValue, md.coupon.DiscountType)
+	return discountEligibleLines(md.coupon, md.cartItems, eligible)
 }
 
 type CategoryDiscount struct {
@@ -44,38 +50,334 @@ func NewCategoryDiscount(coupon *models.Coupon, cartItems []models.CartItem) *Ca
 	}
 }
 
-func (cd *CategoryDiscount) CalculateDiscount() float64 {
-	var discountApplicableCategories float64
-	for _, item := range cd.cartItems {
-		if slices.Contains(cd.coupon.Categories, models.Category{ID: item.Category}) {
-			discountApplicableCategories += item.Price
-		}
-	}
+func (cd *CategoryDiscount) CalculateDiscount() DiscountResult {
+	eligible := eligibleLines(cd.coupon, cd.cartItems, func(item models.CartItem) bool {
+		return slices.Contains(cd.coupon.Categories, models.Category{ID: item.Category})
+	})
 
-	return calculateDiscountValue(discountApplicableCategories, cd.coupon.DiscountValue, cd.coupon.DiscountType)
+	return discountEligibleLines(cd.coupon, cd.cartItems, eligible)
 }
 
 type GeneralDiscount struct {
 	coupon      *models.Coupon
-	totalAmount float64
+	cartItems   []models.CartItem
+	totalAmount money.Money
 }
 
-func NewGeneralDiscount(coupon *models.Coupon, totalAmount float64) *GeneralDiscount {
+func NewGeneralDiscount(coupon *models.Coupon, cartItems []models.CartItem, totalAmount money.Money) *GeneralDiscount {
 	return &GeneralDiscount{
 		coupon:      coupon,
+		cartItems:   cartItems,
+		totalAmount: totalAmount,
+	}
+}
+
+func (gd *GeneralDiscount) CalculateDiscount() DiscountResult {
+	eligible := eligibleLines(gd.coupon, gd.cartItems, func(models.CartItem) bool { return true })
+	if len(gd.cartItems) > 0 && len(eligible) == 0 {
+		return DiscountResult{}
+	}
+
+	// The order total less the excluded lines is what the coupon can discount
+	amount := gd.totalAmount
+	for _, item := range gd.cartItems {
+		if isExcludedItem(gd.coupon, item) {
+			amount = amount.Sub(lineTotal(item))
+		}
+	}
+
+	// The discount never exceeds the eligible lines, whatever order total the client sends, so that it can be
+	// allocated to them; without lines, the order total is all there is
+	limit := amount
+	if len(gd.cartItems) > 0 {
+		limit = eligibleSubtotal(gd.cartItems, eligible)
+		// An order total below the lines contradicts the cart, and the eligible lines are still there to discount
+		amount = money.Max(amount, limit)
+	}
+	amount = money.Max(amount, money.New(0, amount.Currency))
+	total, cappedBy := capDiscount(gd.coupon, calculateDiscountValue(gd.coupon, amount), limit)
+	return DiscountResult{Total: total, Lines: allocateDiscount(total, gd.cartItems, eligible), CappedBy: cappedBy}
+}
+
+// BuyXGetYDiscount gives a percentage off reward units for every BuyQuantity trigger units in the cart,
+// e.g. "buy 2 strips, get 1 free". The cheapest reward units are discounted, and a unit counts either towards
+// a deal or as its reward, never both.
+type BuyXGetYDiscount struct {
+	coupon    *models.Coupon
+	cartItems []models.CartItem
+}
+
+func NewBuyXGetYDiscount(coupon *models.Coupon, cartItems []models.CartItem) *BuyXGetYDiscount {
+	return &BuyXGetYDiscount{
+		coupon:    coupon,
+		cartItems: cartItems,
+	}
+}
+
+func (bd *BuyXGetYDiscount) CalculateDiscount() DiscountResult {
+	buy, get := bd.coupon.BuyQuantity, bd.coupon.GetQuantity
+	if buy <= 0 || get <= 0 {
+		return DiscountResult{}
+	}
+
+	// Units that can only trigger a deal, only be a reward, or be either
+	var triggerOnly, rewardOnly, both int
+	var rewardLines []int
+	for i, item := range bd.cartItems {
+		trigger, reward := isTriggerItem(bd.coupon, item), isRewardItem(bd.coupon, item)
+		switch {
+		case trigger && reward:
+			both += lineQuantity(item)
+		case trigger:
+			triggerOnly += lineQuantity(item)
+		case reward:
+			rewardOnly += lineQuantity(item)
+		}
+		if reward {
+			rewardLines = append(rewardLines, i)
+		}
+	}
+
+	deals := min((triggerOnly+both)/buy, (rewardOnly+both)/get, (triggerOnly+rewardOnly+both)/(buy+get))
+	if deals == 0 {
+		return DiscountResult{}
+	}
+
+	// Reward the cheapest units, keeping back enough units that could be either to trigger every deal
+	slices.SortStableFunc(rewardLines, func(a, b int) int {
+		return bd.cartItems[a].Price.Cmp(bd.cartItems[b].Price)
+	})
+	rewardUnits := deals * get
+	eitherForRewards := both - max(0, deals*buy-triggerOnly)
+
+	var lines []models.LineDiscount
+	var rawShares []int64
+	var rawTotal, rewardedAmount money.Money
+	for _, i := range rewardLines {
+		if rewardUnits == 0 {
+			break
+		}
+		item := bd.cartItems[i]
+		units := min(lineQuantity(item), rewardUnits)
+		if isTriggerItem(bd.coupon, item) {
+			units = min(units, eitherForRewards)
+			eitherForRewards -= units
+		}
+		if units == 0 {
+			continue
+		}
+		rewardUnits -= units
+
+		share := bd.coupon.DiscountPercent.Of(item.Price).Mul(int64(units))
+		rawShares = append(rawShares, share.Amount)
+		rawTotal = rawTotal.Add(share)
+		rewardedAmount = rewardedAmount.Add(item.Price.Mul(int64(units)))
+		lines = append(lines, models.LineDiscount{
+			LineIndex:   i,
+			ItemID:      item.ID,
+			Category:    item.Category,
+			Quantity:    lineQuantity(item),
+			LineTotal:   lineTotal(item),
+			RewardUnits: units,
+		})
+	}
+
+	// A capped discount is spread over the rewarded lines in proportion to what each would have got
+	total, cappedBy := capDiscount(bd.coupon, rawTotal, rewardedAmount)
+	for n, share := range total.Allocate(rawShares) {
+		lines[n].Discount = share
+	}
+	slices.SortFunc(lines, func(a, b models.LineDiscount) int {
+		return cmp.Compare(a.LineIndex, b.LineIndex)
+	})
+
+	return DiscountResult{Total: total, Lines: lines, CappedBy: cappedBy}
+}
+
+// TieredDiscount gives the discount of the highest tier the order total reaches, applied like a percentage or
+// fixed amount coupon with the same targeting.
+type TieredDiscount struct {
+	coupon      *models.Coupon
+	cartItems   []models.CartItem
+	totalAmount money.Money
+}
+
+func NewTieredDiscount(coupon *models.Coupon, cartItems []models.CartItem, totalAmount money.Money) *TieredDiscount {
+	return &TieredDiscount{
+		coupon:      coupon,
+		cartItems:   cartItems,
 		totalAmount: totalAmount,
 	}
 }
 
-func (gd *GeneralDiscount) CalculateDiscount() float64 {
-	return calculateDiscountValue(gd.totalAmount, gd.coupon.DiscountValue, gd.coupon.DiscountType)
+func (td *TieredDiscount) CalculateDiscount() DiscountResult {
+	reached, _ := reachedTier(td.coupon, td.totalAmount)
+	if reached == nil {
+		return DiscountResult{}
+	}
+	return calculateDiscount(tierCoupon(td.coupon, reached), td.cartItems, td.totalAmount)
 }
 
-func calculateDiscountValue(amountForDiscount, discount float64, discountType string) float64 {
-	if discountType == "fixed_amount" {
-		return discount
-	} else if discountType == "percentage" {
-		return (amountForDiscount * (discount / 100))
+// reachedTier returns the highest tier an order total reaches and the tier above it. Either is nil when there
+// is no such tier.
+func reachedTier(coupon *models.Coupon, orderTotal money.Money) (*models.CouponTier, *models.CouponTier) {
+	var reached *models.CouponTier
+	for i := range coupon.Tiers {
+		if orderTotal.LessThan(coupon.Tiers[i].Threshold) {
+			return reached, &coupon.Tiers[i]
+		}
+		reached = &coupon.Tiers[i]
+	}
+	return reached, nil
+}
+
+// tierCoupon returns a copy of a tiered coupon that gives the tier's discount.
+func tierCoupon(coupon *models.Coupon, tier *models.CouponTier) *models.Coupon {
+	reward := *coupon
+	reward.DiscountType = tier.DiscountType
+	reward.DiscountAmount = tier.DiscountAmount
+	reward.DiscountPercent = tier.DiscountPercent
+	reward.Tiers = nil
+	return &reward
+}
+
+func calculateDiscountValue(coupon *models.Coupon, amountForDiscount money.Money) money.Money {
+	if coupon.DiscountType == "fixed_amount" {
+		return coupon.DiscountAmount
+	} else if coupon.DiscountType == "percentage" {
+		return coupon.DiscountPercent.Of(amountForDiscount)
+	}
+	return money.Money{}
+}
+
+// discountEligibleLines applies the coupon to the eligible cart lines. A cart without eligible lines gets no discount.
+func discountEligibleLines(coupon *models.Coupon, cartItems []models.CartItem, eligible []int) DiscountResult {
+	if len(eligible) == 0 {
+		return DiscountResult{}
+	}
+
+	subtotal := eligibleSubtotal(cartItems, eligible)
+	total, cappedBy := capDiscount(coupon, calculateDiscountValue(coupon, subtotal), subtotal)
+	return DiscountResult{Total: total, Lines: allocateDiscount(total, cartItems, eligible), CappedBy: cappedBy}
+}
+
+// capDiscount limits a discount to the amount it applies to, so an order is never discounted below zero, and
+// then to the coupon's maximum discount amount if it has one. It also reports which cap, if any, took effect.
+func capDiscount(coupon *models.Coupon, discount money.Money, eligibleAmount money.Money) (money.Money, string) {
+	cappedBy := ""
+	if eligibleAmount.IsNegative() {
+		eligibleAmount = money.New(0, eligibleAmount.Currency)
+	}
+	if eligibleAmount.LessThan(discount) {
+		discount = money.Min(discount, eligibleAmount)
+		cappedBy = models.DiscountCappedByEligibleAmount
+	}
+	if coupon.MaxDiscountAmount.IsPositive() && coupon.MaxDiscountAmount.LessThan(discount) {
+		discount = money.Min(discount, coupon.MaxDiscountAmount)
+		cappedBy = models.DiscountCappedByMaxDiscount
+	}
+	return discount, cappedBy
+}
+
+// isTriggerItem reports whether a cart item counts towards the units to buy of a buy-X-get-Y coupon. Every
+// item does when the coupon has no trigger set.
+func isTriggerItem(coupon *models.Coupon, item models.CartItem) bool {
+	if isExcludedItem(coupon, item) {
+		return false
+	}
+	if len(coupon.TriggerMedicines) == 0 && len(coupon.TriggerCategories) == 0 {
+		return true
+	}
+	return slices.Contains(coupon.TriggerMedicines, models.Medicine{ID: item.ID}) ||
+		slices.Contains(coupon.TriggerCategories, models.Category{ID: item.Category})
+}
+
+// isRewardItem reports whether a cart item can be given as the reward of a buy-X-get-Y coupon. Without a
+// reward set the reward comes from the trigger set.
+func isRewardItem(coupon *models.Coupon, item models.CartItem) bool {
+	if isExcludedItem(coupon, item) {
+		return false
+	}
+	if len(coupon.RewardMedicines) == 0 && len(coupon.RewardCategories) == 0 {
+		return isTriggerItem(coupon, item)
+	}
+	return slices.Contains(coupon.RewardMedicines, models.Medicine{ID: item.ID}) ||
+		slices.Contains(coupon.RewardCategories, models.Category{ID: item.Category})
+}
+
+// lineQuantity returns the number of units on a cart line. Lines without a quantity count as one unit.
+func lineQuantity(item models.CartItem) int {
+	if item.Quantity <= 0 {
+		return 1
+	}
+	return item.Quantity
+}
+
+// lineTotal returns the price of all units on a cart line.
+func lineTotal(item models.CartItem) money.Money {
+	return item.Price.Mul(int64(lineQuantity(item)))
+}
+
+// isExcludedItem reports whether the coupon must never discount a cart item.
+func isExcludedItem(coupon *models.Coupon, item models.CartItem) bool {
+	return slices.Contains(coupon.ExcludedMedicines, models.Medicine{ID: item.ID}) ||
+		slices.Contains(coupon.ExcludedCategories, models.Category{ID: item.Category})
+}
+
+// isTargetedItem reports whether a cart item is one the coupon is meant for: any item for general coupons,
+// or a matching medicine or category for targeted coupons.
+func isTargetedItem(coupon *models.Coupon, item models.CartItem) bool {
+	if len(coupon.MedicineIDs) == 0 && len(coupon.Categories) == 0 {
+		return true
+	}
+	return slices.Contains(coupon.MedicineIDs, models.Medicine{ID: item.ID}) ||
+		slices.Contains(coupon.Categories, models.Category{ID: item.Category})
+}
+
+// eligibleLines returns the indexes of the cart lines the discount applies to. Excluded lines never are.
+func eligibleLines(coupon *models.Coupon, cartItems []models.CartItem, isEligible func(models.CartItem) bool) []int {
+	var eligible []int
+	for i, item := range cartItems {
+		if isEligible(item) && !isExcludedItem(coupon, item) {
+			eligible = append(eligible, i)
+		}
+	}
+	return eligible
+}
+
+func eligibleSubtotal(cartItems []models.CartItem, eligible []int) money.Money {
+	var subtotal money.Money
+	for _, i := range eligible {
+		subtotal = subtotal.Add(lineTotal(cartItems[i]))
+	}
+	return subtotal
+}
+
+// allocateDiscount splits a discount across the eligible cart lines in proportion to their line totals.
+// The line discounts always add up to the total; see money.Money.Allocate for how leftover minor units
+// are handed out.
+func allocateDiscount(total money.Money, cartItems []models.CartItem, eligible []int) []models.LineDiscount {
+	if len(eligible) == 0 || !total.IsPositive() {
+		return nil
+	}
+
+	weights := make([]int64, 0, len(eligible))
+	for _, i := range eligible {
+		weights = append(weights, max(lineTotal(cartItems[i]).Amount, 0))
+	}
+
+	shares := total.Allocate(weights)
+	lines := make([]models.LineDiscount, 0, len(eligible))
+	for n, i := range eligible {
+		item := cartItems[i]
+		lines = append(lines, models.LineDiscount{
+			LineIndex: i,
+			ItemID:    item.ID,
+			Category:  item.Category,
+			Quantity:  lineQuantity(item),
+			LineTotal: lineTotal(item),
+			Discount:  shares[n],
+		})
 	}
-	return 0
+	return lines
 }
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"testing"
+
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+)
+
+// A general discount is capped by the lines it can discount, not by the order total the client sends, so its
+// allocation never gives a line more than it costs.
+func TestGeneralDiscountIsCappedByEligibleLines(t *testing.T) {
+	tests := []struct {
+		name         string
+		coupon       models.Coupon
+		cart         []models.CartItem
+		orderTotal   string
+		wantTotal    string
+		wantCappedBy string
+	}{
+		{
+			name:         "fixed amount above the only line",
+			coupon:       models.Coupon{DiscountType: "fixed_amount", DiscountAmount: inr("500.00")},
+			cart:         []models.CartItem{{ID: "med1", Price: inr("10.00"), Quantity: 1}},
+			orderTotal:   "1000.00",
+			wantTotal:    "10.00",
+			wantCappedBy: models.DiscountCappedByEligibleAmount,
+		},
+		{
+			name:   "fixed amount with an excluded line",
+			coupon: models.Coupon{DiscountType: "fixed_amount", DiscountAmount: inr("500.00"), ExcludedMedicines: []models.Medicine{{ID: "med2"}}},
+			cart: []models.CartItem{
+				{ID: "med1", Price: inr("10.00"), Quantity: 2},
+				{ID: "med2", Price: inr("300.00"), Quantity: 1},
+			},
+			orderTotal:   "1000.00",
+			wantTotal:    "20.00",
+			wantCappedBy: models.DiscountCappedByEligibleAmount,
+		},
+		{
+			name:         "percentage of an order total above the lines",
+			coupon:       models.Coupon{DiscountType: "percentage", DiscountPercent: money.MustParsePercent("50.00")},
+			cart:         []models.CartItem{{ID: "med1", Price: inr("10.00"), Quantity: 1}},
+			orderTotal:   "1000.00",
+			wantTotal:    "10.00",
+			wantCappedBy: models.DiscountCappedByEligibleAmount,
+		},
+		{
+			name:       "fixed amount below the lines",
+			coupon:     models.Coupon{DiscountType: "fixed_amount", DiscountAmount: inr("5.00")},
+			cart:       []models.CartItem{{ID: "med1", Price: inr("10.00"), Quantity: 1}},
+			orderTotal: "1000.00",
+			wantTotal:  "5.00",
+		},
+		{
+			name:   "order total below the excluded lines",
+			coupon: models.Coupon{DiscountType: "percentage", DiscountPercent: money.MustParsePercent("10.00"), ExcludedCategories: []models.Category{{ID: "X"}}},
+			cart: []models.CartItem{
+				{ID: "med1", Category: "X", Price: inr("500.00"), Quantity: 1},
+				{ID: "med2", Category: "Y", Price: inr("100.00"), Quantity: 1},
+			},
+			orderTotal: "400.00",
+			wantTotal:  "10.00",
+		},
+		{
+			name:       "no lines",
+			coupon:     models.Coupon{DiscountType: "fixed_amount", DiscountAmount: inr("500.00")},
+			orderTotal: "1000.00",
+			wantTotal:  "500.00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewGeneralDiscount(&tt.coupon, tt.cart, inr(tt.orderTotal)).CalculateDiscount()
+			if result.Total.Cmp(inr(tt.wantTotal)) != 0 || result.CappedBy != tt.wantCappedBy {
+				t.Errorf("discount is %s capped by %q, want %s capped by %q", result.Total, result.CappedBy, tt.wantTotal, tt.wantCappedBy)
+			}
+			allocated := inr("0.00")
+			for _, line := range result.Lines {
+				if lineTotal := lineTotal(tt.cart[line.LineIndex]); lineTotal.LessThan(line.Discount) {
+					t.Errorf("line %d is discounted %s, more than its total of %s", line.LineIndex, line.Discount, lineTotal)
+				}
+				allocated = allocated.Add(line.Discount)
+			}
+			if len(tt.cart) > 0 && allocated.Cmp(result.Total) != 0 {
+				t.Errorf("the lines are discounted %s in all, want %s", allocated, result.Total)
+			}
+		})
+	}
+}
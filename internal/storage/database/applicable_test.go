@@ -0,0 +1,135 @@
+package database_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"coupon-system/internal/models"
+	"coupon-system/internal/storage/database"
+
+	"github.com/google/uuid"
+)
+
+// cart is the cart the applicability tests look at: a line of med1 in Painkillers, one of med2 in Vitamins and
+// one of med3 in Controlled, a category on the global exclusion list.
+var cart = struct {
+	medicineIDs []string
+	categoryIDs []string
+	total       string
+}{
+	medicineIDs: []string{"med1", "med2", "med3"},
+	categoryIDs: []string{"Painkillers", "Vitamins", "Controlled"},
+	total:       "200.00",
+}
+
+// createCartCoupons creates coupons of which the ones named APPLIES... apply to cart for u1 at now, in UTC, and
+// those named NEAR... would apply if the order total reached their minimum order value.
+func createCartCoupons(t *testing.T, store database.CouponStorage) {
+	t.Helper()
+	exclusion := &models.DiscountExclusion{ID: uuid.New().String(), Kind: models.ExclusionKindCategory, ItemID: "Controlled", CreatedAt: now}
+	if err := store.CreateExclusion(context.Background(), exclusion); err != nil {
+		t.Fatalf("failed to create exclusion: %v", err)
+	}
+
+	usedUp := newCoupon("USED_UP", func(c *models.Coupon) { c.MaxUsagePerUser = 1 })
+	redeemed := newCoupon("REDEEMED", func(c *models.Coupon) { c.UsageType = models.UsageTypeOneTime; c.MaxTotalUsage = 1 })
+	createCoupons(t, store,
+		newCoupon("APPLIES_GENERAL"),
+		newCoupon("APPLIES_MEDICINE", func(c *models.Coupon) { c.MedicineIDs = medicines("med1", "med9") }),
+		newCoupon("APPLIES_CATEGORY", func(c *models.Coupon) { c.Categories = categories("Vitamins") }),
+		newCoupon("APPLIES_WEDNESDAY_NOON", func(c *models.Coupon) {
+			c.UsageType = models.UsageTypeTimeBased
+			c.Recurrence = models.Recurrence{Days: 1 << int(time.Wednesday), StartMinute: 11 * 60, EndMinute: 13 * 60}
+		}),
+		newCoupon("APPLIES_WINDOW", func(c *models.Coupon) {
+			c.UsageType = models.UsageTypeTimeBased
+			start, end := now.Add(-time.Hour), now.Add(time.Hour)
+			c.ValidTimeWindowStart, c.ValidTimeWindowEnd = &start, &end
+		}),
+		newCoupon("NEAR_300", func(c *models.Coupon) { c.MinOrderValue = inr("300.00") }),
+		newCoupon("NEAR_500", func(c *models.Coupon) { c.MinOrderValue = inr("500.00") }),
+		newCoupon("OTHER_MEDICINE", func(c *models.Coupon) { c.MedicineIDs = medicines("med9") }),
+		newCoupon("EXCLUDED_BY_COUPON", func(c *models.Coupon) {
+			c.MedicineIDs = medicines("med1")
+			c.ExcludedCategories = categories("Painkillers")
+		}),
+		newCoupon("EXCLUDED_GLOBALLY", func(c *models.Coupon) { c.Categories = categories("Controlled") }),
+		newCoupon("INACTIVE", func(c *models.Coupon) { c.IsActive = false }),
+		newCoupon("EXPIRED", func(c *models.Coupon) { c.ExpiryDate = now.Add(-time.Minute) }),
+		newCoupon("NOT_YET_VALID", func(c *models.Coupon) {
+			c.UsageType = models.UsageTypeTimeBased
+			start := now.Add(time.Hour)
+			c.ValidTimeWindowStart = &start
+		}),
+		newCoupon("WEEKENDS", func(c *models.Coupon) {
+			c.UsageType = models.UsageTypeTimeBased
+			c.Recurrence = models.Recurrence{Days: 1<<int(time.Saturday) | 1<<int(time.Sunday)}
+		}),
+		newCoupon("NO_WINDOW", func(c *models.Coupon) { c.UsageType = models.UsageTypeTimeBased }),
+		usedUp,
+		redeemed,
+	)
+	createHolds(t, store, newHold(usedUp, "u1"), newHold(redeemed, "u2"))
+}
+
+func TestApplicableCouponsAreFilteredByCart(t *testing.T) {
+	tests := []struct {
+		name        string
+		timestamp   time.Time
+		medicineIDs []string
+		categoryIDs []string
+		want        []string
+	}{
+		{"the cart", now, cart.medicineIDs, cart.categoryIDs, []string{"APPLIES_CATEGORY", "APPLIES_GENERAL", "APPLIES_MEDICINE", "APPLIES_WEDNESDAY_NOON", "APPLIES_WINDOW"}},
+		// 17:30 in India, outside of the recurring window
+		{"the cart in another time zone", now.In(time.FixedZone("IST", 5*60*60+30*60)), cart.medicineIDs, cart.categoryIDs, []string{"APPLIES_CATEGORY", "APPLIES_GENERAL", "APPLIES_MEDICINE", "APPLIES_WINDOW"}},
+		{"an empty cart", now, nil, nil, []string{"APPLIES_GENERAL", "APPLIES_WEDNESDAY_NOON", "APPLIES_WINDOW"}},
+		{"only excluded lines", now, []string{"med3"}, []string{"Controlled"}, nil},
+	}
+
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		createCartCoupons(t, store)
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				coupons, err := store.GetApplicableCoupons(ctx, tt.timestamp, inr(cart.total), tt.medicineIDs, tt.categoryIDs, "u1")
+				if err != nil {
+					t.Fatalf("GetApplicableCoupons failed: %v", err)
+				}
+				got := codesOf(coupons)
+				slices.Sort(got)
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("GetApplicableCoupons returned %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		// Associations are loaded with the coupons
+		coupons, err := store.GetApplicableCoupons(ctx, now, inr(cart.total), []string{"med1"}, []string{"Painkillers"}, "u1")
+		if err != nil {
+			t.Fatalf("GetApplicableCoupons failed: %v", err)
+		}
+		for _, coupon := range coupons {
+			if coupon.CouponCode == "APPLIES_MEDICINE" && len(coupon.MedicineIDs) != 2 {
+				t.Errorf("APPLIES_MEDICINE was returned with medicines %v, want med1 and med9", coupon.MedicineIDs)
+			}
+		}
+	})
+}
+
+func TestNearMissesAreOrderedByMinimumOrderValue(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		createCartCoupons(t, store)
+		for limit, want := range map[int][]string{1: {"NEAR_300"}, 5: {"NEAR_300", "NEAR_500"}} {
+			coupons, err := store.GetNearMissCoupons(context.Background(), now, inr(cart.total), cart.medicineIDs, cart.categoryIDs, "u1", limit)
+			if err != nil {
+				t.Fatalf("GetNearMissCoupons failed: %v", err)
+			}
+			if got := codesOf(coupons); !slices.Equal(got, want) {
+				t.Errorf("GetNearMissCoupons with limit %d returned %v, want %v", limit, got, want)
+			}
+		}
+	})
+}
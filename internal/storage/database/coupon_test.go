@@ -0,0 +1,225 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"coupon-system/internal/storage/database"
+
+	"github.com/google/uuid"
+)
+
+func TestCouponsAreStoredWithTheirAssociations(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		coupon := newCoupon("STORED", func(c *models.Coupon) {
+			c.DiscountType = "tiered"
+			c.MinOrderValue = inr("100.00")
+			c.MedicineIDs = medicines("med1")
+			c.Categories = categories("Vitamins")
+			c.ExcludedMedicines = medicines("med2")
+			c.Tiers = []models.CouponTier{
+				{Position: 0, Threshold: inr("100.00"), DiscountType: "fixed_amount", DiscountAmount: inr("10.00")},
+				{Position: 1, Threshold: inr("500.00"), DiscountType: "percentage", DiscountPercent: money.MustParsePercent("15.00")},
+			}
+		})
+		createCoupons(t, store, coupon)
+
+		byCode, err := store.GetCouponByCode(ctx, "STORED")
+		if err != nil || byCode == nil {
+			t.Fatalf("GetCouponByCode returned %v, %v; want the coupon", byCode, err)
+		}
+		byID, err := store.GetCouponByID(ctx, coupon.ID)
+		if err != nil || byID == nil {
+			t.Fatalf("GetCouponByID returned %v, %v; want the coupon", byID, err)
+		}
+
+		for _, got := range []*models.Coupon{byCode, byID} {
+			switch {
+			case got.ID != coupon.ID || got.CouponCode != coupon.CouponCode:
+				t.Errorf("got coupon %s %s, want %s %s", got.ID, got.CouponCode, coupon.ID, coupon.CouponCode)
+			case !got.ExpiryDate.Equal(coupon.ExpiryDate):
+				t.Errorf("expiry date is %v, want %v", got.ExpiryDate, coupon.ExpiryDate)
+			case got.MinOrderValue.Cmp(coupon.MinOrderValue) != 0:
+				t.Errorf("minimum order value is %v, want %v", got.MinOrderValue, coupon.MinOrderValue)
+			case !got.IsActive:
+				t.Errorf("coupon is not active")
+			case len(got.MedicineIDs) != 1 || got.MedicineIDs[0].ID != "med1":
+				t.Errorf("medicines are %v, want med1", got.MedicineIDs)
+			case len(got.Categories) != 1 || got.Categories[0].ID != "Vitamins":
+				t.Errorf("categories are %v, want Vitamins", got.Categories)
+			case len(got.ExcludedMedicines) != 1 || got.ExcludedMedicines[0].ID != "med2":
+				t.Errorf("excluded medicines are %v, want med2", got.ExcludedMedicines)
+			case len(got.Tiers) != 2 || got.Tiers[0].Position != 0 || got.Tiers[1].DiscountPercent != coupon.Tiers[1].DiscountPercent:
+				t.Errorf("tiers are %+v, want %+v", got.Tiers, coupon.Tiers)
+			}
+		}
+	})
+}
+
+func TestMissingRecordsAreNil(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		if coupon, err := store.GetCouponByCode(ctx, "MISSING"); coupon != nil || err != nil {
+			t.Errorf("GetCouponByCode returned %v, %v; want nil, nil", coupon, err)
+		}
+		if coupon, err := store.GetCouponByID(ctx, uuid.New().String()); coupon != nil || err != nil {
+			t.Errorf("GetCouponByID returned %v, %v; want nil, nil", coupon, err)
+		}
+		if redemption, err := store.GetRedemption(ctx, uuid.New().String()); redemption != nil || err != nil {
+			t.Errorf("GetRedemption returned %v, %v; want nil, nil", redemption, err)
+		}
+		if redemption, err := store.CommitRedemption(ctx, uuid.New().String(), now); redemption != nil || err != nil {
+			t.Errorf("CommitRedemption returned %v, %v; want nil, nil", redemption, err)
+		}
+		if redemption, err := store.ReleaseRedemption(ctx, uuid.New().String()); redemption != nil || err != nil {
+			t.Errorf("ReleaseRedemption returned %v, %v; want nil, nil", redemption, err)
+		}
+		if usage, err := store.GetUserUsageForCoupon(ctx, "u1", uuid.New().String()); usage != 0 || err != nil {
+			t.Errorf("GetUserUsageForCoupon returned %d, %v; want 0, nil", usage, err)
+		}
+	})
+}
+
+func TestCouponCodesAreUnique(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		first, second := newCoupon("TAKEN"), newCoupon("OTHER")
+		createCoupons(t, store, first, second)
+
+		if err := store.CreateCoupon(ctx, newCoupon("TAKEN")); !errors.Is(err, database.ErrCouponCodeTaken) {
+			t.Errorf("creating a coupon with a taken code returned %v, want ErrCouponCodeTaken", err)
+		}
+		second.CouponCode = "TAKEN"
+		if err := store.UpdateCoupon(ctx, second); !errors.Is(err, database.ErrCouponCodeTaken) {
+			t.Errorf("renaming a coupon to a taken code returned %v, want ErrCouponCodeTaken", err)
+		}
+	})
+}
+
+func TestUpdatesReplaceSettingsAndKeepUsage(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		coupon := newCoupon("UPDATED", func(c *models.Coupon) { c.MedicineIDs = medicines("med1") })
+		createCoupons(t, store, coupon)
+		createHolds(t, store, newHold(coupon, "u1"))
+
+		// The coupon was read before the hold, so its usage counter is stale
+		coupon.MinOrderValue = inr("50.00")
+		coupon.MedicineIDs = medicines("med3")
+		coupon.Recurrence = models.Recurrence{Days: 1 << int(time.Saturday), StartMinute: 18 * 60, EndMinute: 21 * 60}
+		if err := store.UpdateCoupon(ctx, coupon); err != nil {
+			t.Fatalf("failed to update coupon: %v", err)
+		}
+
+		got, err := store.GetCouponByID(ctx, coupon.ID)
+		switch {
+		case err != nil || got == nil:
+			t.Fatalf("GetCouponByID returned %v, %v; want the coupon", got, err)
+		case got.MinOrderValue.Cmp(coupon.MinOrderValue) != 0:
+			t.Errorf("minimum order value is %v, want %v", got.MinOrderValue, coupon.MinOrderValue)
+		case got.Recurrence != coupon.Recurrence:
+			t.Errorf("recurrence is %+v, want %+v", got.Recurrence, coupon.Recurrence)
+		case len(got.MedicineIDs) != 1 || got.MedicineIDs[0].ID != "med3":
+			t.Errorf("medicines are %v, want med3", got.MedicineIDs)
+		case got.CurrentTotalUsage != 1:
+			t.Errorf("current total usage is %d after the update, want 1", got.CurrentTotalUsage)
+		}
+	})
+}
+
+func TestDeletedCouponsCannotBeFound(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		coupon := newCoupon("DELETED")
+		createCoupons(t, store, coupon)
+
+		if deleted, err := store.DeleteCoupon(ctx, coupon.ID); !deleted || err != nil {
+			t.Fatalf("DeleteCoupon returned %v, %v; want true, nil", deleted, err)
+		}
+		if got, err := store.GetCouponByCode(ctx, "DELETED"); got != nil || err != nil {
+			t.Errorf("GetCouponByCode returned %v, %v after deleting; want nil, nil", got, err)
+		}
+		if deleted, err := store.DeleteCoupon(ctx, coupon.ID); deleted || err != nil {
+			t.Errorf("deleting again returned %v, %v; want false, nil", deleted, err)
+		}
+	})
+}
+
+func TestCouponsAreListedByFilterAndPage(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		active := newCoupon("ACTIVE")
+		inactive := newCoupon("INACTIVE", func(c *models.Coupon) { c.IsActive = false })
+		expired := newCoupon("EXPIRED", func(c *models.Coupon) { c.ExpiryDate = now.AddDate(0, 0, -1) })
+		targeted := newCoupon("TARGETED", func(c *models.Coupon) {
+			c.UsageType = models.UsageTypeOneTime
+			c.MaxTotalUsage = 1
+			c.MedicineIDs = medicines("med1")
+		})
+		for i, coupon := range []*models.Coupon{active, inactive, expired, targeted} {
+			coupon.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+		}
+		createCoupons(t, store, active, inactive, expired, targeted)
+
+		tests := []struct {
+			filter database.CouponFilter
+			want   []string
+			total  int64
+		}{
+			{database.CouponFilter{Limit: 10}, []string{"TARGETED", "EXPIRED", "INACTIVE", "ACTIVE"}, 4},
+			{database.CouponFilter{Limit: 2, Offset: 1}, []string{"EXPIRED", "INACTIVE"}, 4},
+			{database.CouponFilter{Status: database.CouponStatusActive, Now: now, Limit: 10}, []string{"TARGETED", "ACTIVE"}, 2},
+			{database.CouponFilter{Status: database.CouponStatusInactive, Now: now, Limit: 10}, []string{"INACTIVE"}, 1},
+			{database.CouponFilter{Status: database.CouponStatusExpired, Now: now, Limit: 10}, []string{"EXPIRED"}, 1},
+			{database.CouponFilter{UsageType: models.UsageTypeOneTime, Limit: 10}, []string{"TARGETED"}, 1},
+			{database.CouponFilter{MedicineID: "med1", Limit: 10}, []string{"TARGETED"}, 1},
+		}
+		for _, tt := range tests {
+			coupons, total, err := store.ListCoupons(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("ListCoupons(%+v) failed: %v", tt.filter, err)
+			}
+			if got := codesOf(coupons); !slices.Equal(got, tt.want) || total != tt.total {
+				t.Errorf("ListCoupons(%+v) returned %v of %d, want %v of %d", tt.filter, got, total, tt.want, tt.total)
+			}
+		}
+	})
+}
+
+func TestExclusionsAreUniquePerItem(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		medicine := &models.DiscountExclusion{ID: uuid.New().String(), Kind: models.ExclusionKindMedicine, ItemID: "med1", CreatedAt: now}
+		category := &models.DiscountExclusion{ID: uuid.New().String(), Kind: models.ExclusionKindCategory, ItemID: "Controlled", CreatedAt: now}
+		for _, exclusion := range []*models.DiscountExclusion{medicine, category} {
+			if err := store.CreateExclusion(ctx, exclusion); err != nil {
+				t.Fatalf("failed to create exclusion: %v", err)
+			}
+		}
+
+		duplicate := &models.DiscountExclusion{ID: uuid.New().String(), Kind: models.ExclusionKindMedicine, ItemID: "med1", CreatedAt: now}
+		if err := store.CreateExclusion(ctx, duplicate); !errors.Is(err, database.ErrExclusionExists) {
+			t.Errorf("excluding an item twice returned %v, want ErrExclusionExists", err)
+		}
+
+		exclusions, err := store.ListExclusions(ctx)
+		if err != nil {
+			t.Fatalf("failed to list exclusions: %v", err)
+		}
+		if len(exclusions) != 2 || exclusions[0].ID != category.ID || exclusions[1].ID != medicine.ID {
+			t.Errorf("exclusions are %+v, want the category and then the medicine", exclusions)
+		}
+
+		if deleted, err := store.DeleteExclusion(ctx, medicine.ID); !deleted || err != nil {
+			t.Errorf("DeleteExclusion returned %v, %v; want true, nil", deleted, err)
+		}
+		if deleted, err := store.DeleteExclusion(ctx, medicine.ID); deleted || err != nil {
+			t.Errorf("deleting again returned %v, %v; want false, nil", deleted, err)
+		}
+	})
+}
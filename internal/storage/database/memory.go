@@ -0,0 +1,586 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryStore implements the CouponStorage interface in memory, for tests and demos. It behaves like SQLStore,
+// down to keeping deleted coupons' codes taken, but everything is lost when the process exits. A single lock
+// guards the whole store, so usage limits hold under concurrent redemptions. Coupons and redemptions are copied
+// on the way in and out, so callers cannot change the stored ones.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	coupons     map[string]*models.Coupon // By ID, including deleted coupons
+	couponIDs   map[string]string         // Coupon IDs by code, including deleted coupons
+	usages      map[userCoupon]int
+	redemptions map[string]*models.CouponRedemption
+	exclusions  map[string]*models.DiscountExclusion
+	nextTierID  uint
+}
+
+// userCoupon identifies a user's usage of a coupon.
+type userCoupon struct {
+	userID   string
+	couponID string
+}
+
+// NewMemoryStore creates a new, empty instance of MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		coupons:     make(map[string]*models.Coupon),
+		couponIDs:   make(map[string]string),
+		usages:      make(map[userCoupon]int),
+		redemptions: make(map[string]*models.CouponRedemption),
+		exclusions:  make(map[string]*models.DiscountExclusion),
+	}
+}
+
+// CreateCoupon stores a new coupon.
+func (s *MemoryStore) CreateCoupon(ctx context.Context, coupon *models.Coupon) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, taken := s.couponIDs[coupon.CouponCode]; taken {
+		return ErrCouponCodeTaken
+	}
+	if _, exists := s.coupons[coupon.ID]; exists {
+		return errors.New("failed to create coupon: a coupon with this ID already exists")
+	}
+
+	s.setTierIDs(coupon)
+	s.coupons[coupon.ID] = cloneCoupon(coupon)
+	sortTiers(s.coupons[coupon.ID])
+	s.couponIDs[coupon.CouponCode] = coupon.ID
+	return nil
+}
+
+// GetCouponByID retrieves a coupon by its ID, along with its associations.
+func (s *MemoryStore) GetCouponByID(ctx context.Context, couponID string) (*models.Coupon, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coupon := s.liveCoupon(couponID)
+	if coupon == nil {
+		return nil, nil // Coupon not found is not an error in this context
+	}
+	return cloneCoupon(coupon), nil
+}
+
+// GetCouponByCode retrieves a coupon by its coupon code, along with its associations.
+func (s *MemoryStore) GetCouponByCode(ctx context.Context, couponCode string) (*models.Coupon, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coupon := s.liveCoupon(s.couponIDs[couponCode])
+	if coupon == nil {
+		return nil, nil // Coupon not found is not an error in this context
+	}
+	return cloneCoupon(coupon), nil
+}
+
+// ListCoupons retrieves one page of the coupons matching the filter, newest first.
+func (s *MemoryStore) ListCoupons(ctx context.Context, filter CouponFilter) ([]models.Coupon, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []*models.Coupon
+	for _, coupon := range s.coupons {
+		if !coupon.DeletedAt.Valid && matchesFilter(coupon, filter) {
+			matches = append(matches, coupon)
+		}
+	}
+	slices.SortFunc(matches, func(a, b *models.Coupon) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	total := int64(len(matches))
+	matches = matches[min(filter.Offset, len(matches)):]
+	if filter.Limit >= 0 {
+		matches = matches[:min(filter.Limit, len(matches))]
+	}
+	return cloneCoupons(matches), total, nil
+}
+
+// matchesFilter reports whether a coupon matches every condition of a filter.
+func matchesFilter(coupon *models.Coupon, filter CouponFilter) bool {
+	switch filter.Status {
+	case CouponStatusActive:
+		if !coupon.IsActive || !coupon.ExpiryDate.After(filter.Now) {
+			return false
+		}
+	case CouponStatusInactive:
+		if coupon.IsActive {
+			return false
+		}
+	case CouponStatusExpired:
+		if coupon.ExpiryDate.After(filter.Now) {
+			return false
+		}
+	}
+	switch {
+	case filter.UsageType != "" && coupon.UsageType != filter.UsageType:
+		return false
+	case filter.ExpiresAfter != nil && coupon.ExpiryDate.Before(*filter.ExpiresAfter):
+		return false
+	case filter.ExpiresBefore != nil && coupon.ExpiryDate.After(*filter.ExpiresBefore):
+		return false
+	case filter.MedicineID != "" && !slices.ContainsFunc(coupon.MedicineIDs, func(m models.Medicine) bool { return m.ID == filter.MedicineID }):
+		return false
+	case filter.Category != "" && !slices.ContainsFunc(coupon.Categories, func(c models.Category) bool { return c.ID == filter.Category }):
+		return false
+	}
+	return true
+}
+
+// UpdateCoupon saves a coupon's settings and replaces its associations. Usage counters are left untouched so
+// that concurrent redemptions are not overwritten.
+func (s *MemoryStore) UpdateCoupon(ctx context.Context, coupon *models.Coupon) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.liveCoupon(coupon.ID)
+	if stored == nil {
+		return nil
+	}
+	if id, taken := s.couponIDs[coupon.CouponCode]; taken && id != coupon.ID {
+		return ErrCouponCodeTaken
+	}
+
+	s.setTierIDs(coupon)
+	updated := cloneCoupon(coupon)
+	updated.CurrentTotalUsage = stored.CurrentTotalUsage
+	updated.CreatedAt = stored.CreatedAt
+	updated.DeletedAt = stored.DeletedAt
+	sortTiers(updated)
+
+	delete(s.couponIDs, stored.CouponCode)
+	s.coupons[coupon.ID] = updated
+	s.couponIDs[updated.CouponCode] = coupon.ID
+	return nil
+}
+
+// DeleteCoupon soft-deletes a coupon. Its code stays taken and its redemption history is kept.
+func (s *MemoryStore) DeleteCoupon(ctx context.Context, couponID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coupon := s.liveCoupon(couponID)
+	if coupon == nil {
+		return false, nil
+	}
+	coupon.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return true, nil
+}
+
+// GetApplicableCoupons retrieves the coupons applicable to a cart, along with their associations.
+func (s *MemoryStore) GetApplicableCoupons(ctx context.Context, timestamp time.Time, orderTotal money.Money, medicineIDs []string, categoryIDs []string, userID string) ([]models.Coupon, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var coupons []*models.Coupon
+	for _, coupon := range s.couponsForCart(timestamp, medicineIDs, categoryIDs, userID) {
+		if coupon.MinOrderValue.Amount <= orderTotal.Amount {
+			coupons = append(coupons, coupon)
+		}
+	}
+	slices.SortFunc(coupons, func(a, b *models.Coupon) int { return strings.Compare(a.CouponCode, b.CouponCode) })
+	return cloneCoupons(coupons), nil
+}
+
+// GetNearMissCoupons retrieves the coupons that would apply to a cart if the order total reached their minimum
+// order value, closest first.
+func (s *MemoryStore) GetNearMissCoupons(ctx context.Context, timestamp time.Time, orderTotal money.Money, medicineIDs []string, categoryIDs []string, userID string, limit int) ([]models.Coupon, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var coupons []*models.Coupon
+	for _, coupon := range s.couponsForCart(timestamp, medicineIDs, categoryIDs, userID) {
+		if coupon.MinOrderValue.Amount > orderTotal.Amount {
+			coupons = append(coupons, coupon)
+		}
+	}
+	slices.SortFunc(coupons, func(a, b *models.Coupon) int {
+		if c := cmp.Compare(a.MinOrderValue.Amount, b.MinOrderValue.Amount); c != 0 {
+			return c
+		}
+		return strings.Compare(a.CouponCode, b.CouponCode)
+	})
+	return cloneCoupons(coupons[:min(limit, len(coupons))]), nil
+}
+
+// couponsForCart returns the coupons that are usable at the timestamp, meet the requirements of their usage
+// type, have uses left for the user and can discount at least one cart line, like SQLStore's
+// couponsForCartQuery. The minimum order value is left to the caller.
+func (s *MemoryStore) couponsForCart(timestamp time.Time, medicineIDs []string, categoryIDs []string, userID string) []*models.Coupon {
+	var coupons []*models.Coupon
+	for _, coupon := range s.coupons {
+		switch {
+		case coupon.DeletedAt.Valid || !coupon.IsActive || !coupon.ExpiryDate.After(timestamp):
+		case coupon.MaxTotalUsage > 0 && coupon.CurrentTotalUsage >= coupon.MaxTotalUsage:
+		case coupon.UsageType == models.UsageTypeOneTime && coupon.CurrentTotalUsage > 0:
+		case coupon.UsageType == models.UsageTypeTimeBased && coupon.ValidTimeWindowStart == nil && coupon.ValidTimeWindowEnd == nil && coupon.Recurrence == (models.Recurrence{}):
+		case coupon.ValidTimeWindowStart != nil && coupon.ValidTimeWindowStart.After(timestamp):
+		case coupon.ValidTimeWindowEnd != nil && coupon.ValidTimeWindowEnd.Before(timestamp):
+		case !recurrenceIncludes(coupon.Recurrence, timestamp):
+		case coupon.MaxUsagePerUser > 0 && s.usages[userCoupon{userID, coupon.ID}] >= coupon.MaxUsagePerUser:
+		case !s.discountsAnyLine(coupon, medicineIDs, categoryIDs):
+		default:
+			coupons = append(coupons, coupon)
+		}
+	}
+	return coupons
+}
+
+// recurrenceIncludes reports whether a time falls within a recurrence, in the time's location.
+func recurrenceIncludes(recurrence models.Recurrence, local time.Time) bool {
+	if recurrence.Days != 0 && recurrence.Days&(1<<int(local.Weekday())) == 0 {
+		return false
+	}
+	if recurrence.Weeks != 0 && recurrence.Weeks&(1<<(models.WeekOfMonth(local)-1)) == 0 {
+		return false
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	switch {
+	case recurrence.StartMinute == recurrence.EndMinute:
+		return true
+	case recurrence.StartMinute < recurrence.EndMinute:
+		return minute >= recurrence.StartMinute && minute < recurrence.EndMinute
+	default:
+		return minute >= recurrence.StartMinute || minute < recurrence.EndMinute
+	}
+}
+
+// discountsAnyLine reports whether a coupon can discount a cart line: one that neither the coupon nor the global
+// exclusion list excludes and that the coupon targets, if it targets anything. Without lines, only coupons
+// that target nothing apply.
+func (s *MemoryStore) discountsAnyLine(coupon *models.Coupon, medicineIDs []string, categoryIDs []string) bool {
+	untargeted := len(coupon.MedicineIDs) == 0 && len(coupon.Categories) == 0
+	if len(medicineIDs) == 0 {
+		return untargeted
+	}
+
+	hasMedicine := func(medicines []models.Medicine, id string) bool {
+		return slices.ContainsFunc(medicines, func(m models.Medicine) bool { return m.ID == id })
+	}
+	hasCategory := func(categories []models.Category, id string) bool {
+		return slices.ContainsFunc(categories, func(c models.Category) bool { return c.ID == id })
+	}
+	for i := range medicineIDs {
+		switch {
+		case s.isExcluded(models.ExclusionKindMedicine, medicineIDs[i]) || s.isExcluded(models.ExclusionKindCategory, categoryIDs[i]):
+		case hasMedicine(coupon.ExcludedMedicines, medicineIDs[i]) || hasCategory(coupon.ExcludedCategories, categoryIDs[i]):
+		case untargeted || hasMedicine(coupon.MedicineIDs, medicineIDs[i]) || hasCategory(coupon.Categories, categoryIDs[i]):
+			return true
+		}
+	}
+	return false
+}
+
+// isExcluded reports whether an item is on the global exclusion list.
+func (s *MemoryStore) isExcluded(kind string, itemID string) bool {
+	for _, exclusion := range s.exclusions {
+		if exclusion.Kind == kind && exclusion.ItemID == itemID {
+			return true
+		}
+	}
+	return false
+}
+
+// ListExclusions retrieves the global exclusion list.
+func (s *MemoryStore) ListExclusions(ctx context.Context) ([]models.DiscountExclusion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	exclusions := make([]models.DiscountExclusion, 0, len(s.exclusions))
+	for _, exclusion := range s.exclusions {
+		exclusions = append(exclusions, *exclusion)
+	}
+	slices.SortFunc(exclusions, func(a, b models.DiscountExclusion) int {
+		if c := strings.Compare(a.Kind, b.Kind); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ItemID, b.ItemID)
+	})
+	return exclusions, nil
+}
+
+// CreateExclusion adds a medicine or category to the global exclusion list.
+func (s *MemoryStore) CreateExclusion(ctx context.Context, exclusion *models.DiscountExclusion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isExcluded(exclusion.Kind, exclusion.ItemID) {
+		return ErrExclusionExists
+	}
+	stored := *exclusion
+	s.exclusions[exclusion.ID] = &stored
+	return nil
+}
+
+// DeleteExclusion removes a medicine or category from the global exclusion list.
+func (s *MemoryStore) DeleteExclusion(ctx context.Context, exclusionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.exclusions[exclusionID]; !exists {
+		return false, nil
+	}
+	delete(s.exclusions, exclusionID)
+	return true, nil
+}
+
+// GetUserUsageForCoupon retrieves the number of times a user has used a specific coupon.
+func (s *MemoryStore) GetUserUsageForCoupon(ctx context.Context, userID string, couponID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.usages[userCoupon{userID, couponID}], nil
+}
+
+// GetCouponUsages retrieves the current usage of coupons and a user's usage of them.
+func (s *MemoryStore) GetCouponUsages(ctx context.Context, userID string, couponIDs []string) (map[string]CouponUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usages := make(map[string]CouponUsage, len(couponIDs))
+	for _, couponID := range couponIDs {
+		if coupon := s.liveCoupon(couponID); coupon != nil {
+			usages[couponID] = CouponUsage{TotalUsage: coupon.CurrentTotalUsage, UserUsage: s.usages[userCoupon{userID, couponID}]}
+		}
+	}
+	return usages, nil
+}
+
+// CreateRedemption places a coupon hold and counts it against the coupon's usage.
+func (s *MemoryStore) CreateRedemption(ctx context.Context, redemption *models.CouponRedemption) error {
+	return s.CreateRedemptions(ctx, []*models.CouponRedemption{redemption})
+}
+
+// CreateRedemptions places several coupon holds, either all of them or none.
+func (s *MemoryStore) CreateRedemptions(ctx context.Context, redemptions []*models.CouponRedemption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A retry for the same order gets the hold already placed, rather than taking another use
+	var placed []*models.CouponRedemption
+	existing := make(map[*models.CouponRedemption]*models.CouponRedemption)
+	for _, redemption := range redemptions {
+		if found := s.findActiveRedemption(redemption); found != nil {
+			existing[redemption] = found
+			continue
+		}
+		if err := s.incrementCouponUsage(redemption.CouponID, redemption.UserID); err != nil {
+			// Give back the usage counted for the holds before it
+			for _, counted := range placed {
+				s.decrementCouponUsage(counted.CouponID, counted.UserID)
+			}
+			return err
+		}
+		placed = append(placed, redemption)
+	}
+	for _, redemption := range redemptions {
+		if found, ok := existing[redemption]; ok {
+			*redemption = *found
+			continue
+		}
+		stored := *redemption
+		s.redemptions[redemption.ID] = &stored
+	}
+	return nil
+}
+
+// findActiveRedemption returns the redemption of the same coupon by the same user for the same order as a new
+// one, if it is committed or still held when the new one is created, or nil if there is none. s.mu must be held.
+func (s *MemoryStore) findActiveRedemption(redemption *models.CouponRedemption) *models.CouponRedemption {
+	var found *models.CouponRedemption
+	for _, stored := range s.redemptions {
+		if stored.UserID != redemption.UserID || stored.CouponID != redemption.CouponID || stored.OrderID != redemption.OrderID {
+			continue
+		}
+		active := stored.Status == models.RedemptionStatusCommitted ||
+			(stored.Status == models.RedemptionStatusHeld && stored.ExpiresAt.After(redemption.CreatedAt))
+		if active && (found == nil || stored.CreatedAt.Before(found.CreatedAt)) {
+			found = stored
+		}
+	}
+	return found
+}
+
+// GetRedemption retrieves a redemption by its ID.
+func (s *MemoryStore) GetRedemption(ctx context.Context, redemptionID string) (*models.CouponRedemption, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	redemption, exists := s.redemptions[redemptionID]
+	if !exists {
+		return nil, nil // Redemption not found is not an error in this context
+	}
+	found := *redemption
+	return &found, nil
+}
+
+// CommitRedemption confirms a held redemption. A hold whose TTL has already passed is expired instead.
+func (s *MemoryStore) CommitRedemption(ctx context.Context, redemptionID string, now time.Time) (*models.CouponRedemption, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redemption, exists := s.redemptions[redemptionID]
+	if !exists {
+		return nil, nil
+	}
+	if redemption.Status != models.RedemptionStatusHeld {
+		found := *redemption
+		return &found, ErrRedemptionNotHeld
+	}
+
+	if now.After(redemption.ExpiresAt) {
+		s.expire(redemption, now)
+		expired := *redemption
+		return &expired, ErrRedemptionNotHeld
+	}
+	redemption.Status = models.RedemptionStatusCommitted
+	redemption.UpdatedAt = now
+	committed := *redemption
+	return &committed, nil
+}
+
+// ReleaseRedemption cancels a held redemption and gives its usage back to the coupon.
+func (s *MemoryStore) ReleaseRedemption(ctx context.Context, redemptionID string) (*models.CouponRedemption, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redemption, exists := s.redemptions[redemptionID]
+	if !exists {
+		return nil, nil
+	}
+	if redemption.Status != models.RedemptionStatusHeld {
+		found := *redemption
+		return &found, ErrRedemptionNotHeld
+	}
+
+	redemption.Status = models.RedemptionStatusReleased
+	redemption.UpdatedAt = time.Now()
+	s.decrementCouponUsage(redemption.CouponID, redemption.UserID)
+	released := *redemption
+	return &released, nil
+}
+
+// ExpireRedemptions marks every hold whose TTL has passed as expired and gives its usage back to the coupon.
+func (s *MemoryStore) ExpireRedemptions(ctx context.Context, now time.Time) ([]models.CouponRedemption, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []models.CouponRedemption
+	for _, redemption := range s.redemptions {
+		if redemption.Status == models.RedemptionStatusHeld && redemption.ExpiresAt.Before(now) {
+			s.expire(redemption, now)
+			expired = append(expired, *redemption)
+		}
+	}
+	return expired, nil
+}
+
+// expire marks a held redemption as expired and gives its usage back to the coupon.
+func (s *MemoryStore) expire(redemption *models.CouponRedemption, now time.Time) {
+	redemption.Status = models.RedemptionStatusExpired
+	redemption.UpdatedAt = now
+	s.decrementCouponUsage(redemption.CouponID, redemption.UserID)
+}
+
+// incrementCouponUsage counts one more use of the coupon, overall and for the user, unless that would take
+// it past one of its usage limits.
+func (s *MemoryStore) incrementCouponUsage(couponID string, userID string) error {
+	key := userCoupon{userID, couponID}
+	coupon := s.liveCoupon(couponID)
+	if coupon != nil && coupon.MaxUsagePerUser > 0 && s.usages[key] >= coupon.MaxUsagePerUser {
+		return &UsageLimitError{CouponID: couponID, Scope: UsageLimitPerUser}
+	}
+	if coupon == nil ||
+		coupon.MaxTotalUsage > 0 && coupon.CurrentTotalUsage >= coupon.MaxTotalUsage ||
+		coupon.UsageType == models.UsageTypeOneTime && coupon.CurrentTotalUsage > 0 {
+		return &UsageLimitError{CouponID: couponID, Scope: UsageLimitTotal}
+	}
+
+	s.usages[key]++
+	coupon.CurrentTotalUsage++
+	return nil
+}
+
+// decrementCouponUsage gives one use of the coupon back, overall and for the user.
+func (s *MemoryStore) decrementCouponUsage(couponID string, userID string) {
+	if coupon := s.liveCoupon(couponID); coupon != nil && coupon.CurrentTotalUsage > 0 {
+		coupon.CurrentTotalUsage--
+	}
+	if key := (userCoupon{userID, couponID}); s.usages[key] > 0 {
+		s.usages[key]--
+	}
+}
+
+// liveCoupon returns the stored coupon with an ID, or nil if there is none or it was deleted.
+func (s *MemoryStore) liveCoupon(couponID string) *models.Coupon {
+	coupon, exists := s.coupons[couponID]
+	if !exists || coupon.DeletedAt.Valid {
+		return nil
+	}
+	return coupon
+}
+
+// setTierIDs numbers a coupon's tiers and ties them to the coupon, as the database would.
+func (s *MemoryStore) setTierIDs(coupon *models.Coupon) {
+	for i := range coupon.Tiers {
+		s.nextTierID++
+		coupon.Tiers[i].ID = s.nextTierID
+		coupon.Tiers[i].CouponID = coupon.ID
+	}
+}
+
+// sortTiers orders a stored coupon's tiers by position, the order SQLStore loads them in.
+func sortTiers(coupon *models.Coupon) {
+	slices.SortStableFunc(coupon.Tiers, func(a, b models.CouponTier) int { return cmp.Compare(a.Position, b.Position) })
+}
+
+// cloneCoupon copies a coupon along with its associations and time windows.
+func cloneCoupon(coupon *models.Coupon) *models.Coupon {
+	clone := *coupon
+	clone.MedicineIDs = slices.Clone(coupon.MedicineIDs)
+	clone.Categories = slices.Clone(coupon.Categories)
+	clone.TriggerMedicines = slices.Clone(coupon.TriggerMedicines)
+	clone.TriggerCategories = slices.Clone(coupon.TriggerCategories)
+	clone.RewardMedicines = slices.Clone(coupon.RewardMedicines)
+	clone.RewardCategories = slices.Clone(coupon.RewardCategories)
+	clone.ExcludedMedicines = slices.Clone(coupon.ExcludedMedicines)
+	clone.ExcludedCategories = slices.Clone(coupon.ExcludedCategories)
+	clone.Tiers = slices.Clone(coupon.Tiers)
+	if coupon.ValidTimeWindowStart != nil {
+		start := *coupon.ValidTimeWindowStart
+		clone.ValidTimeWindowStart = &start
+	}
+	if coupon.ValidTimeWindowEnd != nil {
+		end := *coupon.ValidTimeWindowEnd
+		clone.ValidTimeWindowEnd = &end
+	}
+	return &clone
+}
+
+// cloneCoupons copies coupons for returning them.
+func cloneCoupons(coupons []*models.Coupon) []models.Coupon {
+	clones := make([]models.Coupon, 0, len(coupons))
+	for _, coupon := range coupons {
+		clones = append(clones, *cloneCoupon(coupon))
+	}
+	return clones
+}
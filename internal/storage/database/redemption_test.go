@@ -0,0 +1,302 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	"coupon-system/internal/models"
+	"coupon-system/internal/storage/database"
+
+	"github.com/google/uuid"
+)
+
+func TestConcurrentHoldsRespectLimits(t *testing.T) {
+	// Enough holds at once that SQLite's writers queue on its busy timeout and PostgreSQL's on the coupon's row lock
+	const attempts = 300
+	tests := []struct {
+		name      string
+		coupon    func(c *models.Coupon)
+		userID    func(i int) string // User placing the i-th hold
+		wantScope string
+		wantHeld  int
+	}{
+		{
+			name:      "total limit",
+			coupon:    func(c *models.Coupon) { c.MaxTotalUsage = 7 },
+			userID:    func(i int) string { return fmt.Sprintf("u%d", i) },
+			wantScope: database.UsageLimitTotal,
+			wantHeld:  7,
+		},
+		{
+			name:      "per user limit",
+			coupon:    func(c *models.Coupon) { c.MaxUsagePerUser = 3 },
+			userID:    func(i int) string { return "u1" },
+			wantScope: database.UsageLimitPerUser,
+			wantHeld:  3,
+		},
+		{
+			name:     "per user limit below total limit",
+			coupon:   func(c *models.Coupon) { c.MaxUsagePerUser = 2; c.MaxTotalUsage = 9 },
+			userID:   func(i int) string { return fmt.Sprintf("u%d", i%10) },
+			wantHeld: 9,
+		},
+		{
+			name: "one_time",
+			coupon: func(c *models.Coupon) {
+				c.UsageType = models.UsageTypeOneTime
+				c.MaxTotalUsage = 1
+			},
+			userID:    func(i int) string { return fmt.Sprintf("u%d", i) },
+			wantScope: database.UsageLimitTotal,
+			wantHeld:  1,
+		},
+	}
+
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				coupon := newCoupon(tt.name)
+				tt.coupon(coupon)
+				if err := store.CreateCoupon(ctx, coupon); err != nil {
+					t.Fatalf("failed to create coupon: %v", err)
+				}
+
+				var wg sync.WaitGroup
+				start := make(chan struct{})
+				errs := make([]error, attempts)
+				for i := range attempts {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						<-start
+						errs[i] = store.CreateRedemption(ctx, newHold(coupon, tt.userID(i)))
+					}()
+				}
+				close(start)
+				wg.Wait()
+
+				held := 0
+				for _, err := range errs {
+					var limitErr *database.UsageLimitError
+					switch {
+					case err == nil:
+						held++
+					case !errors.As(err, &limitErr):
+						t.Fatalf("a hold failed with %v, want a usage limit error", err)
+					case tt.wantScope != "" && limitErr.Scope != tt.wantScope:
+						t.Errorf("a hold reached the %s limit, want the %s limit", limitErr.Scope, tt.wantScope)
+					}
+				}
+				if held != tt.wantHeld {
+					t.Errorf("%d of %d concurrent holds were placed, want %d", held, attempts, tt.wantHeld)
+				}
+				got, err := store.GetCouponByID(ctx, coupon.ID)
+				if err != nil || got == nil {
+					t.Fatalf("GetCouponByID returned %v, %v", got, err)
+				}
+				if got.CurrentTotalUsage != tt.wantHeld {
+					t.Errorf("current total usage is %d, want %d", got.CurrentTotalUsage, tt.wantHeld)
+				}
+			})
+		}
+	})
+}
+
+func TestHoldsCountAgainstUsageLimits(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		coupon := newCoupon("LIMITED", func(c *models.Coupon) {
+			c.MaxUsagePerUser = 1
+			c.MaxTotalUsage = 2
+		})
+		createCoupons(t, store, coupon)
+
+		holds := []struct {
+			userID string
+			scope  string // Scope of the usage limit the hold reaches, empty when it is placed
+		}{
+			{"u1", ""},
+			{"u1", database.UsageLimitPerUser},
+			{"u2", ""},
+			{"u3", database.UsageLimitTotal},
+		}
+		for _, hold := range holds {
+			err := store.CreateRedemption(ctx, newHold(coupon, hold.userID))
+			var limitErr *database.UsageLimitError
+			switch {
+			case hold.scope == "" && err != nil:
+				t.Fatalf("holding the coupon for %s failed: %v", hold.userID, err)
+			case hold.scope != "" && (!errors.As(err, &limitErr) || limitErr.Scope != hold.scope):
+				t.Fatalf("holding the coupon for %s returned %v, want a %s usage limit error", hold.userID, err, hold.scope)
+			}
+		}
+
+		if usage, err := store.GetUserUsageForCoupon(ctx, "u1", coupon.ID); usage != 1 || err != nil {
+			t.Errorf("GetUserUsageForCoupon returned %d, %v; want 1, nil", usage, err)
+		}
+		wantTotalUsage(t, store, coupon, 2)
+	})
+}
+
+func TestCouponUsagesAreReportedByCoupon(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		used, unused, deleted := newCoupon("USED"), newCoupon("UNUSED"), newCoupon("DELETED")
+		createCoupons(t, store, used, unused, deleted)
+		createHolds(t, store, newHold(used, "u1"), newHold(used, "u2"), newHold(used, "u1"), newHold(deleted, "u1"))
+		if _, err := store.DeleteCoupon(ctx, deleted.ID); err != nil {
+			t.Fatalf("failed to delete coupon: %v", err)
+		}
+
+		usages, err := store.GetCouponUsages(ctx, "u1", []string{used.ID, unused.ID, deleted.ID, uuid.New().String()})
+		if err != nil {
+			t.Fatalf("GetCouponUsages failed: %v", err)
+		}
+		want := map[string]database.CouponUsage{
+			used.ID:   {TotalUsage: 3, UserUsage: 2},
+			unused.ID: {TotalUsage: 0, UserUsage: 0},
+		}
+		if !maps.Equal(usages, want) {
+			t.Errorf("GetCouponUsages returned %v, want %v", usages, want)
+		}
+	})
+}
+
+func TestOneTimeCouponsAreHeldOnce(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		// Coupons created before usage types were enforced may have no total usage limit
+		coupon := newCoupon("ONCE", func(c *models.Coupon) { c.UsageType = models.UsageTypeOneTime })
+		createCoupons(t, store, coupon)
+		createHolds(t, store, newHold(coupon, "u1"))
+
+		if err := store.CreateRedemption(context.Background(), newHold(coupon, "u2")); !errors.Is(err, database.ErrUsageLimitReached) {
+			t.Errorf("holding a one_time coupon twice returned %v, want a usage limit error", err)
+		}
+	})
+}
+
+func TestStackHoldsAreAllOrNothing(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		open := newCoupon("OPEN")
+		full := newCoupon("FULL", func(c *models.Coupon) { c.MaxTotalUsage = 1 })
+		createCoupons(t, store, open, full)
+		createHolds(t, store, newHold(full, "u2"))
+
+		openHold := newHold(open, "u1")
+		if err := store.CreateRedemptions(ctx, []*models.CouponRedemption{openHold, newHold(full, "u1")}); !errors.Is(err, database.ErrUsageLimitReached) {
+			t.Fatalf("holding a stack with a used up coupon returned %v, want a usage limit error", err)
+		}
+		if redemption, err := store.GetRedemption(ctx, openHold.ID); redemption != nil || err != nil {
+			t.Errorf("the stack's other hold was placed: GetRedemption returned %+v, %v", redemption, err)
+		}
+		wantTotalUsage(t, store, open, 0)
+	})
+}
+
+func TestRedemptionsAreCommittedOrReleasedOnce(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		coupon := newCoupon("HELD")
+		createCoupons(t, store, coupon)
+		committed, released := newHold(coupon, "u1"), newHold(coupon, "u1")
+		if err := store.CreateRedemptions(ctx, []*models.CouponRedemption{committed, released}); err != nil {
+			t.Fatalf("failed to hold coupon: %v", err)
+		}
+
+		if got, err := store.CommitRedemption(ctx, committed.ID, now); err != nil || got == nil || got.Status != models.RedemptionStatusCommitted {
+			t.Errorf("CommitRedemption returned %+v, %v; want a committed redemption", got, err)
+		}
+		if _, err := store.CommitRedemption(ctx, committed.ID, now); !errors.Is(err, database.ErrRedemptionNotHeld) {
+			t.Errorf("committing twice returned %v, want ErrRedemptionNotHeld", err)
+		}
+		if _, err := store.ReleaseRedemption(ctx, committed.ID); !errors.Is(err, database.ErrRedemptionNotHeld) {
+			t.Errorf("releasing a committed redemption returned %v, want ErrRedemptionNotHeld", err)
+		}
+
+		if got, err := store.ReleaseRedemption(ctx, released.ID); err != nil || got == nil || got.Status != models.RedemptionStatusReleased {
+			t.Errorf("ReleaseRedemption returned %+v, %v; want a released redemption", got, err)
+		}
+		if _, err := store.ReleaseRedemption(ctx, released.ID); !errors.Is(err, database.ErrRedemptionNotHeld) {
+			t.Errorf("releasing twice returned %v, want ErrRedemptionNotHeld", err)
+		}
+
+		if got, err := store.GetRedemption(ctx, released.ID); err != nil || got == nil || got.Status != models.RedemptionStatusReleased {
+			t.Errorf("GetRedemption returned %+v, %v; want a released redemption", got, err)
+		}
+		if usage, err := store.GetUserUsageForCoupon(ctx, "u1", coupon.ID); usage != 1 || err != nil {
+			t.Errorf("GetUserUsageForCoupon returned %d, %v; want 1, nil", usage, err)
+		}
+		wantTotalUsage(t, store, coupon, 1)
+	})
+}
+
+func TestHoldsForTheSameOrderArePlacedOnce(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		coupon, other := newCoupon("ORDERED"), newCoupon("OTHER")
+		createCoupons(t, store, coupon, other)
+		first := newHold(coupon, "u1")
+		createHolds(t, store, first)
+
+		// A retry of the order, alone and in a stack with another coupon, gets the same hold
+		retry, otherHold := newHold(coupon, "u1"), newHold(other, "u1")
+		retry.OrderID, otherHold.OrderID = first.OrderID, first.OrderID
+		if err := store.CreateRedemptions(ctx, []*models.CouponRedemption{retry, otherHold}); err != nil {
+			t.Fatalf("failed to hold the order again: %v", err)
+		}
+		if retry.ID != first.ID {
+			t.Errorf("holding the order again placed hold %s, want the first hold %s", retry.ID, first.ID)
+		}
+		if got, err := store.GetRedemption(ctx, otherHold.ID); err != nil || got == nil {
+			t.Errorf("the other coupon's hold was not placed: GetRedemption returned %+v, %v", got, err)
+		}
+		wantTotalUsage(t, store, coupon, 1)
+
+		// Once the hold is given back, the order can hold the coupon again
+		if _, err := store.ReleaseRedemption(ctx, first.ID); err != nil {
+			t.Fatalf("failed to release hold: %v", err)
+		}
+		again := newHold(coupon, "u1")
+		again.OrderID = first.OrderID
+		if err := store.CreateRedemption(ctx, again); err != nil || again.ID == first.ID {
+			t.Errorf("holding a released order again returned hold %s, %v; want a new hold", again.ID, err)
+		}
+	})
+}
+
+func TestExpiredHoldsGiveTheirUsageBack(t *testing.T) {
+	eachStore(t, func(t *testing.T, store database.CouponStorage) {
+		ctx := context.Background()
+		coupon := newCoupon("EXPIRING")
+		createCoupons(t, store, coupon)
+		late, stale, fresh := newHold(coupon, "u1"), newHold(coupon, "u1"), newHold(coupon, "u2")
+		fresh.ExpiresAt = now.Add(3 * time.Hour)
+		if err := store.CreateRedemptions(ctx, []*models.CouponRedemption{late, stale, fresh}); err != nil {
+			t.Fatalf("failed to hold coupon: %v", err)
+		}
+
+		later := now.Add(2 * time.Hour)
+		if got, err := store.CommitRedemption(ctx, late.ID, later); !errors.Is(err, database.ErrRedemptionNotHeld) || got == nil || got.Status != models.RedemptionStatusExpired {
+			t.Errorf("committing an expired hold returned %+v, %v; want an expired redemption and ErrRedemptionNotHeld", got, err)
+		}
+		expired, err := store.ExpireRedemptions(ctx, later)
+		if err != nil || len(expired) != 1 || expired[0].ID != stale.ID || expired[0].Status != models.RedemptionStatusExpired {
+			t.Errorf("ExpireRedemptions returned %+v, %v; want the stale hold, expired", expired, err)
+		}
+		if expired, err := store.ExpireRedemptions(ctx, later); len(expired) != 0 || err != nil {
+			t.Errorf("expiring again returned %+v, %v; want none", expired, err)
+		}
+
+		if usage, err := store.GetUserUsageForCoupon(ctx, "u1", coupon.ID); usage != 0 || err != nil {
+			t.Errorf("GetUserUsageForCoupon returned %d, %v; want 0, nil", usage, err)
+		}
+		wantTotalUsage(t, store, coupon, 1)
+	})
+}
@@ -0,0 +1,804 @@
+package database
+
+import (
+	"context"
+	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SQLStore implements the CouponStorage interface on a SQL database through GORM. It supports SQLite and
+// PostgreSQL, and uses the same queries for both.
+type SQLStore struct {
+	db *gorm.DB
+	// Whether usage updates lock the rows they read with SELECT ... FOR UPDATE. SQLite has no row locks and
+	// takes the write lock for the whole database when a transaction begins instead.
+	rowLocks bool
+}
+
+// Open opens the database a DSN points to: PostgreSQL for a postgres:// or postgresql:// URL, and otherwise
+// the SQLite database at that path.
+func Open(dsn string) (*gorm.DB, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return OpenPostgres(dsn)
+	}
+	return OpenSQLite(dsn)
+}
+
+// NewSQLStore creates a new instance of SQLStore for a database opened with Open, OpenSQLite or OpenPostgres.
+func NewSQLStore(db *gorm.DB) *SQLStore {
+	return &SQLStore{db: db, rowLocks: db.Dialector.Name() == "postgres"}
+}
+
+// Close closes the database connection.
+func (s *SQLStore) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	return sqlDB.Close()
+}
+
+// CreateCoupon inserts a new coupon into the database.
+func (s *SQLStore) CreateCoupon(ctx context.Context, coupon *models.Coupon) error {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Ensure Medicine records exist and associate with coupon
+	for _, medicine := range coupon.MedicineIDs {
+		if err := tx.FirstOrCreate(&medicine, models.Medicine{ID: medicine.ID}).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to find or create medicine: %w", err)
+		}
+		// reload the medicine to get the full object in case it existed
+		if err := tx.First(&medicine, "id = ?", medicine.ID).Error; err != nil {
+			return fmt.Errorf("failed to find medicine after creation %w", err)
+		}
+		// Establish the many-to-many relationship
+		err := tx.Model(coupon).Association("MedicineIDs").Append(&medicine)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to associate medicine with coupon: %w", err)
+		}
+	}
+
+	// Ensure Category records exist and associate with coupon
+	for _, category := range coupon.Categories {
+		if err := tx.FirstOrCreate(&category, models.Category{ID: category.ID}).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to find or create category: %w", err)
+		}
+		// reload the category to get the full object in case it existed
+		if err := tx.First(&category, "id = ?", category.ID).Error; err != nil {
+			return fmt.Errorf("failed to find category after creation %w", err)
+		}
+		// Establish the many-to-many relationship
+		err := tx.Model(coupon).Association("Categories").Append(&category)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to associate category with coupon: %w", err)
+		}
+	}
+
+	// Create the coupon record. GORM inserts the column default instead of a zero value, and copies it into the
+	// coupon, so an inactive coupon is deactivated afterwards.
+	inactive := !coupon.IsActive
+	err := tx.Create(coupon).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrCouponCodeTaken
+		}
+		return fmt.Errorf("failed to create coupon: %w", err)
+	}
+	if inactive {
+		if err := tx.Model(coupon).Update("is_active", false).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to deactivate coupon: %w", err)
+		}
+	}
+
+	// Commit the transaction
+	err = tx.Commit().Error
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetCouponByID retrieves a coupon by its ID, along with its medicine and category associations.
+func (s *SQLStore) GetCouponByID(ctx context.Context, couponID string) (*models.Coupon, error) {
+	var coupon models.Coupon
+	err := preloadAssociations(s.db.WithContext(ctx)).Where("id = ?", couponID).First(&coupon).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil // Coupon not found is not an error in this context
+		}
+		return nil, err
+	}
+
+	return &coupon, nil
+}
+
+// ListCoupons retrieves one page of the coupons matching the filter, newest first.
+func (s *SQLStore) ListCoupons(ctx context.Context, filter CouponFilter) ([]models.Coupon, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.Coupon{})
+
+	switch filter.Status {
+	case CouponStatusActive:
+		query = query.Where("coupons.is_active = ? AND coupons.expiry_date > ?", true, filter.Now)
+	case CouponStatusInactive:
+		query = query.Where("coupons.is_active = ?", false)
+	case CouponStatusExpired:
+		query = query.Where("coupons.expiry_date <= ?", filter.Now)
+	}
+	if filter.UsageType != "" {
+		query = query.Where("coupons.usage_type = ?", filter.UsageType)
+	}
+	if filter.ExpiresAfter != nil {
+		query = query.Where("coupons.expiry_date >= ?", *filter.ExpiresAfter)
+	}
+	if filter.ExpiresBefore != nil {
+		query = query.Where("coupons.expiry_date <= ?", *filter.ExpiresBefore)
+	}
+	if filter.MedicineID != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM coupon_medicine_ids WHERE coupon_id = coupons.id AND medicine_id = ?)", filter.MedicineID)
+	}
+	if filter.Category != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM coupon_categories WHERE coupon_id = coupons.id AND category_id = ?)", filter.Category)
+	}
+	// Share the filters between the count and the page query
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count coupons: %w", err)
+	}
+
+	var coupons []models.Coupon
+	err := preloadAssociations(query).
+		Order("coupons.created_at DESC, coupons.id").
+		Offset(filter.Offset).
+		Limit(filter.Limit).
+		Find(&coupons).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list coupons: %w", err)
+	}
+	return coupons, total, nil
+}
+
+// UpdateCoupon saves a coupon's settings and replaces its medicine and category associations within a transaction.
+// Usage counters are left untouched so that concurrent redemptions are not overwritten.
+func (s *SQLStore) UpdateCoupon(ctx context.Context, coupon *models.Coupon) error {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err := tx.Model(&models.Coupon{}).Where("id = ?", coupon.ID).Updates(map[string]interface{}{
+		"coupon_code":             coupon.CouponCode,
+		"expiry_date":             coupon.ExpiryDate,
+		"usage_type":              coupon.UsageType,
+		"min_order_amount":        coupon.MinOrderValue.Amount,
+		"min_order_currency":      coupon.MinOrderValue.Currency,
+		"valid_time_window_start": coupon.ValidTimeWindowStart,
+		"valid_time_window_end":   coupon.ValidTimeWindowEnd,
+		"recurring_days":          coupon.Recurrence.Days,
+		"recurring_weeks":         coupon.Recurrence.Weeks,
+		"recurring_start_minute":  coupon.Recurrence.StartMinute,
+		"recurring_end_minute":    coupon.Recurrence.EndMinute,
+		"terms_and_conditions":    coupon.TermsAndConditions,
+		"eligibility_rule":        coupon.EligibilityRule,
+		"discount_type":           coupon.DiscountType,
+		"discount_amount":         coupon.DiscountAmount.Amount,
+		"discount_currency":       coupon.DiscountAmount.Currency,
+		"discount_percent":        coupon.DiscountPercent,
+		"buy_quantity":            coupon.BuyQuantity,
+		"get_quantity":            coupon.GetQuantity,
+		"max_discount_amount":     coupon.MaxDiscountAmount.Amount,
+		"max_discount_currency":   coupon.MaxDiscountAmount.Currency,
+		"max_usage_per_user":      coupon.MaxUsagePerUser,
+		"max_total_usage":         coupon.MaxTotalUsage,
+		"is_active":               coupon.IsActive,
+		"stackable":               coupon.Stackable,
+		"exclusivity_group":       coupon.ExclusivityGroup,
+		"priority":                coupon.Priority,
+		"updated_at":              coupon.UpdatedAt,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrCouponCodeTaken
+		}
+		return fmt.Errorf("failed to update coupon: %w", err)
+	}
+
+	// Replace the associations, creating any medicine or category that does not exist yet
+	associations := []struct {
+		name   string
+		values interface{}
+	}{
+		{"MedicineIDs", coupon.MedicineIDs},
+		{"Categories", coupon.Categories},
+		{"TriggerMedicines", coupon.TriggerMedicines},
+		{"TriggerCategories", coupon.TriggerCategories},
+		{"RewardMedicines", coupon.RewardMedicines},
+		{"RewardCategories", coupon.RewardCategories},
+		{"ExcludedMedicines", coupon.ExcludedMedicines},
+		{"ExcludedCategories", coupon.ExcludedCategories},
+	}
+	for _, association := range associations {
+		if err := tx.Model(coupon).Association(association.name).Replace(association.values); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to replace coupon %s: %w", association.name, err)
+		}
+	}
+
+	// Tiers belong to a single coupon, so the old ones are deleted rather than detached
+	if err := tx.Where("coupon_id = ?", coupon.ID).Delete(&models.CouponTier{}).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete coupon tiers: %w", err)
+	}
+	for i := range coupon.Tiers {
+		coupon.Tiers[i].ID = 0
+		coupon.Tiers[i].CouponID = coupon.ID
+	}
+	if len(coupon.Tiers) > 0 {
+		if err := tx.Create(&coupon.Tiers).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to create coupon tiers: %w", err)
+		}
+	}
+
+	// Commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// DeleteCoupon soft-deletes a coupon. Its redemption history is kept.
+func (s *SQLStore) DeleteCoupon(ctx context.Context, couponID string) (bool, error) {
+	result := s.db.WithContext(ctx).Where("id = ?", couponID).Delete(&models.Coupon{})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to delete coupon: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetCouponByCode retrieves a coupon by its coupon code, along with its medicine and category associations.
+func (s *SQLStore) GetCouponByCode(ctx context.Context, couponCode string) (*models.Coupon, error) {
+	var coupon models.Coupon
+	err := preloadAssociations(s.db.WithContext(ctx)).Where("coupon_code = ?", couponCode).First(&coupon).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil // Coupon not found is not an error in this context
+		}
+		return nil, err
+	}
+
+	return &coupon, nil
+}
+
+// CreateRedemption places a coupon hold and counts it against the coupon's usage within a transaction.
+func (s *SQLStore) CreateRedemption(ctx context.Context, redemption *models.CouponRedemption) error {
+	return s.CreateRedemptions(ctx, []*models.CouponRedemption{redemption})
+}
+
+// CreateRedemptions places several coupon holds within a single transaction, so either all of them are
+// placed or none are.
+func (s *SQLStore) CreateRedemptions(ctx context.Context, redemptions []*models.CouponRedemption) error {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	couponIDs := make([]string, 0, len(redemptions))
+	for _, redemption := range redemptions {
+		couponIDs = append(couponIDs, redemption.CouponID)
+	}
+	if err := s.lockCoupons(tx, couponIDs...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, redemption := range redemptions {
+		// A retry for the same order gets the hold already placed, rather than taking another use
+		existing, err := findActiveRedemption(tx, redemption)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if existing != nil {
+			*redemption = *existing
+			continue
+		}
+
+		if err := incrementCouponUsage(tx, redemption.CouponID, redemption.UserID); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Create(redemption).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to create redemption: %w", err)
+		}
+	}
+
+	// Commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetRedemption retrieves a redemption by its ID.
+func (s *SQLStore) GetRedemption(ctx context.Context, redemptionID string) (*models.CouponRedemption, error) {
+	var redemption models.CouponRedemption
+	err := s.db.WithContext(ctx).Where("id = ?", redemptionID).First(&redemption).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil // Redemption not found is not an error in this context
+		}
+		return nil, err
+	}
+
+	return &redemption, nil
+}
+
+// CommitRedemption confirms a held redemption. A hold whose TTL has already passed is expired instead.
+func (s *SQLStore) CommitRedemption(ctx context.Context, redemptionID string, now time.Time) (*models.CouponRedemption, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var redemption models.CouponRedemption
+	err := tx.Where("id = ?", redemptionID).First(&redemption).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get redemption: %w", err)
+	}
+
+	status := models.RedemptionStatusCommitted
+	if now.After(redemption.ExpiresAt) {
+		status = models.RedemptionStatusExpired
+	}
+
+	// The coupon is locked before the redemption, as ReleaseRedemption and ExpireRedemptions do, so that they
+	// cannot deadlock
+	if err := s.lockCoupons(tx, redemption.CouponID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	held, err := transitionHeldRedemption(tx, &redemption, status, now)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if !held {
+		tx.Rollback()
+		return &redemption, ErrRedemptionNotHeld
+	}
+
+	if status == models.RedemptionStatusExpired {
+		if err := decrementCouponUsage(tx, redemption.CouponID, redemption.UserID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	// Commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if status == models.RedemptionStatusExpired {
+		return &redemption, ErrRedemptionNotHeld
+	}
+	return &redemption, nil
+}
+
+// ReleaseRedemption cancels a held redemption and gives its usage back to the coupon.
+func (s *SQLStore) ReleaseRedemption(ctx context.Context, redemptionID string) (*models.CouponRedemption, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var redemption models.CouponRedemption
+	err := tx.Where("id = ?", redemptionID).First(&redemption).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get redemption: %w", err)
+	}
+
+	if err := s.lockCoupons(tx, redemption.CouponID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	held, err := transitionHeldRedemption(tx, &redemption, models.RedemptionStatusReleased, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if !held {
+		tx.Rollback()
+		return &redemption, ErrRedemptionNotHeld
+	}
+
+	if err := decrementCouponUsage(tx, redemption.CouponID, redemption.UserID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &redemption, nil
+}
+
+// ExpireRedemptions marks every hold whose TTL has passed as expired and gives its usage back to the coupon.
+func (s *SQLStore) ExpireRedemptions(ctx context.Context, now time.Time) ([]models.CouponRedemption, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var stale []models.CouponRedemption
+	err := tx.Where("status = ? AND expires_at < ?", models.RedemptionStatusHeld, now).Find(&stale).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to find stale redemptions: %w", err)
+	}
+
+	couponIDs := make([]string, 0, len(stale))
+	for _, redemption := range stale {
+		couponIDs = append(couponIDs, redemption.CouponID)
+	}
+	if err := s.lockCoupons(tx, couponIDs...); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	expired := make([]models.CouponRedemption, 0, len(stale))
+	for i := range stale {
+		held, err := transitionHeldRedemption(tx, &stale[i], models.RedemptionStatusExpired, now)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if !held {
+			continue
+		}
+		if err := decrementCouponUsage(tx, stale[i].CouponID, stale[i].UserID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		expired = append(expired, stale[i])
+	}
+
+	// Commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return expired, nil
+}
+
+// transitionHeldRedemption moves a redemption out of the held status. It reports false when the
+// redemption had already left the held status, so concurrent commits and releases cannot both win.
+func transitionHeldRedemption(tx *gorm.DB, redemption *models.CouponRedemption, status string, now time.Time) (bool, error) {
+	result := tx.Model(&models.CouponRedemption{}).
+		Where("id = ? AND status = ?", redemption.ID, models.RedemptionStatusHeld).
+		Updates(map[string]interface{}{"status": status, "updated_at": now})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update redemption status: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	redemption.Status = status
+	redemption.UpdatedAt = now
+	return true, nil
+}
+
+// findActiveRedemption returns the redemption of the same coupon by the same user for the same order as a new
+// one, if it is committed or still held when the new one is created, or nil if there is none. The coupon must
+// be locked, so that concurrent holds for the order cannot both miss each other.
+func findActiveRedemption(tx *gorm.DB, redemption *models.CouponRedemption) (*models.CouponRedemption, error) {
+	var existing []models.CouponRedemption
+	err := tx.Where("user_id = ? AND coupon_id = ? AND order_id = ?", redemption.UserID, redemption.CouponID, redemption.OrderID).
+		Where("status = ? OR (status = ? AND expires_at > ?)", models.RedemptionStatusCommitted, models.RedemptionStatusHeld, redemption.CreatedAt).
+		Order("created_at").Limit(1).Find(&existing).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find redemption for order: %w", err)
+	}
+	if len(existing) == 0 {
+		return nil, nil
+	}
+	return &existing[0], nil
+}
+
+// lockCoupons locks the rows of the coupons whose usage a transaction is about to change, until the transaction
+// ends. They are locked in ID order, so transactions that lock several coupons cannot deadlock. On SQLite the
+// transaction already holds the database's write lock, so there is nothing to do.
+func (s *SQLStore) lockCoupons(tx *gorm.DB, couponIDs ...string) error {
+	if !s.rowLocks || len(couponIDs) == 0 {
+		return nil
+	}
+	ids := slices.Compact(slices.Sorted(slices.Values(couponIDs)))
+
+	var locked []models.Coupon
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id IN ?", ids).Order("id").Find(&locked).Error
+	if err != nil {
+		return fmt.Errorf("failed to lock coupons: %w", err)
+	}
+	return nil
+}
+
+// incrementCouponUsage counts one more use of the coupon, overall and for the user. Each limit is
+// checked by the same statement that increments it, so concurrent redemptions cannot overshoot a cap
+// based on a stale read.
+func incrementCouponUsage(tx *gorm.DB, couponID string, userID string) error {
+	result := tx.Exec(`INSERT INTO user_coupon_usages (user_id, coupon_id, times_used) VALUES (?, ?, 1)
+		ON CONFLICT (user_id, coupon_id) DO UPDATE SET times_used = user_coupon_usages.times_used + 1
+		WHERE EXISTS (SELECT 1 FROM coupons WHERE coupons.id = user_coupon_usages.coupon_id
+			AND (coupons.max_usage_per_user = 0 OR user_coupon_usages.times_used < coupons.max_usage_per_user))`,
+		userID, couponID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user coupon usage: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return &UsageLimitError{CouponID: couponID, Scope: UsageLimitPerUser}
+	}
+
+	result = tx.Model(&models.Coupon{}).
+		Where("id = ? AND (max_total_usage = 0 OR current_total_usage < max_total_usage)", couponID).
+		Where("usage_type <> ? OR current_total_usage = 0", models.UsageTypeOneTime).
+		Update("current_total_usage", gorm.Expr("current_total_usage + ?", 1))
+	if result.Error != nil {
+		return fmt.Errorf("failed to increment current_total_usage: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return &UsageLimitError{CouponID: couponID, Scope: UsageLimitTotal}
+	}
+	return nil
+}
+
+// decrementCouponUsage gives one use of the coupon back, overall and for the user.
+func decrementCouponUsage(tx *gorm.DB, couponID string, userID string) error {
+	err := tx.Model(&models.Coupon{}).Where("id = ? AND current_total_usage > 0", couponID).
+		Update("current_total_usage", gorm.Expr("current_total_usage - ?", 1)).Error
+	if err != nil {
+		return fmt.Errorf("failed to decrement current_total_usage: %w", err)
+	}
+
+	err = tx.Model(&models.UserCouponUsage{}).Where("user_id = ? AND coupon_id = ? AND times_used > 0", userID, couponID).
+		Update("times_used", gorm.Expr("times_used - ?", 1)).Error
+	if err != nil {
+		return fmt.Errorf("failed to decrement user coupon usage: %w", err)
+	}
+	return nil
+}
+
+// GetApplicableCoupons retrieves the coupons applicable to a cart, along with their medicine and category associations.
+func (s *SQLStore) GetApplicableCoupons(ctx context.Context, timestamp time.Time, orderTotal money.Money, medicineIDs []string, categoryIDs []string, userID string) ([]models.Coupon, error) {
+	var coupons []models.Coupon // Explicitly declare coupons
+	query := s.couponsForCartQuery(ctx, timestamp, medicineIDs, categoryIDs, userID).
+		Where("coupons.min_order_amount <= ?", orderTotal.Amount)
+
+	// Associations are loaded with one batched query per association rather than one per coupon
+	err := preloadAssociations(query).Find(&coupons).Error
+	if err != nil {
+		return nil, err
+	}
+	return coupons, nil
+}
+
+// GetNearMissCoupons retrieves the coupons that would apply to a cart if the order total reached their minimum
+// order value, closest first.
+func (s *SQLStore) GetNearMissCoupons(ctx context.Context, timestamp time.Time, orderTotal money.Money, medicineIDs []string, categoryIDs []string, userID string, limit int) ([]models.Coupon, error) {
+	var coupons []models.Coupon
+	query := s.couponsForCartQuery(ctx, timestamp, medicineIDs, categoryIDs, userID).
+		Where("coupons.min_order_amount > ?", orderTotal.Amount).
+		Order("coupons.min_order_amount, coupons.coupon_code").
+		Limit(limit)
+
+	err := preloadAssociations(query).Find(&coupons).Error
+	if err != nil {
+		return nil, err
+	}
+	return coupons, nil
+}
+
+// couponsForCartQuery selects the coupons that are usable at the timestamp, including their recurring windows in
+// the timestamp's location, meet the requirements of their usage type, have uses left for the user and
+// can discount at least one cart line: a line that neither the coupon nor the global exclusion list excludes
+// and that the coupon targets, if it targets anything. medicineIDs[i] and categoryIDs[i] describe line i.
+// The minimum order value is left to the caller.
+func (s *SQLStore) couponsForCartQuery(ctx context.Context, timestamp time.Time, medicineIDs []string, categoryIDs []string, userID string) *gorm.DB {
+	query := s.db.WithContext(ctx).Model(&models.Coupon{}) // Start with the Coupon model
+	// Times are stored in UTC, so they are compared in UTC, while recurring windows use the local time
+	now := timestamp.UTC()
+	minute := timestamp.Hour()*60 + timestamp.Minute()
+	// Basic filtering conditions
+	query = query.
+		Where("coupons.is_active = ?", true).
+		Where("coupons.expiry_date > ?", now).
+		Where("coupons.current_total_usage < coupons.max_total_usage OR coupons.max_total_usage = 0").
+		Where("coupons.usage_type <> ? OR coupons.current_total_usage = 0", models.UsageTypeOneTime).
+		Where(`coupons.usage_type <> ? OR coupons.valid_time_window_start IS NOT NULL OR coupons.valid_time_window_end IS NOT NULL
+			OR coupons.recurring_days <> 0 OR coupons.recurring_weeks <> 0 OR coupons.recurring_start_minute <> 0 OR coupons.recurring_end_minute <> 0`,
+			models.UsageTypeTimeBased).
+		Where("(coupons.valid_time_window_start IS NULL OR coupons.valid_time_window_start <= ?)", now).
+		Where("(coupons.valid_time_window_end IS NULL OR coupons.valid_time_window_end >= ?)", now).
+		Where("(coupons.recurring_days = 0 OR (coupons.recurring_days & ?) <> 0)", 1<<int(timestamp.Weekday())).
+		Where("(coupons.recurring_weeks = 0 OR (coupons.recurring_weeks & ?) <> 0)", 1<<(models.WeekOfMonth(timestamp)-1)).
+		Where(`(coupons.recurring_start_minute = coupons.recurring_end_minute
+			OR coupons.recurring_start_minute < coupons.recurring_end_minute AND coupons.recurring_start_minute <= ? AND ? < coupons.recurring_end_minute
+			OR coupons.recurring_start_minute > coupons.recurring_end_minute AND (coupons.recurring_start_minute <= ? OR ? < coupons.recurring_end_minute))`,
+			minute, minute, minute, minute).
+		// Filter out coupons that the user has already used up to the maximum allowed limit
+		Joins("LEFT JOIN user_coupon_usages ON coupons.id = user_coupon_usages.coupon_id AND user_coupon_usages.user_id = ?", userID).
+		Where("user_coupon_usages.times_used < coupons.max_usage_per_user OR coupons.max_usage_per_user = 0 OR user_coupon_usages.user_id IS NULL") // Include coupons the user hasn't used yet or have no per-user limit
+
+	// Condition for general coupons, which apply to any line
+	untargeted := "NOT EXISTS (SELECT 1 FROM coupon_medicine_ids WHERE coupon_id = coupons.id) AND NOT EXISTS (SELECT 1 FROM coupon_categories WHERE coupon_id = coupons.id)"
+	if len(medicineIDs) == 0 {
+		// Without lines there is nothing to exclude or target, so only general coupons apply
+		return query.Where(untargeted)
+	}
+
+	// One row per cart line, so a line's medicine and category are checked together
+	lines := make([]string, 0, len(medicineIDs))
+	args := make([]interface{}, 0, 2*len(medicineIDs))
+	for i := range medicineIDs {
+		// PostgreSQL cannot infer the type of a bare parameter in a select list
+		lines = append(lines, "SELECT CAST(? AS TEXT) AS medicine_id, CAST(? AS TEXT) AS category_id")
+		args = append(args, medicineIDs[i], categoryIDs[i])
+	}
+	cartLines := s.db.Raw(strings.Join(lines, " UNION ALL "), args...)
+
+	return query.Where("EXISTS (SELECT 1 FROM (?) AS cart_lines WHERE "+
+		// The line is not on the global exclusion list
+		"NOT EXISTS (SELECT 1 FROM discount_exclusions WHERE (discount_exclusions.kind = ? AND discount_exclusions.item_id = cart_lines.medicine_id) OR (discount_exclusions.kind = ? AND discount_exclusions.item_id = cart_lines.category_id)) "+
+		// The coupon does not exclude the line
+		"AND NOT EXISTS (SELECT 1 FROM coupon_excluded_medicines WHERE coupon_id = coupons.id AND medicine_id = cart_lines.medicine_id) "+
+		"AND NOT EXISTS (SELECT 1 FROM coupon_excluded_categories WHERE coupon_id = coupons.id AND category_id = cart_lines.category_id) "+
+		// The coupon is general or targets the line
+		"AND (("+untargeted+") "+
+		"OR EXISTS (SELECT 1 FROM coupon_medicine_ids WHERE coupon_id = coupons.id AND medicine_id = cart_lines.medicine_id) "+
+		"OR EXISTS (SELECT 1 FROM coupon_categories WHERE coupon_id = coupons.id AND category_id = cart_lines.category_id)))",
+		cartLines,
+		models.ExclusionKindMedicine,
+		models.ExclusionKindCategory,
+	)
+}
+
+// ListExclusions retrieves the global exclusion list.
+func (s *SQLStore) ListExclusions(ctx context.Context) ([]models.DiscountExclusion, error) {
+	var exclusions []models.DiscountExclusion
+	err := s.db.WithContext(ctx).Order("kind, item_id").Find(&exclusions).Error
+	if err != nil {
+		return nil, err
+	}
+	return exclusions, nil
+}
+
+// CreateExclusion adds a medicine or category to the global exclusion list.
+func (s *SQLStore) CreateExclusion(ctx context.Context, exclusion *models.DiscountExclusion) error {
+	err := s.db.WithContext(ctx).Create(exclusion).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrExclusionExists
+		}
+		return fmt.Errorf("failed to create exclusion: %w", err)
+	}
+	return nil
+}
+
+// DeleteExclusion removes a medicine or category from the global exclusion list.
+func (s *SQLStore) DeleteExclusion(ctx context.Context, exclusionID string) (bool, error) {
+	result := s.db.WithContext(ctx).Where("id = ?", exclusionID).Delete(&models.DiscountExclusion{})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to delete exclusion: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// preloadAssociations makes a coupon query also load the coupons' medicine and category associations,
+// including the exclusions, the trigger and reward sets of buy-X-get-Y coupons, and the tiers of tiered coupons.
+func preloadAssociations(query *gorm.DB) *gorm.DB {
+	return query.Preload("MedicineIDs").Preload("Categories").
+		Preload("ExcludedMedicines").Preload("ExcludedCategories").
+		Preload("TriggerMedicines").Preload("TriggerCategories").
+		Preload("RewardMedicines").Preload("RewardCategories").
+		Preload("Tiers", func(db *gorm.DB) *gorm.DB { return db.Order("coupon_tiers.position") })
+}
+
+// GetUserUsageForCoupon retrieves the number of times a user has used a specific coupon.
+func (s *SQLStore) GetUserUsageForCoupon(ctx context.Context, userID string, couponID string) (int, error) {
+	var userUsage models.UserCouponUsage
+	err := s.db.WithContext(ctx).Where("user_id = ? AND coupon_id = ?", userID, couponID).First(&userUsage).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil // No usage record found, treat as zero usage
+		}
+		return 0, fmt.Errorf("failed to get user usage for coupon: %w", err)
+	}
+	return userUsage.TimesUsed, nil
+}
+
+// Number of coupon IDs looked up per query by GetCouponUsages, well under the databases' parameter limits.
+const couponUsageBatchSize = 500
+
+// GetCouponUsages retrieves the current usage of coupons and a user's usage of them.
+func (s *SQLStore) GetCouponUsages(ctx context.Context, userID string, couponIDs []string) (map[string]CouponUsage, error) {
+	usages := make(map[string]CouponUsage, len(couponIDs))
+	for batch := range slices.Chunk(couponIDs, couponUsageBatchSize) {
+		var rows []struct {
+			ID                string
+			CurrentTotalUsage int
+			TimesUsed         int
+		}
+		err := s.db.WithContext(ctx).Model(&models.Coupon{}).
+			Select("coupons.id, coupons.current_total_usage, COALESCE(user_coupon_usages.times_used, 0) AS times_used").
+			Joins("LEFT JOIN user_coupon_usages ON coupons.id = user_coupon_usages.coupon_id AND user_coupon_usages.user_id = ?", userID).
+			Where("coupons.id IN ?", batch).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to get coupon usages: %w", err)
+		}
+		for _, row := range rows {
+			usages[row.ID] = CouponUsage{TotalUsage: row.CurrentTotalUsage, UserUsage: row.TimesUsed}
+		}
+	}
+	return usages, nil
+}
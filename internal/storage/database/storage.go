@@ -3,13 +3,91 @@ package database
 import (
 	"context"
 	"coupon-system/internal/models"
+	"coupon-system/internal/money"
+	"errors"
 	"time"
 )
 
+var (
+	// ErrRedemptionNotHeld is returned when a redemption is committed or released after it has left the held status.
+	ErrRedemptionNotHeld = errors.New("redemption is not held")
+	// ErrCouponCodeTaken is returned when a coupon is created or renamed with a code another coupon already uses.
+	ErrCouponCodeTaken = errors.New("coupon code already exists")
+	// ErrExclusionExists is returned when a medicine or category is added to the global exclusion list twice.
+	ErrExclusionExists = errors.New("exclusion already exists")
+	// ErrUsageLimitReached matches every UsageLimitError with errors.Is.
+	ErrUsageLimitReached = errors.New("coupon usage limit reached")
+)
+
+// Usage limit scopes reported by UsageLimitError.
+const (
+	UsageLimitTotal   = "total"
+	UsageLimitPerUser = "per_user"
+)
+
+// UsageLimitError is returned when counting one more use would take a coupon past one of its usage limits.
+type UsageLimitError struct {
+	CouponID string
+	Scope    string // UsageLimitTotal or UsageLimitPerUser
+}
+
+func (e *UsageLimitError) Error() string {
+	if e.Scope == UsageLimitPerUser {
+		return "maximum usage per user reached"
+	}
+	return "maximum total usage reached"
+}
+
+func (e *UsageLimitError) Is(target error) bool {
+	return target == ErrUsageLimitReached
+}
+
+// Coupon statuses accepted by CouponFilter.Status.
+const (
+	CouponStatusActive   = "active"   // Active and not yet expired
+	CouponStatusInactive = "inactive" // Deactivated by an admin
+	CouponStatusExpired  = "expired"  // Past its expiry date
+)
+
+// CouponFilter narrows down the coupons returned by ListCoupons. Zero-valued fields are ignored.
+type CouponFilter struct {
+	Status        string
+	UsageType     string
+	ExpiresAfter  *time.Time
+	ExpiresBefore *time.Time
+	MedicineID    string
+	Category      string
+	Now           time.Time // Reference time for the active and expired statuses
+	Offset        int
+	Limit         int
+}
+
+// CouponUsage is how many times a coupon is used or held, overall and by one user.
+type CouponUsage struct {
+	TotalUsage int
+	UserUsage  int
+}
+
 type CouponStorage interface {
 	CreateCoupon(ctx context.Context, coupon *models.Coupon) error
 	GetCouponByCode(ctx context.Context, couponCode string) (*models.Coupon, error)
-	UpdateCouponUsage(ctx context.Context, coupon *models.Coupon, userID string) error                                                                                     // Handles usage count and user-specific usage
-	GetApplicableCoupons(ctx context.Context, timestamp time.Time, orderTotal float64, medicineIDs []string, categoryIDs []string, userID string) ([]models.Coupon, error) // For finding applicable coupons
+	GetCouponByID(ctx context.Context, couponID string) (*models.Coupon, error)
+	ListCoupons(ctx context.Context, filter CouponFilter) ([]models.Coupon, int64, error)                                                                                               // Returns one page of coupons and the total number of matches
+	UpdateCoupon(ctx context.Context, coupon *models.Coupon) error                                                                                                                      // Saves the coupon's settings and replaces its associations; usage counters are left untouched
+	DeleteCoupon(ctx context.Context, couponID string) (bool, error)                                                                                                                    // Reports false when there was no coupon to delete
+	GetApplicableCoupons(ctx context.Context, timestamp time.Time, orderTotal money.Money, medicineIDs []string, categoryIDs []string, userID string) ([]models.Coupon, error)          // For finding applicable coupons; medicineIDs[i] and categoryIDs[i] describe the same cart line, and recurring windows are checked in the timestamp's location
+	GetNearMissCoupons(ctx context.Context, timestamp time.Time, orderTotal money.Money, medicineIDs []string, categoryIDs []string, userID string, limit int) ([]models.Coupon, error) // Coupons that only miss their minimum order value, closest first
+
+	ListExclusions(ctx context.Context) ([]models.DiscountExclusion, error)
+	CreateExclusion(ctx context.Context, exclusion *models.DiscountExclusion) error // Fails with ErrExclusionExists when the item is already excluded
+	DeleteExclusion(ctx context.Context, exclusionID string) (bool, error)          // Reports false when there was no exclusion to delete
 	GetUserUsageForCoupon(ctx context.Context, userID string, couponID string) (int, error)
+	GetCouponUsages(ctx context.Context, userID string, couponIDs []string) (map[string]CouponUsage, error) // By coupon ID; coupons that do not exist or are deleted are left out
+
+	CreateRedemption(ctx context.Context, redemption *models.CouponRedemption) error     // Places a hold and counts it against the coupon's usage, failing with a UsageLimitError when a limit is reached; if the user already has a committed or live hold of the coupon for the order, redemption is set to it instead
+	CreateRedemptions(ctx context.Context, redemptions []*models.CouponRedemption) error // Like CreateRedemption, but places either all of the holds or none of them
+	GetRedemption(ctx context.Context, redemptionID string) (*models.CouponRedemption, error)
+	CommitRedemption(ctx context.Context, redemptionID string, now time.Time) (*models.CouponRedemption, error)
+	ReleaseRedemption(ctx context.Context, redemptionID string) (*models.CouponRedemption, error) // Gives the held usage back to the coupon
+	ExpireRedemptions(ctx context.Context, now time.Time) ([]models.CouponRedemption, error)      // Releases every hold whose TTL has passed and returns them
 }
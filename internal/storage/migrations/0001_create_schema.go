@@ -0,0 +1,170 @@
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"coupon-system/internal/money"
+
+	"gorm.io/gorm"
+)
+
+// createSchema creates the tables of the coupon system as they were when migrations were introduced. It
+// also adopts databases that AutoMigrate set up before then: tables that exist are kept, missing columns
+// are added, and amounts are moved out of the legacy floating point columns.
+func createSchema(tx *gorm.DB) error {
+	type Medicine struct {
+		ID string `gorm:"primaryKey"`
+	}
+	type Category struct {
+		ID string `gorm:"primaryKey"`
+	}
+	type CouponTier struct {
+		ID                uint   `gorm:"primaryKey"`
+		CouponID          string `gorm:"column:coupon_id;index"`
+		Position          int    `gorm:"column:position"`
+		ThresholdAmount   int64  `gorm:"column:threshold_amount"`
+		ThresholdCurrency string `gorm:"column:threshold_currency"`
+		DiscountType      string `gorm:"column:discount_type"`
+		DiscountAmount    int64  `gorm:"column:discount_amount"`
+		DiscountCurrency  string `gorm:"column:discount_currency"`
+		DiscountPercent   int64  `gorm:"column:discount_percent"`
+	}
+	type Coupon struct {
+		ID                   string         `gorm:"primaryKey;column:id"`
+		CouponCode           string         `gorm:"unique;column:coupon_code"`
+		ExpiryDate           time.Time      `gorm:"column:expiry_date"`
+		UsageType            string         `gorm:"column:usage_type"`
+		MinOrderAmount       int64          `gorm:"column:min_order_amount"`
+		MinOrderCurrency     string         `gorm:"column:min_order_currency"`
+		ValidTimeWindowStart *time.Time     `gorm:"column:valid_time_window_start"`
+		ValidTimeWindowEnd   *time.Time     `gorm:"column:valid_time_window_end"`
+		RecurringDays        int            `gorm:"column:recurring_days;not null;default:0"`
+		RecurringWeeks       int            `gorm:"column:recurring_weeks;not null;default:0"`
+		RecurringStartMinute int            `gorm:"column:recurring_start_minute;not null;default:0"`
+		RecurringEndMinute   int            `gorm:"column:recurring_end_minute;not null;default:0"`
+		TermsAndConditions   string         `gorm:"column:terms_and_conditions"`
+		EligibilityRule      string         `gorm:"column:eligibility_rule"`
+		DiscountType         string         `gorm:"column:discount_type"`
+		DiscountAmount       int64          `gorm:"column:discount_amount"`
+		DiscountCurrency     string         `gorm:"column:discount_currency"`
+		DiscountPercent      int64          `gorm:"column:discount_percent"`
+		BuyQuantity          int            `gorm:"column:buy_quantity;not null;default:0"`
+		GetQuantity          int            `gorm:"column:get_quantity;not null;default:0"`
+		MaxDiscountAmount    int64          `gorm:"column:max_discount_amount"`
+		MaxDiscountCurrency  string         `gorm:"column:max_discount_currency"`
+		MaxUsagePerUser      int            `gorm:"column:max_usage_per_user"`
+		MaxTotalUsage        int            `gorm:"column:max_total_usage"`
+		CurrentTotalUsage    int            `gorm:"column:current_total_usage"`
+		IsActive             bool           `gorm:"column:is_active;not null;default:true"`
+		Stackable            bool           `gorm:"column:stackable;not null;default:false"`
+		ExclusivityGroup     string         `gorm:"column:exclusivity_group"`
+		Priority             int            `gorm:"column:priority;not null;default:0"`
+		CreatedAt            time.Time      `gorm:"column:created_at"`
+		UpdatedAt            time.Time      `gorm:"column:updated_at"`
+		DeletedAt            gorm.DeletedAt `gorm:"column:deleted_at;index"`
+		MedicineIDs          []Medicine     `gorm:"many2many:coupon_medicine_ids;"`
+		Categories           []Category     `gorm:"many2many:coupon_categories;"`
+		TriggerMedicines     []Medicine     `gorm:"many2many:coupon_trigger_medicines;"`
+		TriggerCategories    []Category     `gorm:"many2many:coupon_trigger_categories;"`
+		RewardMedicines      []Medicine     `gorm:"many2many:coupon_reward_medicines;"`
+		RewardCategories     []Category     `gorm:"many2many:coupon_reward_categories;"`
+		ExcludedMedicines    []Medicine     `gorm:"many2many:coupon_excluded_medicines;"`
+		ExcludedCategories   []Category     `gorm:"many2many:coupon_excluded_categories;"`
+		Tiers                []CouponTier   `gorm:"foreignKey:CouponID"`
+	}
+	type UserCouponUsage struct {
+		UserID    string `gorm:"primaryKey;column:user_id"`
+		CouponID  string `gorm:"primaryKey;column:coupon_id"`
+		TimesUsed int    `gorm:"column:times_used"`
+	}
+	type CouponRedemption struct {
+		ID                    string    `gorm:"primaryKey;column:id"`
+		CouponID              string    `gorm:"column:coupon_id;index"`
+		UserID                string    `gorm:"column:user_id;index"`
+		OrderID               string    `gorm:"column:order_id;index"`
+		CouponCode            string    `gorm:"column:coupon_code"`
+		Status                string    `gorm:"column:status;index"`
+		TotalDiscountAmount   int64     `gorm:"column:total_discount_amount"`
+		TotalDiscountCurrency string    `gorm:"column:total_discount_currency"`
+		ExpiresAt             time.Time `gorm:"column:expires_at;index"`
+		CreatedAt             time.Time `gorm:"column:created_at"`
+		UpdatedAt             time.Time `gorm:"column:updated_at"`
+	}
+	type DiscountExclusion struct {
+		ID        string    `gorm:"primaryKey;column:id"`
+		Kind      string    `gorm:"column:kind;uniqueIndex:idx_discount_exclusions_item"`
+		ItemID    string    `gorm:"column:item_id;uniqueIndex:idx_discount_exclusions_item"`
+		Reason    string    `gorm:"column:reason"`
+		CreatedAt time.Time `gorm:"column:created_at"`
+	}
+
+	err := tx.AutoMigrate(&Coupon{}, &CouponTier{}, &UserCouponUsage{}, &Medicine{}, &Category{}, &CouponRedemption{}, &DiscountExclusion{})
+	if err != nil {
+		return fmt.Errorf("failed to create tables: %w", err)
+	}
+	return migrateMoneyColumns(tx, &Coupon{}, &CouponRedemption{})
+}
+
+// dropSchema drops every table createSchema creates, with everything in them.
+func dropSchema(tx *gorm.DB) error {
+	err := tx.Migrator().DropTable(
+		"coupon_medicine_ids", "coupon_categories",
+		"coupon_trigger_medicines", "coupon_trigger_categories",
+		"coupon_reward_medicines", "coupon_reward_categories",
+		"coupon_excluded_medicines", "coupon_excluded_categories",
+		"coupon_tiers", "user_coupon_usages", "coupon_redemptions", "discount_exclusions",
+		"coupons", "medicines", "categories",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to drop tables: %w", err)
+	}
+	return nil
+}
+
+// migrateMoneyColumns moves amounts stored in the legacy floating point columns of coupons and redemptions
+// into the integer minor unit columns, then drops the legacy columns. Databases without the legacy columns
+// are left as they are. Legacy amounts were always in the default currency, which has two decimal digits,
+// and legacy percentages become basis points.
+func migrateMoneyColumns(tx *gorm.DB, coupons, redemptions interface{}) error {
+	migrator := tx.Migrator()
+
+	if migrator.HasColumn(coupons, "min_order_value") {
+		err := tx.Exec(`UPDATE coupons SET
+			min_order_amount = CAST(ROUND(COALESCE(min_order_value, 0) * 100) AS INTEGER),
+			min_order_currency = ?`, money.DefaultCurrency).Error
+		if err != nil {
+			return fmt.Errorf("failed to migrate min_order_value: %w", err)
+		}
+		if err := migrator.DropColumn(coupons, "min_order_value"); err != nil {
+			return fmt.Errorf("failed to drop min_order_value: %w", err)
+		}
+	}
+
+	if migrator.HasColumn(coupons, "discount_value") {
+		err := tx.Exec(`UPDATE coupons SET
+			discount_amount = CASE WHEN discount_type = 'fixed_amount' THEN CAST(ROUND(COALESCE(discount_value, 0) * 100) AS INTEGER) ELSE 0 END,
+			discount_currency = ?,
+			discount_percent = CASE WHEN discount_type = 'percentage' THEN CAST(ROUND(COALESCE(discount_value, 0) * 100) AS INTEGER) ELSE 0 END`,
+			money.DefaultCurrency).Error
+		if err != nil {
+			return fmt.Errorf("failed to migrate discount_value: %w", err)
+		}
+		if err := migrator.DropColumn(coupons, "discount_value"); err != nil {
+			return fmt.Errorf("failed to drop discount_value: %w", err)
+		}
+	}
+
+	if migrator.HasColumn(redemptions, "total_discount") {
+		err := tx.Exec(`UPDATE coupon_redemptions SET
+			total_discount_amount = CAST(ROUND(COALESCE(total_discount, 0) * 100) AS INTEGER),
+			total_discount_currency = ?`, money.DefaultCurrency).Error
+		if err != nil {
+			return fmt.Errorf("failed to migrate total_discount: %w", err)
+		}
+		if err := migrator.DropColumn(redemptions, "total_discount"); err != nil {
+			return fmt.Errorf("failed to drop total_discount: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+// Package migrations versions the schema of the SQL databases. Each Migration moves the schema up one version
+// and back down again, and the schema_migrations table records which ones a database has had applied. The
+// server refuses to start unless its database is at the latest version; cmd/migrate applies and rolls back
+// migrations.
+//
+// A migration describes the schema as it was at its version, not as the models are now, so that it does the
+// same thing whenever it runs. Changing a model therefore takes a new migration, and an applied migration is
+// never edited.
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"coupon-system/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Migration moves the schema from Version-1 to Version, and Down back again. Both run in a transaction
+// that also records the change in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// All holds every migration in order of version. A new migration is appended with the next version.
+var All = []Migration{
+	{Version: 1, Name: "create_schema", Up: createSchema, Down: dropSchema},
+}
+
+// ErrSchemaMismatch is returned when a database's schema is not the one the models expect.
+var ErrSchemaMismatch = errors.New("database schema does not match")
+
+// currentModels are the models the storage reads and writes, whose tables and columns the latest migration
+// must have created.
+var currentModels = []interface{}{
+	&models.Coupon{}, &models.CouponTier{}, &models.UserCouponUsage{}, &models.Medicine{}, &models.Category{},
+	&models.CouponRedemption{}, &models.DiscountExclusion{},
+}
+
+// schemaMigration is a row of schema_migrations, recording that a migration was applied.
+type schemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false;column:version"`
+	Name      string    `gorm:"column:name"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status describes a migration and whether a database has had it applied. Unknown migrations were applied
+// by a newer build and are not in All.
+type Status struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time // Nil if the migration is pending
+	Unknown   bool
+}
+
+// Latest returns the version the migrations bring a database to.
+func Latest() int {
+	return All[len(All)-1].Version
+}
+
+// Version returns the version of a database's schema: the highest applied migration, or 0 if none is.
+func Version(db *gorm.DB) (int, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		return 0, nil
+	}
+	return applied[len(applied)-1].Version, nil
+}
+
+// GetStatus returns the status of every migration in All, followed by any unknown migrations the database
+// has had applied.
+func GetStatus(db *gorm.DB) ([]Status, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	appliedAt := make(map[int]time.Time, len(applied))
+	for _, migration := range applied {
+		appliedAt[migration.Version] = migration.AppliedAt
+	}
+
+	statuses := make([]Status, 0, len(All))
+	known := make(map[int]bool, len(All))
+	for _, migration := range All {
+		known[migration.Version] = true
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if at, ok := appliedAt[migration.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	for _, migration := range applied {
+		if !known[migration.Version] {
+			at := migration.AppliedAt
+			statuses = append(statuses, Status{Version: migration.Version, Name: migration.Name, AppliedAt: &at, Unknown: true})
+		}
+	}
+	return statuses, nil
+}
+
+// Up applies every pending migration in order of version, each in its own transaction, and returns the
+// ones it applied. It stops at the first migration that fails, leaving the ones before it applied. Up does
+// nothing to a database that has had migrations applied that are not in All.
+func Up(db *gorm.DB) ([]Migration, error) {
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnown(applied); err != nil {
+		return nil, err
+	}
+	isApplied := make(map[int]bool, len(applied))
+	for _, migration := range applied {
+		isApplied[migration.Version] = true
+	}
+
+	var done []Migration
+	for _, migration := range All {
+		if isApplied[migration.Version] {
+			continue
+		}
+		if err := run(db, migration, migration.Up, true); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down rolls back the latest steps applied migrations, newest first, each in its own transaction, and
+// returns the ones it rolled back. It stops at the first migration that fails.
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnown(applied); err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]Migration, len(All))
+	for _, migration := range All {
+		byVersion[migration.Version] = migration
+	}
+
+	var done []Migration
+	for i := len(applied) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := byVersion[applied[i].Version]
+		if err := run(db, migration, migration.Down, false); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Check returns an error wrapping ErrSchemaMismatch unless a database is at the latest version and has
+// every table and column of the models, which catches models changed without a migration.
+func Check(db *gorm.DB) error {
+	version, err := Version(db)
+	if err != nil {
+		return err
+	}
+	if version != Latest() {
+		return fmt.Errorf("%w: database is at version %d, expected version %d", ErrSchemaMismatch, version, Latest())
+	}
+
+	migrator := db.Migrator()
+	for _, model := range currentModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return fmt.Errorf("failed to parse model: %w", err)
+		}
+		if !migrator.HasTable(stmt.Schema.Table) {
+			return fmt.Errorf("%w: table %s is missing", ErrSchemaMismatch, stmt.Schema.Table)
+		}
+		for _, column := range stmt.Schema.DBNames {
+			if !migrator.HasColumn(model, column) {
+				return fmt.Errorf("%w: column %s.%s is missing", ErrSchemaMismatch, stmt.Schema.Table, column)
+			}
+		}
+		for _, relationship := range stmt.Schema.Relationships.Relations {
+			if relationship.JoinTable != nil && !migrator.HasTable(relationship.JoinTable.Table) {
+				return fmt.Errorf("%w: table %s is missing", ErrSchemaMismatch, relationship.JoinTable.Table)
+			}
+		}
+	}
+	return nil
+}
+
+// appliedMigrations returns the rows of schema_migrations in order of version, or none if the table does
+// not exist yet.
+func appliedMigrations(db *gorm.DB) ([]schemaMigration, error) {
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return nil, nil
+	}
+	var applied []schemaMigration
+	if err := db.Order("version").Find(&applied).Error; err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// checkKnown returns an error if a database has had migrations applied that are not in All, meaning a newer
+// build migrated it.
+func checkKnown(applied []schemaMigration) error {
+	known := make(map[int]bool, len(All))
+	for _, migration := range All {
+		known[migration.Version] = true
+	}
+	var unknown []int
+	for _, migration := range applied {
+		if !known[migration.Version] {
+			unknown = append(unknown, migration.Version)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Ints(unknown)
+		return fmt.Errorf("%w: database has unknown migrations %v applied, it was migrated by a newer build", ErrSchemaMismatch, unknown)
+	}
+	return nil
+}
+
+// run runs one direction of a migration and records it in schema_migrations, in a single transaction.
+func run(db *gorm.DB, migration Migration, step func(tx *gorm.DB) error, up bool) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := step(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d %s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	var err error
+	if up {
+		err = tx.Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}).Error
+	} else {
+		err = tx.Where("version = ?", migration.Version).Delete(&schemaMigration{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record migration %d %s: %w", migration.Version, migration.Name, err)
+	}
+
+	// Commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}